@@ -12,7 +12,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
 	"github.com/slok/go-http-metrics/middleware"
-	"github.com/slok/go-http-metrics/middleware/std"
+	chimiddleware "github.com/slok/go-http-metrics/middleware/chi"
 )
 
 const (
@@ -26,9 +26,10 @@ func main() {
 		Recorder: metrics.NewRecorder(metrics.Config{}),
 	})
 
-	// Create our router with the metrics middleware.
+	// Create our router with the metrics middleware, the requests will be
+	// measured with the route templates (e.g `/users/{id}`).
 	r := chi.NewRouter()
-	r.Use(std.HandlerProvider("", mdlw))
+	r.Use(chimiddleware.Handler("", mdlw))
 
 	// Add paths.
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +41,7 @@ func main() {
 	r.Get("/test1/test4", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNonAuthoritativeInfo) })
 	r.Get("/test2", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
 	r.Get("/test3", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusResetContent) })
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 
 	// Serve our handler.
 	go func() {
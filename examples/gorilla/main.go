@@ -12,7 +12,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
 	"github.com/slok/go-http-metrics/middleware"
-	"github.com/slok/go-http-metrics/middleware/std"
+	gorillamiddleware "github.com/slok/go-http-metrics/middleware/gorilla"
 )
 
 const (
@@ -26,9 +26,10 @@ func main() {
 		Recorder: metrics.NewRecorder(metrics.Config{}),
 	})
 
-	// Create our router with the metrics middleware.
+	// Create our router with the metrics middleware, the requests will be
+	// measured with the route templates (e.g `/users/{id}`).
 	r := mux.NewRouter()
-	r.Use(std.HandlerProvider("", mdlw))
+	r.Use(gorillamiddleware.Handler("", mdlw))
 
 	// Add paths.
 	r.Methods("GET").Path("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +41,7 @@ func main() {
 	r.Methods("GET").Path("/test1/test4").HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNonAuthoritativeInfo) })
 	r.Methods("GET").Path("/test2").HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
 	r.Methods("GET").Path("/test3").HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusResetContent) })
+	r.Methods("GET").Path("/users/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 
 	// Serve our handler.
 	go func() {
@@ -0,0 +1,416 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// OverflowPolicy is the policy used when the buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest will drop the new observation when the buffer is full.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest will drop the oldest buffered observation to make room
+	// for the new one when the buffer is full.
+	OverflowDropOldest
+	// OverflowBlock will block the caller until there is room on the buffer.
+	OverflowBlock
+)
+
+// Config has the dependencies and values of the recorder.
+type Config struct {
+	// Recorder is the wrapped recorder that will receive the observations asynchronously.
+	Recorder metrics.Recorder
+	// BufferSize is the max number of observations that can be buffered, by default is 1024.
+	BufferSize int
+	// Workers is the number of goroutines that will send the buffered observations to the
+	// wrapped recorder, by default is 1.
+	Workers int
+	// OverflowPolicy is the policy used when the buffer is full, by default drops the
+	// new observations.
+	OverflowPolicy OverflowPolicy
+}
+
+func (c *Config) defaults() error {
+	if c.Recorder == nil {
+		return errors.New("recorder is required")
+	}
+
+	if c.BufferSize <= 0 {
+		c.BufferSize = 1024
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+
+	switch c.OverflowPolicy {
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock:
+	default:
+		return fmt.Errorf("unknown overflow policy %d", c.OverflowPolicy)
+	}
+
+	return nil
+}
+
+type event struct {
+	kind     metrics.MetricKind
+	ctx      context.Context
+	props    metrics.HTTPReqProperties
+	duration time.Duration
+	size     int64
+	// flush is set on the streams events that are flushes, not the stream duration.
+	flush bool
+	// conn is set on the hijacked connections events.
+	conn metrics.HijackedConnection
+}
+
+type pendingInflight struct {
+	ctx      context.Context
+	quantity int
+}
+
+// Recorder is a metrics recorder decorator that records the observations of the wrapped
+// recorder asynchronously, so slow recorders don't block the HTTP requests.
+//
+// The request duration and response size observations are buffered in a bounded ring
+// buffer, and when full, the overflow policy is applied. The inflight requests are never
+// dropped, instead, they are accumulated by properties and sent to the wrapped recorder
+// in order by a single worker at a time, this way the wrapped recorder inflight gauges
+// never go negative.
+type Recorder struct {
+	rec        metrics.Recorder
+	reqSizeRec metrics.RequestSizeRecorder
+	ttfbRec    metrics.TimeToFirstByteRecorder
+	panicRec   metrics.PanicRecorder
+	abortRec   metrics.ClientAbortRecorder
+	streamRec  metrics.StreamRecorder
+	hijackRec  metrics.HijackRecorder
+	policy     OverflowPolicy
+
+	mu           sync.Mutex
+	notEmpty     *sync.Cond
+	notFull      *sync.Cond
+	idle         *sync.Cond
+	buf          []event
+	head         int
+	count        int
+	inflight     map[metrics.HTTPProperties]pendingInflight
+	inflightBusy bool
+	busy         int
+	dropped      uint64
+	closed       bool
+
+	wg sync.WaitGroup
+}
+
+// NewRecorder returns a new asynchronous recorder.
+func NewRecorder(cfg Config) (*Recorder, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	r := &Recorder{
+		rec:      cfg.Recorder,
+		policy:   cfg.OverflowPolicy,
+		buf:      make([]event, cfg.BufferSize),
+		inflight: map[metrics.HTTPProperties]pendingInflight{},
+	}
+	r.reqSizeRec, _ = cfg.Recorder.(metrics.RequestSizeRecorder)
+	r.ttfbRec, _ = cfg.Recorder.(metrics.TimeToFirstByteRecorder)
+	r.panicRec, _ = cfg.Recorder.(metrics.PanicRecorder)
+	r.abortRec, _ = cfg.Recorder.(metrics.ClientAbortRecorder)
+	r.streamRec, _ = cfg.Recorder.(metrics.StreamRecorder)
+	r.hijackRec, _ = cfg.Recorder.(metrics.HijackRecorder)
+	r.notEmpty = sync.NewCond(&r.mu)
+	r.notFull = sync.NewCond(&r.mu)
+	r.idle = sync.NewCond(&r.mu)
+
+	r.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go r.work()
+	}
+
+	return r, nil
+}
+
+// ObserveHTTPRequestDuration satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPRequestDuration(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.enqueue(event{kind: metrics.MetricKindRequestDuration, ctx: context.WithoutCancel(ctx), props: p, duration: duration})
+}
+
+// ObserveHTTPResponseSize satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPResponseSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.enqueue(event{kind: metrics.MetricKindResponseSize, ctx: context.WithoutCancel(ctx), props: p, size: sizeBytes})
+}
+
+// ObserveHTTPRequestSize satisfies metrics.RequestSizeRecorder interface. The observations
+// are ignored if the wrapped recorder doesn't measure request sizes.
+func (r *Recorder) ObserveHTTPRequestSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	if r.reqSizeRec == nil {
+		return
+	}
+	r.enqueue(event{kind: metrics.MetricKindRequestSize, ctx: context.WithoutCancel(ctx), props: p, size: sizeBytes})
+}
+
+// ObserveHTTPTimeToFirstByte satisfies metrics.TimeToFirstByteRecorder interface. The observations
+// are ignored if the wrapped recorder doesn't measure the time to first byte.
+func (r *Recorder) ObserveHTTPTimeToFirstByte(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	if r.ttfbRec == nil {
+		return
+	}
+	r.enqueue(event{kind: metrics.MetricKindTimeToFirstByte, ctx: context.WithoutCancel(ctx), props: p, duration: duration})
+}
+
+// IncHTTPPanics satisfies metrics.PanicRecorder interface. The panics are ignored if
+// the wrapped recorder doesn't count them.
+func (r *Recorder) IncHTTPPanics(ctx context.Context, p metrics.HTTPProperties) {
+	if r.panicRec == nil {
+		return
+	}
+	r.enqueue(event{kind: metrics.MetricKindPanics, ctx: context.WithoutCancel(ctx), props: metrics.HTTPReqProperties{Service: p.Service, ID: p.ID, Labels: p.Labels}})
+}
+
+// IncHTTPClientAborts satisfies metrics.ClientAbortRecorder interface. The client aborts are
+// ignored if the wrapped recorder doesn't count them.
+func (r *Recorder) IncHTTPClientAborts(ctx context.Context, p metrics.HTTPProperties) {
+	if r.abortRec == nil {
+		return
+	}
+	r.enqueue(event{kind: metrics.MetricKindClientAborts, ctx: context.WithoutCancel(ctx), props: metrics.HTTPReqProperties{Service: p.Service, ID: p.ID, Labels: p.Labels}})
+}
+
+// ObserveHTTPStreamFlush satisfies metrics.StreamRecorder interface. The observations are
+// ignored if the wrapped recorder doesn't measure the streams.
+func (r *Recorder) ObserveHTTPStreamFlush(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64, interval time.Duration) {
+	if r.streamRec == nil {
+		return
+	}
+	r.enqueue(event{kind: metrics.MetricKindStreams, ctx: context.WithoutCancel(ctx), props: p, size: sizeBytes, duration: interval, flush: true})
+}
+
+// ObserveHTTPStreamDuration satisfies metrics.StreamRecorder interface. The observations are
+// ignored if the wrapped recorder doesn't measure the streams.
+func (r *Recorder) ObserveHTTPStreamDuration(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	if r.streamRec == nil {
+		return
+	}
+	r.enqueue(event{kind: metrics.MetricKindStreams, ctx: context.WithoutCancel(ctx), props: p, duration: duration})
+}
+
+// AddHijackedConnections satisfies metrics.HijackRecorder interface. Like the inflight requests,
+// the open hijacked connections are never dropped, they are sent directly to the wrapped recorder
+// (the hijacked connections are not frequent enough to block the requests). The connections are
+// ignored if the wrapped recorder doesn't measure the hijacked connections.
+func (r *Recorder) AddHijackedConnections(ctx context.Context, p metrics.HTTPProperties, quantity int) {
+	if r.hijackRec == nil {
+		return
+	}
+	r.hijackRec.AddHijackedConnections(context.WithoutCancel(ctx), p, quantity)
+}
+
+// ObserveHTTPHijackedConnection satisfies metrics.HijackRecorder interface. The observations are
+// ignored if the wrapped recorder doesn't measure the hijacked connections.
+func (r *Recorder) ObserveHTTPHijackedConnection(ctx context.Context, p metrics.HTTPReqProperties, conn metrics.HijackedConnection) {
+	if r.hijackRec == nil {
+		return
+	}
+	r.enqueue(event{kind: metrics.MetricKindHijackedConnections, ctx: context.WithoutCancel(ctx), props: p, conn: conn})
+}
+
+// AddInflightRequests satisfies metrics.Recorder interface. After closing, the inflight
+// requests are sent directly to the wrapped recorder once the workers have sent the pending
+// ones, so the requests that were inflight when closing are still decremented in order.
+func (r *Recorder) AddInflightRequests(ctx context.Context, p metrics.HTTPProperties, quantity int) {
+	r.mu.Lock()
+	if r.closed {
+		for len(r.inflight) > 0 || r.inflightBusy {
+			r.idle.Wait()
+		}
+		r.mu.Unlock()
+		r.rec.AddInflightRequests(ctx, p, quantity)
+		return
+	}
+	defer r.mu.Unlock()
+
+	pi := r.inflight[p]
+	pi.ctx = context.WithoutCancel(ctx)
+	pi.quantity += quantity
+	r.inflight[p] = pi
+	r.notEmpty.Signal()
+}
+
+// Dropped returns the number of dropped observations.
+func (r *Recorder) Dropped() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.dropped
+}
+
+// Flush blocks until all the buffered observations have been sent to the wrapped recorder.
+func (r *Recorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for !r.isIdle() {
+		r.idle.Wait()
+	}
+}
+
+// Close stops the recorder after sending all the buffered observations to the wrapped
+// recorder. The observations received after closing will be dropped, except the inflight
+// requests that are sent directly to the wrapped recorder.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.notEmpty.Broadcast()
+	r.notFull.Broadcast()
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+func (r *Recorder) enqueue(e event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		r.dropped++
+		return
+	}
+
+	if r.count == len(r.buf) {
+		switch r.policy {
+		case OverflowDropNewest:
+			r.dropped++
+			return
+		case OverflowDropOldest:
+			r.pop()
+			r.dropped++
+		case OverflowBlock:
+			for r.count == len(r.buf) && !r.closed {
+				r.notFull.Wait()
+			}
+			if r.closed {
+				r.dropped++
+				return
+			}
+		}
+	}
+
+	r.buf[(r.head+r.count)%len(r.buf)] = e
+	r.count++
+	r.notEmpty.Signal()
+}
+
+func (r *Recorder) pop() event {
+	e := r.buf[r.head]
+	r.buf[r.head] = event{}
+	r.head = (r.head + 1) % len(r.buf)
+	r.count--
+
+	return e
+}
+
+func (r *Recorder) hasInflight() bool {
+	return len(r.inflight) > 0 && !r.inflightBusy
+}
+
+func (r *Recorder) isIdle() bool {
+	return r.count == 0 && len(r.inflight) == 0 && r.busy == 0
+}
+
+func (r *Recorder) work() {
+	defer r.wg.Done()
+
+	for {
+		r.mu.Lock()
+		for r.count == 0 && !r.hasInflight() && !r.closed {
+			r.notEmpty.Wait()
+		}
+
+		switch {
+		// Only one worker at a time sends the inflights, this keeps the order of
+		// the increments and decrements.
+		case r.hasInflight():
+			pending := r.inflight
+			r.inflight = map[metrics.HTTPProperties]pendingInflight{}
+			r.inflightBusy = true
+			r.busy++
+			r.mu.Unlock()
+
+			for p, pi := range pending {
+				if pi.quantity != 0 {
+					r.rec.AddInflightRequests(pi.ctx, p, pi.quantity)
+				}
+			}
+
+			r.mu.Lock()
+			r.inflightBusy = false
+			// Other workers could be waiting for the inflights.
+			r.notEmpty.Broadcast()
+		case r.count > 0:
+			e := r.pop()
+			r.busy++
+			r.notFull.Signal()
+			r.mu.Unlock()
+
+			r.record(e)
+
+			r.mu.Lock()
+		default:
+			// Closed and nothing left to send.
+			r.mu.Unlock()
+			return
+		}
+
+		r.busy--
+		if r.isIdle() {
+			r.idle.Broadcast()
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *Recorder) record(e event) {
+	switch e.kind {
+	case metrics.MetricKindRequestDuration:
+		r.rec.ObserveHTTPRequestDuration(e.ctx, e.props, e.duration)
+	case metrics.MetricKindResponseSize:
+		r.rec.ObserveHTTPResponseSize(e.ctx, e.props, e.size)
+	case metrics.MetricKindRequestSize:
+		r.reqSizeRec.ObserveHTTPRequestSize(e.ctx, e.props, e.size)
+	case metrics.MetricKindTimeToFirstByte:
+		r.ttfbRec.ObserveHTTPTimeToFirstByte(e.ctx, e.props, e.duration)
+	case metrics.MetricKindPanics:
+		r.panicRec.IncHTTPPanics(e.ctx, metrics.HTTPProperties{Service: e.props.Service, ID: e.props.ID, Labels: e.props.Labels})
+	case metrics.MetricKindClientAborts:
+		r.abortRec.IncHTTPClientAborts(e.ctx, metrics.HTTPProperties{Service: e.props.Service, ID: e.props.ID, Labels: e.props.Labels})
+	case metrics.MetricKindStreams:
+		if e.flush {
+			r.streamRec.ObserveHTTPStreamFlush(e.ctx, e.props, e.size, e.duration)
+			return
+		}
+		r.streamRec.ObserveHTTPStreamDuration(e.ctx, e.props, e.duration)
+	case metrics.MetricKindHijackedConnections:
+		r.hijackRec.ObserveHTTPHijackedConnection(e.ctx, e.props, e.conn)
+	}
+}
+
+var (
+	_ metrics.Recorder                = &Recorder{}
+	_ metrics.RequestSizeRecorder     = &Recorder{}
+	_ metrics.TimeToFirstByteRecorder = &Recorder{}
+	_ metrics.PanicRecorder           = &Recorder{}
+	_ metrics.ClientAbortRecorder     = &Recorder{}
+	_ metrics.StreamRecorder          = &Recorder{}
+	_ metrics.HijackRecorder          = &Recorder{}
+)
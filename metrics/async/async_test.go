@@ -0,0 +1,282 @@
+package async_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/async"
+	"github.com/slok/go-http-metrics/metrics/memory"
+)
+
+// gatedRecorder blocks the request duration observations until the gate is opened.
+type gatedRecorder struct {
+	*memory.Recorder
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func newGatedRecorder() *gatedRecorder {
+	return &gatedRecorder{
+		Recorder: memory.NewRecorder(memory.Config{}),
+		started:  make(chan struct{}, 100),
+		gate:     make(chan struct{}),
+	}
+}
+
+func (g *gatedRecorder) ObserveHTTPRequestDuration(ctx context.Context, p metrics.HTTPReqProperties, d time.Duration) {
+	g.started <- struct{}{}
+	<-g.gate
+	g.Recorder.ObserveHTTPRequestDuration(ctx, p, d)
+}
+
+func durations(r *memory.Recorder) []float64 {
+	res := []float64{}
+	for _, o := range r.Observations() {
+		if o.Metric == memory.MetricRequestDuration {
+			res = append(res, o.Value)
+		}
+	}
+	return res
+}
+
+func TestAsyncRecorder(t *testing.T) {
+	props := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}
+
+	tests := map[string]struct {
+		policy       async.OverflowPolicy
+		expDurations []float64
+		expDropped   uint64
+	}{
+		"Dropping the newest observations should drop the new observations when the buffer is full.": {
+			policy:       async.OverflowDropNewest,
+			expDurations: []float64{1, 2, 3},
+			expDropped:   1,
+		},
+
+		"Dropping the oldest observations should drop the oldest buffered observations when the buffer is full.": {
+			policy:       async.OverflowDropOldest,
+			expDurations: []float64{1, 3, 4},
+			expDropped:   1,
+		},
+
+		"Blocking should wait until there is room on the buffer.": {
+			policy:       async.OverflowBlock,
+			expDurations: []float64{1, 2, 3, 4},
+			expDropped:   0,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			grec := newGatedRecorder()
+			r, err := async.NewRecorder(async.Config{
+				Recorder:       grec,
+				BufferSize:     2,
+				OverflowPolicy: test.policy,
+			})
+			require.NoError(err)
+			defer r.Close()
+
+			// Wait until the worker is blocked with the first one, so the buffer can be filled.
+			r.ObserveHTTPRequestDuration(context.TODO(), props, 1*time.Second)
+			<-grec.started
+			r.ObserveHTTPRequestDuration(context.TODO(), props, 2*time.Second)
+			r.ObserveHTTPRequestDuration(context.TODO(), props, 3*time.Second)
+
+			// Buffer is full.
+			done := make(chan struct{})
+			go func() {
+				r.ObserveHTTPRequestDuration(context.TODO(), props, 4*time.Second)
+				close(done)
+			}()
+			if test.policy == async.OverflowBlock {
+				select {
+				case <-done:
+					assert.Fail("the observation should block while the buffer is full")
+				case <-time.After(50 * time.Millisecond):
+				}
+			} else {
+				<-done
+			}
+
+			// Unblock the wrapped recorder and wait until everything has been sent.
+			close(grec.gate)
+			<-done
+			r.Flush()
+
+			assert.Equal(test.expDurations, durations(grec.Recorder))
+			assert.Equal(test.expDropped, r.Dropped())
+		})
+	}
+}
+
+// minInflightRecorder tracks the min value the inflight requests have had.
+type minInflightRecorder struct {
+	metrics.Recorder
+	mu          sync.Mutex
+	inflight    int
+	minInflight int
+}
+
+func (m *minInflightRecorder) AddInflightRequests(_ context.Context, _ metrics.HTTPProperties, quantity int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	time.Sleep(time.Microsecond)
+	m.inflight += quantity
+	if m.inflight < m.minInflight {
+		m.minInflight = m.inflight
+	}
+}
+
+func TestAsyncRecorderInflightOrder(t *testing.T) {
+	require := require.New(t)
+
+	mrec := &minInflightRecorder{Recorder: metrics.Dummy}
+	r, err := async.NewRecorder(async.Config{
+		Recorder:   mrec,
+		BufferSize: 1,
+		Workers:    8,
+	})
+	require.NoError(err)
+
+	props := metrics.HTTPProperties{Service: "svc1", ID: "test1"}
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.AddInflightRequests(context.TODO(), props, 1)
+			r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{}, time.Second)
+			r.AddInflightRequests(context.TODO(), props, -1)
+		}()
+	}
+	wg.Wait()
+	r.Close()
+
+	// Inflights are never dropped and never go negative.
+	assert.Equal(t, 0, mrec.inflight)
+	assert.Equal(t, 0, mrec.minInflight)
+}
+
+func TestAsyncRecorderClose(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	r, err := async.NewRecorder(async.Config{Recorder: mrec})
+	require.NoError(err)
+
+	props := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}
+	r.ObserveHTTPRequestDuration(context.TODO(), props, time.Second)
+	r.ObserveHTTPResponseSize(context.TODO(), props, 42)
+	r.ObserveHTTPRequestSize(context.TODO(), props, 7)
+	r.ObserveHTTPTimeToFirstByte(context.TODO(), props, 2*time.Second)
+	r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 3)
+
+	// Closing should send the buffered observations.
+	r.Close()
+	assert.Equal(1, mrec.RequestCount(memory.Query{}))
+	assert.Equal(memory.Aggregate{Count: 1, Sum: 42, Min: 42, Max: 42}, mrec.ResponseSize(memory.Query{}))
+	assert.Equal(memory.Aggregate{Count: 1, Sum: 7, Min: 7, Max: 7}, mrec.RequestSize(memory.Query{}))
+	assert.Equal(memory.Aggregate{Count: 1, Sum: 2, Min: 2, Max: 2}, mrec.TimeToFirstByte(memory.Query{}))
+	assert.Equal(3, mrec.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: "test1"}))
+
+	// After closing, the observations should be dropped.
+	r.ObserveHTTPRequestDuration(context.TODO(), props, time.Second)
+	assert.Equal(1, mrec.RequestCount(memory.Query{}))
+	assert.Equal(uint64(1), r.Dropped())
+
+	// After closing, the inflight requests should be sent directly.
+	r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, -3)
+	assert.Equal(0, mrec.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: "test1"}))
+	assert.Equal(uint64(1), r.Dropped())
+}
+
+// gatedInflightRecorder blocks the first inflight requests until the gate is opened.
+type gatedInflightRecorder struct {
+	*minInflightRecorder
+	gated   atomic.Bool
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedInflightRecorder) AddInflightRequests(ctx context.Context, p metrics.HTTPProperties, quantity int) {
+	if g.gated.CompareAndSwap(false, true) {
+		close(g.started)
+		<-g.gate
+	}
+	g.minInflightRecorder.AddInflightRequests(ctx, p, quantity)
+}
+
+func TestAsyncRecorderInflightOrderOnClose(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mrec := &gatedInflightRecorder{
+		minInflightRecorder: &minInflightRecorder{Recorder: metrics.Dummy},
+		started:             make(chan struct{}),
+		gate:                make(chan struct{}),
+	}
+	r, err := async.NewRecorder(async.Config{Recorder: mrec})
+	require.NoError(err)
+
+	// The worker is sending the inflight request while closing.
+	props := metrics.HTTPProperties{Service: "svc1", ID: "test1"}
+	r.AddInflightRequests(context.TODO(), props, 1)
+	<-mrec.started
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	require.Eventually(func() bool {
+		r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{}, time.Second)
+		return r.Dropped() > 0
+	}, time.Second, time.Millisecond)
+
+	// The inflight requests after closing should wait for the pending ones.
+	decremented := make(chan struct{})
+	go func() {
+		r.AddInflightRequests(context.TODO(), props, -1)
+		close(decremented)
+	}()
+	select {
+	case <-decremented:
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(mrec.gate)
+	<-decremented
+	<-closed
+
+	assert.Equal(0, mrec.inflight)
+	assert.Equal(0, mrec.minInflight)
+}
+
+func TestAsyncRecorderPanicsAndClientAborts(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	r, err := async.NewRecorder(async.Config{Recorder: mrec})
+	require.NoError(err)
+	defer r.Close()
+
+	props := metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: metrics.NewLabels(map[string]string{"version": "v1"})}
+	r.IncHTTPPanics(context.TODO(), props)
+	r.IncHTTPClientAborts(context.TODO(), props)
+	r.IncHTTPClientAborts(context.TODO(), props)
+	r.Flush()
+
+	assert.Equal(1, mrec.Panics(props))
+	assert.Equal(2, mrec.ClientAborts(props))
+}
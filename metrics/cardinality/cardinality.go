@@ -0,0 +1,369 @@
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// Label is a label of the HTTP properties that can be limited.
+type Label string
+
+const (
+	// LabelID is the handler ID label.
+	LabelID Label = "handler"
+	// LabelMethod is the method label.
+	LabelMethod Label = "method"
+	// LabelCode is the status code label.
+	LabelCode Label = "code"
+)
+
+// FoldedValue is a label value that has been folded into the overflow value.
+type FoldedValue struct {
+	// Label is the label of the folded value.
+	Label Label
+	// Service is the service of the observation that had the folded value.
+	Service string
+	// Value is the original value.
+	Value string
+	// Count is the number of times the value has been folded.
+	Count uint64
+}
+
+// Config has the dependencies and values of the recorder.
+type Config struct {
+	// Recorder is the wrapped recorder that will receive the limited observations.
+	Recorder metrics.Recorder
+	// MaxHandlerIDs is the max number of distinct handler IDs for all the services, by default is unlimited.
+	MaxHandlerIDs int
+	// MaxHandlerIDsPerService is the max number of distinct handler IDs on each service, by default is unlimited.
+	MaxHandlerIDsPerService int
+	// MaxMethods is the max number of distinct methods, by default is unlimited.
+	MaxMethods int
+	// MaxCodes is the max number of distinct status codes, by default is unlimited.
+	MaxCodes int
+	// OverflowValue is the value set to the labels that exceed the limits, by default is `__overflow__`.
+	OverflowValue string
+	// MaxFoldedValues is the max number of distinct folded values that will be tracked to be
+	// reported, by default is 100.
+	MaxFoldedValues int
+}
+
+func (c *Config) defaults() error {
+	if c.Recorder == nil {
+		return errors.New("recorder is required")
+	}
+
+	if c.OverflowValue == "" {
+		c.OverflowValue = "__overflow__"
+	}
+
+	if c.MaxFoldedValues <= 0 {
+		c.MaxFoldedValues = 100
+	}
+
+	return nil
+}
+
+// Recorder is a metrics recorder decorator that limits the cardinality of the labels.
+//
+// The first distinct values of each label are allowed until the limit is reached,
+// after that, the new values are folded into the overflow value. Allowed values are never
+// evicted, so the same value is always folded or not, this keeps the inflight
+// increments and decrements balanced.
+type Recorder struct {
+	rec           metrics.Recorder
+	reqSizeRec    metrics.RequestSizeRecorder
+	ttfbRec       metrics.TimeToFirstByteRecorder
+	panicRec      metrics.PanicRecorder
+	abortRec      metrics.ClientAbortRecorder
+	streamRec     metrics.StreamRecorder
+	hijackRec     metrics.HijackRecorder
+	overflowValue string
+
+	ids             *limiter
+	idsPerService   sync.Map // service -> *limiter.
+	maxIDsPerSvc    int
+	methods         *limiter
+	codes           *limiter
+	maxFoldedValues int64
+	foldedValues    sync.Map // foldedKey -> *atomic.Uint64.
+	foldedCount     atomic.Int64
+}
+
+// NewRecorder returns a new cardinality limiter recorder.
+func NewRecorder(cfg Config) (*Recorder, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	reqSizeRec, _ := cfg.Recorder.(metrics.RequestSizeRecorder)
+	ttfbRec, _ := cfg.Recorder.(metrics.TimeToFirstByteRecorder)
+	panicRec, _ := cfg.Recorder.(metrics.PanicRecorder)
+	abortRec, _ := cfg.Recorder.(metrics.ClientAbortRecorder)
+	streamRec, _ := cfg.Recorder.(metrics.StreamRecorder)
+	hijackRec, _ := cfg.Recorder.(metrics.HijackRecorder)
+
+	return &Recorder{
+		rec:             cfg.Recorder,
+		reqSizeRec:      reqSizeRec,
+		ttfbRec:         ttfbRec,
+		panicRec:        panicRec,
+		abortRec:        abortRec,
+		streamRec:       streamRec,
+		hijackRec:       hijackRec,
+		overflowValue:   cfg.OverflowValue,
+		ids:             newLimiter(cfg.MaxHandlerIDs),
+		maxIDsPerSvc:    cfg.MaxHandlerIDsPerService,
+		methods:         newLimiter(cfg.MaxMethods),
+		codes:           newLimiter(cfg.MaxCodes),
+		maxFoldedValues: int64(cfg.MaxFoldedValues),
+	}, nil
+}
+
+// ObserveHTTPRequestDuration satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPRequestDuration(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.rec.ObserveHTTPRequestDuration(ctx, r.limitHTTPReqProperties(p), duration)
+}
+
+// ObserveHTTPResponseSize satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPResponseSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.rec.ObserveHTTPResponseSize(ctx, r.limitHTTPReqProperties(p), sizeBytes)
+}
+
+// ObserveHTTPRequestSize satisfies metrics.RequestSizeRecorder interface. The observations
+// are ignored if the wrapped recorder doesn't measure request sizes.
+func (r *Recorder) ObserveHTTPRequestSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	if r.reqSizeRec == nil {
+		return
+	}
+	r.reqSizeRec.ObserveHTTPRequestSize(ctx, r.limitHTTPReqProperties(p), sizeBytes)
+}
+
+// ObserveHTTPTimeToFirstByte satisfies metrics.TimeToFirstByteRecorder interface. The observations
+// are ignored if the wrapped recorder doesn't measure the time to first byte.
+func (r *Recorder) ObserveHTTPTimeToFirstByte(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	if r.ttfbRec == nil {
+		return
+	}
+	r.ttfbRec.ObserveHTTPTimeToFirstByte(ctx, r.limitHTTPReqProperties(p), duration)
+}
+
+// AddInflightRequests satisfies metrics.Recorder interface.
+func (r *Recorder) AddInflightRequests(ctx context.Context, p metrics.HTTPProperties, quantity int) {
+	p.ID = r.limitID(p.Service, p.ID)
+	r.rec.AddInflightRequests(ctx, p, quantity)
+}
+
+// IncHTTPPanics satisfies metrics.PanicRecorder interface. The panics are ignored if
+// the wrapped recorder doesn't count them.
+func (r *Recorder) IncHTTPPanics(ctx context.Context, p metrics.HTTPProperties) {
+	if r.panicRec == nil {
+		return
+	}
+	p.ID = r.limitID(p.Service, p.ID)
+	r.panicRec.IncHTTPPanics(ctx, p)
+}
+
+// IncHTTPClientAborts satisfies metrics.ClientAbortRecorder interface. The client aborts are
+// ignored if the wrapped recorder doesn't count them.
+func (r *Recorder) IncHTTPClientAborts(ctx context.Context, p metrics.HTTPProperties) {
+	if r.abortRec == nil {
+		return
+	}
+	p.ID = r.limitID(p.Service, p.ID)
+	r.abortRec.IncHTTPClientAborts(ctx, p)
+}
+
+// ObserveHTTPStreamFlush satisfies metrics.StreamRecorder interface. The observations are
+// ignored if the wrapped recorder doesn't measure the streams.
+func (r *Recorder) ObserveHTTPStreamFlush(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64, interval time.Duration) {
+	if r.streamRec == nil {
+		return
+	}
+	r.streamRec.ObserveHTTPStreamFlush(ctx, r.limitHTTPReqProperties(p), sizeBytes, interval)
+}
+
+// ObserveHTTPStreamDuration satisfies metrics.StreamRecorder interface. The observations are
+// ignored if the wrapped recorder doesn't measure the streams.
+func (r *Recorder) ObserveHTTPStreamDuration(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	if r.streamRec == nil {
+		return
+	}
+	r.streamRec.ObserveHTTPStreamDuration(ctx, r.limitHTTPReqProperties(p), duration)
+}
+
+// AddHijackedConnections satisfies metrics.HijackRecorder interface. The connections are
+// ignored if the wrapped recorder doesn't measure the hijacked connections.
+func (r *Recorder) AddHijackedConnections(ctx context.Context, p metrics.HTTPProperties, quantity int) {
+	if r.hijackRec == nil {
+		return
+	}
+	p.ID = r.limitID(p.Service, p.ID)
+	r.hijackRec.AddHijackedConnections(ctx, p, quantity)
+}
+
+// ObserveHTTPHijackedConnection satisfies metrics.HijackRecorder interface. The observations are
+// ignored if the wrapped recorder doesn't measure the hijacked connections.
+func (r *Recorder) ObserveHTTPHijackedConnection(ctx context.Context, p metrics.HTTPReqProperties, conn metrics.HijackedConnection) {
+	if r.hijackRec == nil {
+		return
+	}
+	r.hijackRec.ObserveHTTPHijackedConnection(ctx, r.limitHTTPReqProperties(p), conn)
+}
+
+// Folded returns the values that have been folded into the overflow value. Only the first
+// distinct folded values (up to the configured max) are reported.
+func (r *Recorder) Folded() []FoldedValue {
+	res := []FoldedValue{}
+	r.foldedValues.Range(func(k, v any) bool {
+		key := k.(foldedKey)
+		res = append(res, FoldedValue{
+			Label:   key.label,
+			Service: key.service,
+			Value:   key.value,
+			Count:   v.(*atomic.Uint64).Load(),
+		})
+		return true
+	})
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Label != res[j].Label {
+			return res[i].Label < res[j].Label
+		}
+		if res[i].Service != res[j].Service {
+			return res[i].Service < res[j].Service
+		}
+		return res[i].Value < res[j].Value
+	})
+
+	return res
+}
+
+func (r *Recorder) limitHTTPReqProperties(p metrics.HTTPReqProperties) metrics.HTTPReqProperties {
+	p.ID = r.limitID(p.Service, p.ID)
+
+	if !r.methods.allow(p.Method) {
+		r.fold(LabelMethod, p.Service, p.Method)
+		p.Method = r.overflowValue
+	}
+
+	if !r.codes.allow(p.Code) {
+		r.fold(LabelCode, p.Service, p.Code)
+		p.Code = r.overflowValue
+	}
+
+	return p
+}
+
+// limitID limits the handler IDs, the global limit is checked first, so the IDs folded by
+// the global limit don't use the slots of the per service limits.
+func (r *Recorder) limitID(service, id string) string {
+	if !r.ids.allow(id) {
+		r.fold(LabelID, service, id)
+		return r.overflowValue
+	}
+
+	if r.maxIDsPerSvc > 0 {
+		l, ok := r.idsPerService.Load(service)
+		if !ok {
+			l, _ = r.idsPerService.LoadOrStore(service, newLimiter(r.maxIDsPerSvc))
+		}
+
+		if !l.(*limiter).allow(id) {
+			r.fold(LabelID, service, id)
+			return r.overflowValue
+		}
+	}
+
+	return id
+}
+
+type foldedKey struct {
+	label   Label
+	service string
+	value   string
+}
+
+func (r *Recorder) fold(label Label, service, value string) {
+	key := foldedKey{label: label, service: service, value: value}
+
+	c, ok := r.foldedValues.Load(key)
+	if !ok {
+		if r.foldedCount.Load() >= r.maxFoldedValues {
+			return
+		}
+
+		var loaded bool
+		c, loaded = r.foldedValues.LoadOrStore(key, &atomic.Uint64{})
+		if !loaded {
+			r.foldedCount.Add(1)
+		}
+	}
+
+	c.(*atomic.Uint64).Add(1)
+}
+
+// limiter allows a max number of distinct values. The allowed values are checked
+// without locks, the lock is only used to allow new values.
+type limiter struct {
+	max     int
+	allowed sync.Map // string -> struct{}.
+	full    atomic.Bool
+	mu      sync.Mutex
+	count   int
+}
+
+func newLimiter(max int) *limiter {
+	return &limiter{max: max}
+}
+
+func (l *limiter) allow(value string) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	if _, ok := l.allowed.Load(value); ok {
+		return true
+	}
+
+	if l.full.Load() {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.allowed.Load(value); ok {
+		return true
+	}
+
+	if l.count >= l.max {
+		return false
+	}
+
+	l.allowed.Store(value, struct{}{})
+	l.count++
+	if l.count >= l.max {
+		l.full.Store(true)
+	}
+
+	return true
+}
+
+var (
+	_ metrics.Recorder                = &Recorder{}
+	_ metrics.RequestSizeRecorder     = &Recorder{}
+	_ metrics.TimeToFirstByteRecorder = &Recorder{}
+	_ metrics.PanicRecorder           = &Recorder{}
+	_ metrics.ClientAbortRecorder     = &Recorder{}
+	_ metrics.StreamRecorder          = &Recorder{}
+	_ metrics.HijackRecorder          = &Recorder{}
+)
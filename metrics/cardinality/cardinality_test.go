@@ -0,0 +1,160 @@
+package cardinality_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/cardinality"
+	"github.com/slok/go-http-metrics/metrics/memory"
+)
+
+func TestCardinalityRecorder(t *testing.T) {
+	tests := map[string]struct {
+		config     cardinality.Config
+		recordMets func(r *cardinality.Recorder)
+		expCounts  map[string]int
+		expFolded  []cardinality.FoldedValue
+	}{
+		"Without limits all the values should be allowed.": {
+			config: cardinality.Config{},
+			recordMets: func(r *cardinality.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "id1", Method: "GET", Code: "200"}, time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "id2", Method: "POST", Code: "500"}, time.Second)
+			},
+			expCounts: map[string]int{"id1": 1, "id2": 1},
+			expFolded: []cardinality.FoldedValue{},
+		},
+
+		"Handler IDs above the global limit should be folded.": {
+			config: cardinality.Config{MaxHandlerIDs: 2},
+			recordMets: func(r *cardinality.Recorder) {
+				for _, id := range []string{"id1", "id2", "id3", "id1", "id4", "id3"} {
+					r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: id, Method: "GET", Code: "200"}, time.Second)
+				}
+			},
+			expCounts: map[string]int{"id1": 2, "id2": 1, "__overflow__": 3},
+			expFolded: []cardinality.FoldedValue{
+				{Label: cardinality.LabelID, Service: "svc1", Value: "id3", Count: 2},
+				{Label: cardinality.LabelID, Service: "svc1", Value: "id4", Count: 1},
+			},
+		},
+
+		"Handler IDs above the per service limit should be folded.": {
+			config: cardinality.Config{MaxHandlerIDsPerService: 1, OverflowValue: "other"},
+			recordMets: func(r *cardinality.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "id1", Method: "GET", Code: "200"}, time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "id2", Method: "GET", Code: "200"}, time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc2", ID: "id2", Method: "GET", Code: "200"}, time.Second)
+			},
+			expCounts: map[string]int{"id1": 1, "id2": 1, "other": 1},
+			expFolded: []cardinality.FoldedValue{
+				{Label: cardinality.LabelID, Service: "svc1", Value: "id2", Count: 1},
+			},
+		},
+
+		"Handler IDs folded by the global limit shouldn't use the per service limit.": {
+			config: cardinality.Config{MaxHandlerIDs: 2, MaxHandlerIDsPerService: 2, OverflowValue: "other"},
+			recordMets: func(r *cardinality.Recorder) {
+				for _, p := range []metrics.HTTPReqProperties{
+					{Service: "svc1", ID: "id1", Method: "GET", Code: "200"},
+					{Service: "svc1", ID: "id2", Method: "GET", Code: "200"},
+					{Service: "svc2", ID: "id3", Method: "GET", Code: "200"},
+					{Service: "svc2", ID: "id1", Method: "GET", Code: "200"},
+					{Service: "svc2", ID: "id2", Method: "GET", Code: "200"},
+				} {
+					r.ObserveHTTPRequestDuration(context.TODO(), p, time.Second)
+				}
+			},
+			expCounts: map[string]int{"id1": 2, "id2": 2, "other": 1},
+			expFolded: []cardinality.FoldedValue{
+				{Label: cardinality.LabelID, Service: "svc2", Value: "id3", Count: 1},
+			},
+		},
+
+		"Methods and codes above the limits should be folded.": {
+			config: cardinality.Config{MaxMethods: 1, MaxCodes: 1},
+			recordMets: func(r *cardinality.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "id1", Method: "GET", Code: "200"}, time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "id1", Method: "BREW", Code: "418"}, time.Second)
+				r.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "id1", Method: "BREW", Code: "418"}, 10)
+			},
+			expCounts: map[string]int{"id1": 2},
+			expFolded: []cardinality.FoldedValue{
+				{Label: cardinality.LabelCode, Service: "svc1", Value: "418", Count: 2},
+				{Label: cardinality.LabelMethod, Service: "svc1", Value: "BREW", Count: 2},
+			},
+		},
+
+		"The folded values report should be bounded.": {
+			config: cardinality.Config{MaxHandlerIDs: 1, MaxFoldedValues: 1},
+			recordMets: func(r *cardinality.Recorder) {
+				for _, id := range []string{"id1", "id2", "id3", "id2"} {
+					r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: id, Method: "GET", Code: "200"}, time.Second)
+				}
+			},
+			expCounts: map[string]int{"id1": 1, "__overflow__": 3},
+			expFolded: []cardinality.FoldedValue{
+				{Label: cardinality.LabelID, Service: "svc1", Value: "id2", Count: 2},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			rec, err := cardinality.NewRecorder(test.config)
+			require.NoError(err)
+
+			test.recordMets(rec)
+
+			gotCounts := map[string]int{}
+			for id, agg := range mrec.RequestDurationBy(memory.LabelID, memory.Query{}) {
+				gotCounts[id] = agg.Count
+			}
+			assert.Equal(test.expCounts, gotCounts)
+			assert.Equal(test.expFolded, rec.Folded())
+		})
+	}
+}
+
+func TestCardinalityRecorderInflightBalanced(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	rec, err := cardinality.NewRecorder(cardinality.Config{Recorder: mrec, MaxHandlerIDs: 5})
+	require.NoError(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := metrics.HTTPProperties{Service: "svc1", ID: fmt.Sprintf("id%d", i%10)}
+			rec.AddInflightRequests(context.TODO(), p, 1)
+			rec.AddInflightRequests(context.TODO(), p, -1)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(0, mrec.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: "__overflow__"}))
+	for i := 0; i < 10; i++ {
+		assert.Equal(0, mrec.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: fmt.Sprintf("id%d", i)}))
+	}
+}
+
+func TestCardinalityRecorderRequiresRecorder(t *testing.T) {
+	_, err := cardinality.NewRecorder(cardinality.Config{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package expvar
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// Config has the dependencies and values of the recorder.
+type Config struct {
+	// Name is the name used to publish the metrics on expvar, by default is `http_metrics`.
+	Name string
+	// DurationBuckets are the buckets in seconds used to measure the request duration,
+	// by default uses the same default buckets as Prometheus.
+	DurationBuckets []float64
+}
+
+func (c *Config) defaults() {
+	if c.Name == "" {
+		c.Name = "http_metrics"
+	}
+
+	if len(c.DurationBuckets) == 0 {
+		c.DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	}
+}
+
+// Recorder is a recorder that publishes the metrics using the standard library
+// expvar package, as nested maps by service and handler ID:
+//
+//	{
+//	  "<service>": {
+//	    "<handler>": {
+//	      "requests": 10,
+//	      "codes": {"200": 9, "500": 1},
+//	      "methods": {"GET": 10},
+//	      "duration_seconds": {"buckets": {"0.005": 2, ..., "+Inf": 10}, "count": 10, "sum": 0.52},
+//	      "response_bytes": 1024,
+//	      "request_bytes": 512,
+//	      "inflight": 1
+//	    }
+//	  }
+//	}
+//
+// The duration buckets are cumulative, like Prometheus histograms.
+type Recorder struct {
+	root        *expvar.Map
+	buckets     []float64
+	bucketNames []string
+
+	mu       sync.Mutex
+	handlers sync.Map // metrics.HTTPProperties -> *handlerVars.
+}
+
+type handlerVars struct {
+	requests      *expvar.Int
+	codes         *expvar.Map
+	methods       *expvar.Map
+	buckets       []*expvar.Int
+	infBucket     *expvar.Int
+	durationSum   *expvar.Float
+	durationCount *expvar.Int
+	responseBytes *expvar.Int
+	requestBytes  *expvar.Int
+	inflight      *expvar.Int
+}
+
+// NewRecorder returns a new metrics recorder that publishes the metrics on expvar.
+// It will fail if there is already a published variable with the same name.
+func NewRecorder(cfg Config) (*Recorder, error) {
+	cfg.defaults()
+
+	if expvar.Get(cfg.Name) != nil {
+		return nil, fmt.Errorf("expvar %q is already published", cfg.Name)
+	}
+
+	bucketNames := make([]string, 0, len(cfg.DurationBuckets))
+	for _, b := range cfg.DurationBuckets {
+		bucketNames = append(bucketNames, strconv.FormatFloat(b, 'g', -1, 64))
+	}
+
+	return &Recorder{
+		root:        expvar.NewMap(cfg.Name),
+		buckets:     cfg.DurationBuckets,
+		bucketNames: bucketNames,
+	}, nil
+}
+
+// ObserveHTTPRequestDuration satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPRequestDuration(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	h := r.handlerVars(metrics.HTTPProperties{Service: p.Service, ID: p.ID})
+	h.requests.Add(1)
+	h.codes.Add(p.Code, 1)
+	h.methods.Add(p.Method, 1)
+
+	secs := duration.Seconds()
+	for i, b := range r.buckets {
+		if secs <= b {
+			h.buckets[i].Add(1)
+		}
+	}
+	h.infBucket.Add(1)
+	h.durationSum.Add(secs)
+	h.durationCount.Add(1)
+}
+
+// ObserveHTTPResponseSize satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPResponseSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	h := r.handlerVars(metrics.HTTPProperties{Service: p.Service, ID: p.ID})
+	h.responseBytes.Add(sizeBytes)
+}
+
+// ObserveHTTPRequestSize satisfies metrics.RequestSizeRecorder interface.
+func (r *Recorder) ObserveHTTPRequestSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	h := r.handlerVars(metrics.HTTPProperties{Service: p.Service, ID: p.ID})
+	h.requestBytes.Add(sizeBytes)
+}
+
+// AddInflightRequests satisfies metrics.Recorder interface.
+func (r *Recorder) AddInflightRequests(_ context.Context, p metrics.HTTPProperties, quantity int) {
+	h := r.handlerVars(metrics.HTTPProperties{Service: p.Service, ID: p.ID})
+	h.inflight.Add(int64(quantity))
+}
+
+// handlerVars returns the variables of a handler, creating and publishing them if
+// they don't exist. The extra labels are not published, so the properties must only
+// have the service and the handler ID.
+func (r *Recorder) handlerVars(p metrics.HTTPProperties) *handlerVars {
+	if h, ok := r.handlers.Load(p); ok {
+		return h.(*handlerVars)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if h, ok := r.handlers.Load(p); ok {
+		return h.(*handlerVars)
+	}
+
+	h := &handlerVars{
+		requests:      new(expvar.Int),
+		codes:         new(expvar.Map).Init(),
+		methods:       new(expvar.Map).Init(),
+		durationSum:   new(expvar.Float),
+		durationCount: new(expvar.Int),
+		infBucket:     new(expvar.Int),
+		responseBytes: new(expvar.Int),
+		requestBytes:  new(expvar.Int),
+		inflight:      new(expvar.Int),
+	}
+
+	buckets := new(expvar.Map).Init()
+	for _, name := range r.bucketNames {
+		b := new(expvar.Int)
+		h.buckets = append(h.buckets, b)
+		buckets.Set(name, b)
+	}
+	buckets.Set(strconv.FormatFloat(math.Inf(1), 'g', -1, 64), h.infBucket)
+
+	duration := new(expvar.Map).Init()
+	duration.Set("buckets", buckets)
+	duration.Set("sum", h.durationSum)
+	duration.Set("count", h.durationCount)
+
+	handler := new(expvar.Map).Init()
+	handler.Set("requests", h.requests)
+	handler.Set("codes", h.codes)
+	handler.Set("methods", h.methods)
+	handler.Set("duration_seconds", duration)
+	handler.Set("response_bytes", h.responseBytes)
+	handler.Set("request_bytes", h.requestBytes)
+	handler.Set("inflight", h.inflight)
+
+	service, ok := r.root.Get(p.Service).(*expvar.Map)
+	if !ok {
+		service = new(expvar.Map).Init()
+		r.root.Set(p.Service, service)
+	}
+	service.Set(p.ID, handler)
+
+	r.handlers.Store(p, h)
+
+	return h
+}
+
+var (
+	_ metrics.Recorder            = &Recorder{}
+	_ metrics.RequestSizeRecorder = &Recorder{}
+)
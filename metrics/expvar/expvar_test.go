@@ -0,0 +1,146 @@
+package expvar_test
+
+import (
+	"context"
+	"encoding/json"
+	stdexpvar "expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/expvar"
+)
+
+func TestExpvarRecorder(t *testing.T) {
+	tests := map[string]struct {
+		config     expvar.Config
+		recordMets func(r *expvar.Recorder)
+		expMetrics string
+	}{
+		"Default configuration should publish the metrics by service and handler.": {
+			config: expvar.Config{Name: "test_default"},
+			recordMets: func(r *expvar.Recorder) {
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 5*time.Millisecond)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "POST", Code: "500"}, 20*time.Second)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 100)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "POST", Code: "500"}, 5)
+				r.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "POST", Code: "500"}, 512)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc2", ID: "test2"}, 3)
+			},
+			expMetrics: `{
+				"svc1": {
+					"test1": {
+						"codes": {"200": 1, "500": 1},
+						"duration_seconds": {
+							"buckets": {"0.005": 1, "0.01": 1, "0.025": 1, "0.05": 1, "0.1": 1, "0.25": 1, "0.5": 1, "1": 1, "2.5": 1, "5": 1, "10": 1, "+Inf": 2},
+							"count": 2,
+							"sum": 20.005
+						},
+						"inflight": 1,
+						"methods": {"GET": 1, "POST": 1},
+						"request_bytes": 512,
+						"requests": 2,
+						"response_bytes": 105
+					}
+				},
+				"svc2": {
+					"test2": {
+						"codes": {},
+						"duration_seconds": {
+							"buckets": {"0.005": 0, "0.01": 0, "0.025": 0, "0.05": 0, "0.1": 0, "0.25": 0, "0.5": 0, "1": 0, "2.5": 0, "5": 0, "10": 0, "+Inf": 0},
+							"count": 0,
+							"sum": 0
+						},
+						"inflight": 3,
+						"methods": {},
+						"request_bytes": 0,
+						"requests": 0,
+						"response_bytes": 0
+					}
+				}
+			}`,
+		},
+
+		"Extra labels should be ignored, measuring the metrics by service and handler.": {
+			config: expvar.Config{Name: "test_extra_labels"},
+			recordMets: func(r *expvar.Recorder) {
+				labels := metrics.NewLabels(map[string]string{"version": "v1"})
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200", Labels: labels}, 50*time.Millisecond)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: labels}, 1)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200", Labels: labels}, 10)
+			},
+			expMetrics: `{
+				"svc1": {
+					"test1": {
+						"codes": {"200": 1},
+						"duration_seconds": {
+							"buckets": {"0.005": 0, "0.01": 0, "0.025": 0, "0.05": 1, "0.1": 1, "0.25": 1, "0.5": 1, "1": 1, "2.5": 1, "5": 1, "10": 1, "+Inf": 1},
+							"count": 1,
+							"sum": 0.05
+						},
+						"inflight": 2,
+						"methods": {"GET": 1},
+						"request_bytes": 0,
+						"requests": 1,
+						"response_bytes": 10
+					}
+				}
+			}`,
+		},
+
+		"Custom buckets should be used on the duration histogram.": {
+			config: expvar.Config{Name: "test_custom_buckets", DurationBuckets: []float64{0.1, 1}},
+			recordMets: func(r *expvar.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 500*time.Millisecond)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 50*time.Millisecond)
+			},
+			expMetrics: `{
+				"svc1": {
+					"test1": {
+						"codes": {"200": 2},
+						"duration_seconds": {
+							"buckets": {"0.1": 1, "1": 2, "+Inf": 2},
+							"count": 2,
+							"sum": 0.55
+						},
+						"inflight": 0,
+						"methods": {"GET": 2},
+						"request_bytes": 0,
+						"requests": 2,
+						"response_bytes": 0
+					}
+				}
+			}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			rec, err := expvar.NewRecorder(test.config)
+			require.NoError(err)
+
+			test.recordMets(rec)
+
+			var expMetrics, gotMetrics any
+			require.NoError(json.Unmarshal([]byte(test.expMetrics), &expMetrics))
+			require.NoError(json.Unmarshal([]byte(stdexpvar.Get(test.config.Name).String()), &gotMetrics))
+			assert.Equal(expMetrics, gotMetrics)
+		})
+	}
+}
+
+func TestExpvarRecorderAlreadyPublished(t *testing.T) {
+	_, err := expvar.NewRecorder(expvar.Config{Name: "test_already_published"})
+	require.NoError(t, err)
+
+	_, err = expvar.NewRecorder(expvar.Config{Name: "test_already_published"})
+	assert.Error(t, err)
+}
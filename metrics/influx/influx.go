@@ -0,0 +1,409 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// Config has the dependencies and values of the recorder.
+type Config struct {
+	// Writer is where the line protocol points will be written on every flush, each
+	// flush is a single write. Use NewUDPWriter or NewHTTPWriter to send the points to
+	// InfluxDB or Telegraf. The recorder doesn't close the writer.
+	Writer io.Writer
+	// FlushInterval is the interval where the observations are aggregated and
+	// written, by default is 10s.
+	FlushInterval time.Duration
+	// OnFlushError is called when a flush of the background loop fails, by default the
+	// errors are ignored.
+	OnFlushError func(err error)
+	// Quantiles are the quantiles (0-1) calculated on each flush interval for the durations
+	// and sizes (e.g `0.5`, `0.99`), they are written as `p50`, `p99` fields. Calculating
+	// quantiles requires storing all the observations of the interval, by default there are
+	// no quantiles.
+	Quantiles []float64
+	// DurationBuckets are the buckets in seconds used to count the request durations, they
+	// are written as cumulative `le_<bucket>` fields, by default there are no buckets.
+	DurationBuckets []float64
+	// SizeBuckets are the buckets in bytes used to count the request and response sizes, they
+	// are written as cumulative `le_<bucket>` fields, by default there are no buckets.
+	SizeBuckets []float64
+	// DurationMeasurement is the measurement of the request durations, by default is `http_request_duration_seconds`.
+	DurationMeasurement string
+	// SizeMeasurement is the measurement of the response sizes, by default is `http_response_size_bytes`.
+	SizeMeasurement string
+	// RequestSizeMeasurement is the measurement of the request sizes, by default is `http_request_size_bytes`.
+	RequestSizeMeasurement string
+	// InflightMeasurement is the measurement of the inflight requests, by default is `http_requests_inflight`.
+	InflightMeasurement string
+	// HandlerIDLabel is the name that will be set to the handler ID tag, by default is `handler`.
+	HandlerIDLabel string
+	// StatusCodeLabel is the name that will be set to the status code tag, by default is `code`.
+	StatusCodeLabel string
+	// MethodLabel is the name that will be set to the method tag, by default is `method`.
+	MethodLabel string
+	// ServiceLabel is the name that will be set to the service tag, by default is `service`.
+	ServiceLabel string
+}
+
+func (c *Config) defaults() error {
+	if c.Writer == nil {
+		return errors.New("writer is required")
+	}
+
+	for _, q := range c.Quantiles {
+		if q < 0 || q > 1 {
+			return fmt.Errorf("invalid quantile %v", q)
+		}
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = 10 * time.Second
+	}
+
+	if c.OnFlushError == nil {
+		c.OnFlushError = func(error) {}
+	}
+
+	if c.DurationMeasurement == "" {
+		c.DurationMeasurement = "http_request_duration_seconds"
+	}
+
+	if c.SizeMeasurement == "" {
+		c.SizeMeasurement = "http_response_size_bytes"
+	}
+
+	if c.RequestSizeMeasurement == "" {
+		c.RequestSizeMeasurement = "http_request_size_bytes"
+	}
+
+	if c.InflightMeasurement == "" {
+		c.InflightMeasurement = "http_requests_inflight"
+	}
+
+	if c.HandlerIDLabel == "" {
+		c.HandlerIDLabel = "handler"
+	}
+
+	if c.StatusCodeLabel == "" {
+		c.StatusCodeLabel = "code"
+	}
+
+	if c.MethodLabel == "" {
+		c.MethodLabel = "method"
+	}
+
+	if c.ServiceLabel == "" {
+		c.ServiceLabel = "service"
+	}
+
+	return nil
+}
+
+// Recorder is a metrics recorder that writes the metrics using the InfluxDB
+// line protocol.
+//
+// The durations and sizes are aggregated on every flush interval (count, sum, min, max
+// and the configured quantiles and buckets) and written as a point for each
+// service, handler, method and code, the other properties (extra labels, protocol and
+// scheme) are not written so their observations are aggregated on the same point. The
+// inflight requests are written as absolute gauges on every flush.
+type Recorder struct {
+	cfg Config
+
+	mu        sync.Mutex
+	durations map[metrics.HTTPReqProperties]*aggregate
+	sizes     map[metrics.HTTPReqProperties]*aggregate
+	reqSizes  map[metrics.HTTPReqProperties]*aggregate
+	inflight  map[metrics.HTTPProperties]int64
+
+	// writeMu serializes the writes so the points are written in order.
+	writeMu sync.Mutex
+
+	stopC chan struct{}
+	doneC chan struct{}
+	once  sync.Once
+}
+
+// NewRecorder returns a new metrics recorder that implements the recorder
+// using the InfluxDB line protocol.
+func NewRecorder(cfg Config) (*Recorder, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	r := &Recorder{
+		cfg:       cfg,
+		durations: map[metrics.HTTPReqProperties]*aggregate{},
+		sizes:     map[metrics.HTTPReqProperties]*aggregate{},
+		reqSizes:  map[metrics.HTTPReqProperties]*aggregate{},
+		inflight:  map[metrics.HTTPProperties]int64{},
+		stopC:     make(chan struct{}),
+		doneC:     make(chan struct{}),
+	}
+
+	go r.flushLoop()
+
+	return r, nil
+}
+
+// ObserveHTTPRequestDuration satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPRequestDuration(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observe(r.durations, p, r.cfg.DurationBuckets, duration.Seconds())
+}
+
+// ObserveHTTPResponseSize satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPResponseSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observe(r.sizes, p, r.cfg.SizeBuckets, float64(sizeBytes))
+}
+
+// ObserveHTTPRequestSize satisfies metrics.RequestSizeRecorder interface.
+func (r *Recorder) ObserveHTTPRequestSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observe(r.reqSizes, p, r.cfg.SizeBuckets, float64(sizeBytes))
+}
+
+// AddInflightRequests satisfies metrics.Recorder interface.
+func (r *Recorder) AddInflightRequests(_ context.Context, p metrics.HTTPProperties, quantity int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inflight[metrics.HTTPProperties{Service: p.Service, ID: p.ID}] += int64(quantity)
+}
+
+// Flush writes the aggregated observations of the current interval and resets them.
+func (r *Recorder) Flush() error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	r.mu.Lock()
+	durations, sizes, reqSizes := r.durations, r.sizes, r.reqSizes
+	r.durations = map[metrics.HTTPReqProperties]*aggregate{}
+	r.sizes = map[metrics.HTTPReqProperties]*aggregate{}
+	r.reqSizes = map[metrics.HTTPReqProperties]*aggregate{}
+	inflight := make(map[metrics.HTTPProperties]int64, len(r.inflight))
+	for p, v := range r.inflight {
+		inflight[p] = v
+	}
+	r.mu.Unlock()
+
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+	var b bytes.Buffer
+	r.writeAggregates(&b, r.cfg.DurationMeasurement, durations, r.cfg.DurationBuckets, ts)
+	r.writeAggregates(&b, r.cfg.SizeMeasurement, sizes, r.cfg.SizeBuckets, ts)
+	r.writeAggregates(&b, r.cfg.RequestSizeMeasurement, reqSizes, r.cfg.SizeBuckets, ts)
+	r.writeInflight(&b, inflight, ts)
+
+	if b.Len() == 0 {
+		return nil
+	}
+
+	_, err := r.cfg.Writer.Write(b.Bytes())
+	return err
+}
+
+// Close stops the recorder flushing the pending metrics. The recorder should not be used
+// after closing it.
+func (r *Recorder) Close() error {
+	r.once.Do(func() { close(r.stopC) })
+	<-r.doneC
+
+	return r.Flush()
+}
+
+func (r *Recorder) flushLoop() {
+	defer close(r.doneC)
+
+	t := time.NewTicker(r.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stopC:
+			return
+		case <-t.C:
+			if err := r.Flush(); err != nil {
+				r.cfg.OnFlushError(err)
+			}
+		}
+	}
+}
+
+type aggregate struct {
+	count   int64
+	sum     float64
+	min     float64
+	max     float64
+	buckets []int64
+	values  []float64
+}
+
+func (r *Recorder) observe(aggs map[metrics.HTTPReqProperties]*aggregate, p metrics.HTTPReqProperties, buckets []float64, v float64) {
+	// Only the written tags identify the points.
+	p = metrics.HTTPReqProperties{Service: p.Service, ID: p.ID, Method: p.Method, Code: p.Code}
+	agg, ok := aggs[p]
+	if !ok {
+		agg = &aggregate{min: v, max: v, buckets: make([]int64, len(buckets))}
+		aggs[p] = agg
+	}
+
+	agg.count++
+	agg.sum += v
+	agg.min = math.Min(agg.min, v)
+	agg.max = math.Max(agg.max, v)
+	for i, b := range buckets {
+		if v <= b {
+			agg.buckets[i]++
+		}
+	}
+	if len(r.cfg.Quantiles) > 0 {
+		agg.values = append(agg.values, v)
+	}
+}
+
+func (r *Recorder) writeAggregates(b *bytes.Buffer, measurement string, aggs map[metrics.HTTPReqProperties]*aggregate, buckets []float64, ts string) {
+	props := make([]metrics.HTTPReqProperties, 0, len(aggs))
+	for p := range aggs {
+		props = append(props, p)
+	}
+	sort.Slice(props, func(i, j int) bool {
+		a, b := props[i], props[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Code < b.Code
+	})
+
+	for _, p := range props {
+		agg := aggs[p]
+
+		writeMeasurement(b, measurement)
+		writeTag(b, r.cfg.ServiceLabel, p.Service)
+		writeTag(b, r.cfg.HandlerIDLabel, p.ID)
+		writeTag(b, r.cfg.MethodLabel, p.Method)
+		writeTag(b, r.cfg.StatusCodeLabel, p.Code)
+
+		b.WriteString(" count=")
+		b.WriteString(strconv.FormatInt(agg.count, 10))
+		b.WriteString("i,sum=")
+		b.WriteString(formatFloat(agg.sum))
+		b.WriteString(",min=")
+		b.WriteString(formatFloat(agg.min))
+		b.WriteString(",max=")
+		b.WriteString(formatFloat(agg.max))
+
+		if len(agg.values) > 0 {
+			sort.Float64s(agg.values)
+			for _, q := range r.cfg.Quantiles {
+				b.WriteString(",p")
+				b.WriteString(quantileName(q))
+				b.WriteByte('=')
+				b.WriteString(formatFloat(quantile(agg.values, q)))
+			}
+		}
+
+		for i, bucket := range buckets {
+			b.WriteString(",le_")
+			b.WriteString(formatFloat(bucket))
+			b.WriteByte('=')
+			b.WriteString(strconv.FormatInt(agg.buckets[i], 10))
+			b.WriteByte('i')
+		}
+
+		b.WriteByte(' ')
+		b.WriteString(ts)
+		b.WriteByte('\n')
+	}
+}
+
+func (r *Recorder) writeInflight(b *bytes.Buffer, inflight map[metrics.HTTPProperties]int64, ts string) {
+	props := make([]metrics.HTTPProperties, 0, len(inflight))
+	for p := range inflight {
+		props = append(props, p)
+	}
+	sort.Slice(props, func(i, j int) bool {
+		if props[i].Service != props[j].Service {
+			return props[i].Service < props[j].Service
+		}
+		return props[i].ID < props[j].ID
+	})
+
+	for _, p := range props {
+		writeMeasurement(b, r.cfg.InflightMeasurement)
+		writeTag(b, r.cfg.ServiceLabel, p.Service)
+		writeTag(b, r.cfg.HandlerIDLabel, p.ID)
+		b.WriteString(" value=")
+		b.WriteString(strconv.FormatInt(inflight[p], 10))
+		b.WriteString("i ")
+		b.WriteString(ts)
+		b.WriteByte('\n')
+	}
+}
+
+// quantile returns the quantile of the sorted values using the nearest rank method.
+func quantile(sorted []float64, q float64) float64 {
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+
+	return sorted[idx]
+}
+
+// quantileName returns the percentile of the quantile field names (e.g 0.995 is 99.5),
+// rounded to avoid the float noise of the multiplication (e.g 0.29 is not 28.999999999999996).
+func quantileName(q float64) string {
+	return formatFloat(math.Round(q*1e4) / 1e2)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+func writeMeasurement(b *bytes.Buffer, name string) {
+	b.WriteString(measurementEscaper.Replace(name))
+}
+
+// writeTag writes a tag, the line protocol doesn't support empty tag values
+// so these are omitted.
+func writeTag(b *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+
+	b.WriteByte(',')
+	b.WriteString(tagEscaper.Replace(key))
+	b.WriteByte('=')
+	b.WriteString(tagEscaper.Replace(value))
+}
+
+var (
+	_ metrics.Recorder            = &Recorder{}
+	_ metrics.RequestSizeRecorder = &Recorder{}
+)
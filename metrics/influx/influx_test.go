@@ -0,0 +1,210 @@
+package influx_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/influx"
+)
+
+var timestampRegexp = regexp.MustCompile(` \d+\n`)
+
+// stripTimestamps removes the timestamps of the points so they can be compared.
+func stripTimestamps(s string) string {
+	return timestampRegexp.ReplaceAllString(s, "\n")
+}
+
+func TestInfluxRecorder(t *testing.T) {
+	tests := map[string]struct {
+		config     influx.Config
+		recordMets func(r metrics.Recorder)
+		expPoints  string
+	}{
+		"Default configuration should write the aggregated points.": {
+			config: influx.Config{},
+			recordMets: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 100*time.Millisecond)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 300*time.Millisecond)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "POST", Code: "500"}, 2*time.Second)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 1024)
+				r.(metrics.RequestSizeRecorder).ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "POST", Code: "500"}, 512)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 3)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, -1)
+			},
+			expPoints: "http_request_duration_seconds,service=svc1,handler=test1,method=GET,code=200 count=2i,sum=0.4,min=0.1,max=0.3\n" +
+				"http_request_duration_seconds,service=svc1,handler=test1,method=POST,code=500 count=1i,sum=2,min=2,max=2\n" +
+				"http_response_size_bytes,service=svc1,handler=test1,method=GET,code=200 count=1i,sum=1024,min=1024,max=1024\n" +
+				"http_request_size_bytes,service=svc1,handler=test1,method=POST,code=500 count=1i,sum=512,min=512,max=512\n" +
+				"http_requests_inflight,service=svc1,handler=test1 value=2i\n",
+		},
+
+		"The properties not written as tags should be aggregated on the same point.": {
+			config: influx.Config{},
+			recordMets: func(r metrics.Recorder) {
+				v1 := metrics.NewLabels(map[string]string{"version": "v1"})
+				v2 := metrics.NewLabels(map[string]string{"version": "v2"})
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200", Labels: v1}, 1*time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200", Labels: v2}, 3*time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200", Proto: "HTTP/2", Scheme: "https"}, 5*time.Second)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: v1}, 1)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: v2}, 2)
+			},
+			expPoints: "http_request_duration_seconds,service=svc1,handler=test1,method=GET,code=200 count=3i,sum=9,min=1,max=5\n" +
+				"http_requests_inflight,service=svc1,handler=test1 value=3i\n",
+		},
+
+		"Quantiles and buckets should be written as fields.": {
+			config: influx.Config{
+				Quantiles:       []float64{0.5, 0.99},
+				DurationBuckets: []float64{0.1, 1},
+				SizeBuckets:     []float64{100},
+			},
+			recordMets: func(r metrics.Recorder) {
+				for _, d := range []time.Duration{50 * time.Millisecond, 500 * time.Millisecond, 5 * time.Second, 200 * time.Millisecond} {
+					r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, d)
+				}
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, 10)
+			},
+			expPoints: "http_request_duration_seconds,service=svc1,handler=test1,method=GET,code=200 count=4i,sum=5.75,min=0.05,max=5,p50=0.2,p99=5,le_0.1=1i,le_1=3i\n" +
+				"http_response_size_bytes,service=svc1,handler=test1,method=GET,code=200 count=1i,sum=10,min=10,max=10,p50=10,p99=10,le_100=1i\n",
+		},
+
+		"Quantile field names should be rounded to the percentile.": {
+			config: influx.Config{
+				Quantiles: []float64{0.07, 0.29, 0.995},
+			},
+			recordMets: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: "GET", Code: "200"}, time.Second)
+			},
+			expPoints: "http_request_duration_seconds,service=svc1,handler=test1,method=GET,code=200 count=1i,sum=1,min=1,max=1,p7=1,p29=1,p99.5=1\n",
+		},
+
+		"Custom names and special characters should be escaped, empty tags omitted.": {
+			config: influx.Config{
+				DurationMeasurement: "request duration",
+				HandlerIDLabel:      "route",
+				ServiceLabel:        "app",
+			},
+			recordMets: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{ID: "/a b,c=d", Method: "GET", Code: "200"}, time.Second)
+			},
+			expPoints: `request\ duration,route=/a\ b\,c\=d,method=GET,code=200 count=1i,sum=1,min=1,max=1` + "\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			var b bytes.Buffer
+			test.config.Writer = &b
+			test.config.FlushInterval = time.Hour
+			rec, err := influx.NewRecorder(test.config)
+			require.NoError(err)
+			defer rec.Close()
+
+			test.recordMets(rec)
+			require.NoError(rec.Flush())
+
+			assert.Equal(test.expPoints, stripTimestamps(b.String()))
+
+			// The aggregations are reset after the flush, the inflights are kept.
+			b.Reset()
+			require.NoError(rec.Flush())
+			assert.NotContains(b.String(), "http_request_duration_seconds")
+		})
+	}
+}
+
+func TestInfluxRecorderHTTPWriter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var gotBody, gotAuth, gotQuery string
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "unauthorized access", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		gotAuth = r.Header.Get("Authorization")
+		gotQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w, err := influx.NewHTTPWriter(influx.HTTPWriterConfig{
+		URL:   srv.URL + "/api/v2/write?org=org1&bucket=bucket1",
+		Token: "secret",
+	})
+	require.NoError(err)
+
+	rec, err := influx.NewRecorder(influx.Config{Writer: w, FlushInterval: time.Hour})
+	require.NoError(err)
+	defer rec.Close()
+
+	rec.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+	require.NoError(rec.Flush())
+
+	assert.Equal("http_requests_inflight,service=svc1,handler=test1 value=1i\n", stripTimestamps(gotBody))
+	assert.Equal("Token secret", gotAuth)
+	assert.Equal("org=org1&bucket=bucket1", gotQuery)
+
+	// Failed writes should return an error.
+	fail = true
+	err = rec.Flush()
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "unauthorized access")
+	}
+}
+
+func TestInfluxRecorderUDPWriter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(err)
+	defer conn.Close()
+
+	w, err := influx.NewUDPWriter(conn.LocalAddr().String(), 80)
+	require.NoError(err)
+	defer w.Close()
+
+	rec, err := influx.NewRecorder(influx.Config{Writer: w, FlushInterval: time.Hour})
+	require.NoError(err)
+	defer rec.Close()
+
+	rec.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+	rec.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test2"}, 2)
+	require.NoError(rec.Flush())
+
+	// Each point doesn't fit with another one on the same packet.
+	gotPackets := []string{}
+	buf := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(err)
+		gotPackets = append(gotPackets, stripTimestamps(string(buf[:n])))
+	}
+
+	expPackets := []string{
+		"http_requests_inflight,service=svc1,handler=test1 value=1i\n",
+		"http_requests_inflight,service=svc1,handler=test2 value=2i\n",
+	}
+	assert.Equal(expPackets, gotPackets)
+}
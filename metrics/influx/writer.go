@@ -0,0 +1,143 @@
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// UDPWriter is a writer that sends the line protocol points to an InfluxDB or
+// Telegraf UDP listener. The points are split in multiple packets so they
+// don't exceed the max packet size.
+type UDPWriter struct {
+	conn          net.Conn
+	maxPacketSize int
+}
+
+// NewUDPWriter returns a new UDP writer. If the max packet size is 0 it will
+// default to 1432 (safe for UDP over Ethernet).
+func NewUDPWriter(address string, maxPacketSize int) (*UDPWriter, error) {
+	if maxPacketSize <= 0 {
+		maxPacketSize = 1432
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %q: %w", address, err)
+	}
+
+	return &UDPWriter{
+		conn:          conn,
+		maxPacketSize: maxPacketSize,
+	}, nil
+}
+
+// Write satisfies io.Writer interface. The points are never split, a point that
+// doesn't fit in a packet is sent on its own packet.
+func (w *UDPWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	packet := []byte{}
+	for len(p) > 0 {
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+
+		if len(packet) > 0 && len(packet)+len(line) > w.maxPacketSize {
+			if _, err := w.conn.Write(packet); err != nil {
+				return 0, err
+			}
+			packet = packet[:0]
+		}
+		packet = append(packet, line...)
+	}
+
+	if len(packet) > 0 {
+		if _, err := w.conn.Write(packet); err != nil {
+			return 0, err
+		}
+	}
+
+	return total, nil
+}
+
+// Close closes the UDP connection.
+func (w *UDPWriter) Close() error {
+	return w.conn.Close()
+}
+
+// HTTPWriterConfig is the configuration of the HTTP writer.
+type HTTPWriterConfig struct {
+	// URL is the full URL of the write endpoint, including the query parameters
+	// (e.g `http://127.0.0.1:8086/api/v2/write?org=myorg&bucket=mybucket` or
+	// `http://127.0.0.1:8086/write?db=mydb`).
+	URL string
+	// Token is the token set on the authorization header, by default it's not set.
+	Token string
+	// Client is the HTTP client used to write, by default is a client with a 10s timeout.
+	Client *http.Client
+}
+
+func (c *HTTPWriterConfig) defaults() error {
+	if c.URL == "" {
+		return errors.New("url is required")
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return nil
+}
+
+// HTTPWriter is a writer that sends the line protocol points to an InfluxDB
+// HTTP write endpoint, every write is a request.
+type HTTPWriter struct {
+	cfg HTTPWriterConfig
+}
+
+// NewHTTPWriter returns a new HTTP writer.
+func NewHTTPWriter(cfg HTTPWriterConfig) (*HTTPWriter, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &HTTPWriter{cfg: cfg}, nil
+}
+
+// Write satisfies io.Writer interface.
+func (w *HTTPWriter) Write(p []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(p))
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	}
+
+	resp, err := w.cfg.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not write points: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("could not write points: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return len(p), nil
+}
+
+var (
+	_ io.WriteCloser = &UDPWriter{}
+	_ io.Writer      = &HTTPWriter{}
+)
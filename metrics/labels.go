@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+)
+
+// labelsSep separates the keys and values of the encoded labels.
+const labelsSep = "\x00"
+
+// Labels are the extra label key/values of the metrics (e.g API version, tenant tier...).
+//
+// Labels are immutable and comparable, this way the properties that have them can
+// still be used as map keys. The zero value has no labels.
+type Labels struct {
+	// encoded are the key/values sorted by key, and separated by `labelsSep`.
+	encoded string
+}
+
+// NewLabels returns the labels of the key/values, the keys with empty values are ignored.
+func NewLabels(kvs map[string]string) Labels {
+	if len(kvs) == 0 {
+		return Labels{}
+	}
+
+	keys := make([]string, 0, len(kvs))
+	for k, v := range kvs {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(labelsSep)
+		}
+		b.WriteString(k)
+		b.WriteString(labelsSep)
+		b.WriteString(kvs[k])
+	}
+
+	return Labels{encoded: b.String()}
+}
+
+// Get returns the value of a label, if missing it will return an empty value.
+func (l Labels) Get(key string) string {
+	rest := l.encoded
+	for rest != "" {
+		var k, v string
+		k, rest, _ = strings.Cut(rest, labelsSep)
+		v, rest, _ = strings.Cut(rest, labelsSep)
+		if k == key {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// Len returns the number of labels.
+func (l Labels) Len() int {
+	if l.encoded == "" {
+		return 0
+	}
+
+	return (strings.Count(l.encoded, labelsSep) + 1) / 2
+}
+
+// Map returns the labels as a map.
+func (l Labels) Map() map[string]string {
+	res := map[string]string{}
+	rest := l.encoded
+	for rest != "" {
+		var k, v string
+		k, rest, _ = strings.Cut(rest, labelsSep)
+		v, rest, _ = strings.Cut(rest, labelsSep)
+		res[k] = v
+	}
+
+	return res
+}
+
+// Values returns the values of the keys in the same order, the missing labels
+// will have an empty value.
+func (l Labels) Values(keys []string) []string {
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, l.Get(k))
+	}
+
+	return res
+}
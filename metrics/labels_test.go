@@ -0,0 +1,63 @@
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+func TestLabels(t *testing.T) {
+	tests := map[string]struct {
+		kvs       map[string]string
+		expLen    int
+		expMap    map[string]string
+		expValues []string
+	}{
+		"Without labels it should be empty.": {
+			kvs:       nil,
+			expLen:    0,
+			expMap:    map[string]string{},
+			expValues: []string{"", ""},
+		},
+
+		"Having labels it should return the label values.": {
+			kvs:       map[string]string{"version": "v2", "tier": "gold"},
+			expLen:    2,
+			expMap:    map[string]string{"version": "v2", "tier": "gold"},
+			expValues: []string{"gold", "v2"},
+		},
+
+		"Having labels with empty values it should ignore them.": {
+			kvs:       map[string]string{"version": "v2", "tier": ""},
+			expLen:    1,
+			expMap:    map[string]string{"version": "v2"},
+			expValues: []string{"", "v2"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			l := metrics.NewLabels(test.kvs)
+
+			assert.Equal(test.expLen, l.Len())
+			assert.Equal(test.expMap, l.Map())
+			assert.Equal(test.expValues, l.Values([]string{"tier", "version"}))
+		})
+	}
+}
+
+func TestLabelsComparable(t *testing.T) {
+	assert := assert.New(t)
+
+	l1 := metrics.NewLabels(map[string]string{"a": "1", "b": "2"})
+	l2 := metrics.NewLabels(map[string]string{"b": "2", "a": "1"})
+	l3 := metrics.NewLabels(map[string]string{"a": "1", "b": "3"})
+
+	assert.True(l1 == l2)
+	assert.False(l1 == l3)
+	assert.True(metrics.NewLabels(nil) == metrics.Labels{})
+}
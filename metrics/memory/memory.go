@@ -0,0 +1,620 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// Metric is the kind of metric of an observation.
+type Metric string
+
+const (
+	// MetricRequestDuration is the HTTP request duration metric.
+	MetricRequestDuration Metric = "request_duration"
+	// MetricResponseSize is the HTTP response size metric.
+	MetricResponseSize Metric = "response_size"
+	// MetricInflightRequests is the HTTP inflight requests metric.
+	MetricInflightRequests Metric = "inflight_requests"
+	// MetricRequestSize is the HTTP request size metric.
+	MetricRequestSize Metric = "request_size"
+	// MetricTimeToFirstByte is the HTTP time to first byte metric.
+	MetricTimeToFirstByte Metric = "time_to_first_byte"
+	// MetricPanics is the HTTP handler panics metric.
+	MetricPanics Metric = "panics"
+	// MetricClientAborts is the HTTP client aborted requests metric.
+	MetricClientAborts Metric = "client_aborts"
+	// MetricStreamFlushSize is the HTTP streaming response flush size metric.
+	MetricStreamFlushSize Metric = "stream_flush_size"
+	// MetricStreamFlushInterval is the HTTP streaming response flush interval metric.
+	MetricStreamFlushInterval Metric = "stream_flush_interval"
+	// MetricStreamDuration is the HTTP streaming response duration metric.
+	MetricStreamDuration Metric = "stream_duration"
+	// MetricHijackedConnections is the HTTP open hijacked connections metric.
+	MetricHijackedConnections Metric = "hijacked_connections"
+	// MetricHijackedConnDuration is the HTTP closed hijacked connection duration metric.
+	MetricHijackedConnDuration Metric = "hijacked_connection_duration"
+	// MetricHijackedConnBytesRead is the HTTP closed hijacked connection read bytes metric.
+	MetricHijackedConnBytesRead Metric = "hijacked_connection_bytes_read"
+	// MetricHijackedConnBytesWritten is the HTTP closed hijacked connection written bytes metric.
+	MetricHijackedConnBytesWritten Metric = "hijacked_connection_bytes_written"
+)
+
+// Label is a label of the HTTP request properties.
+type Label string
+
+const (
+	// LabelService is the service label.
+	LabelService Label = "service"
+	// LabelID is the handler ID label.
+	LabelID Label = "handler"
+	// LabelMethod is the method label.
+	LabelMethod Label = "method"
+	// LabelCode is the status code label.
+	LabelCode Label = "code"
+)
+
+// Observation is a single recorded observation.
+type Observation struct {
+	// Metric is the metric of the observation.
+	Metric Metric
+	// ReqProps are the properties of the request metrics (duration and size).
+	ReqProps metrics.HTTPReqProperties
+	// Props are the properties of the global server metrics (inflight, panics and client aborts).
+	Props metrics.HTTPProperties
+	// Value is the observed value, seconds for durations, bytes for sizes
+	// and the added quantity for inflights, panics and client aborts.
+	Value float64
+}
+
+// Aggregate is the aggregation of multiple observations.
+type Aggregate struct {
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+func (a *Aggregate) add(v float64) {
+	if a.Count == 0 || v < a.Min {
+		a.Min = v
+	}
+	if a.Count == 0 || v > a.Max {
+		a.Max = v
+	}
+	a.Count++
+	a.Sum += v
+}
+
+func (a *Aggregate) merge(b Aggregate) {
+	if b.Count == 0 {
+		return
+	}
+	if a.Count == 0 || b.Min < a.Min {
+		a.Min = b.Min
+	}
+	if a.Count == 0 || b.Max > a.Max {
+		a.Max = b.Max
+	}
+	a.Count += b.Count
+	a.Sum += b.Sum
+}
+
+// Query is used to select the aggregated request metrics, the empty
+// fields will match any value.
+type Query struct {
+	Service string
+	ID      string
+	Method  string
+	Code    string
+	Proto   string
+	Scheme  string
+}
+
+func (q Query) match(p metrics.HTTPReqProperties) bool {
+	return (q.Service == "" || q.Service == p.Service) &&
+		(q.ID == "" || q.ID == p.ID) &&
+		(q.Method == "" || q.Method == p.Method) &&
+		(q.Code == "" || q.Code == p.Code) &&
+		(q.Proto == "" || q.Proto == p.Proto) &&
+		(q.Scheme == "" || q.Scheme == p.Scheme)
+}
+
+// Snapshot is a point in time copy of the recorder data.
+type Snapshot struct {
+	// Observations are all the stored observations in recording order.
+	Observations []Observation
+	// RequestDurations are the request duration aggregates (in seconds) by properties.
+	RequestDurations map[metrics.HTTPReqProperties]Aggregate
+	// ResponseSizes are the response size aggregates (in bytes) by properties.
+	ResponseSizes map[metrics.HTTPReqProperties]Aggregate
+	// RequestSizes are the request size aggregates (in bytes) by properties.
+	RequestSizes map[metrics.HTTPReqProperties]Aggregate
+	// TimesToFirstByte are the time to first byte aggregates (in seconds) by properties.
+	TimesToFirstByte map[metrics.HTTPReqProperties]Aggregate
+	// InflightRequests are the current inflight requests by properties.
+	InflightRequests map[metrics.HTTPProperties]int
+	// Panics are the number of handler panics by properties.
+	Panics map[metrics.HTTPProperties]int
+	// ClientAborts are the number of requests aborted by the clients by properties.
+	ClientAborts map[metrics.HTTPProperties]int
+	// StreamFlushSizes are the streaming response flush size aggregates (in bytes) by properties.
+	StreamFlushSizes map[metrics.HTTPReqProperties]Aggregate
+	// StreamFlushIntervals are the streaming response flush interval aggregates (in seconds) by properties.
+	StreamFlushIntervals map[metrics.HTTPReqProperties]Aggregate
+	// StreamDurations are the streaming response duration aggregates (in seconds) by properties.
+	StreamDurations map[metrics.HTTPReqProperties]Aggregate
+	// HijackedConnections are the current open hijacked connections by properties.
+	HijackedConnections map[metrics.HTTPProperties]int
+	// HijackedConnDurations are the closed hijacked connection duration aggregates (in seconds) by properties.
+	HijackedConnDurations map[metrics.HTTPReqProperties]Aggregate
+	// HijackedConnBytesRead are the closed hijacked connection read bytes aggregates by properties.
+	HijackedConnBytesRead map[metrics.HTTPReqProperties]Aggregate
+	// HijackedConnBytesWritten are the closed hijacked connection written bytes aggregates by properties.
+	HijackedConnBytesWritten map[metrics.HTTPReqProperties]Aggregate
+}
+
+// Config has the dependencies and values of the recorder.
+type Config struct {
+	// MaxObservations is the max number of raw observations stored, when reached, the
+	// oldest observations will be discarded. This doesn't affect the aggregated values.
+	// By default all the observations are stored.
+	MaxObservations int
+}
+
+// Recorder is a metrics recorder that stores every observation in memory and
+// aggregates them by properties, it has a query API to get the recorded data.
+//
+// It's safe to use concurrently, this makes it useful as a fake recorder on
+// tests or as the recorder of small services that don't have a metrics backend.
+type Recorder struct {
+	cfg Config
+
+	mu               sync.RWMutex
+	observations     []Observation
+	observationsHead int
+	requestDurations map[metrics.HTTPReqProperties]*Aggregate
+	responseSizes    map[metrics.HTTPReqProperties]*Aggregate
+	requestSizes     map[metrics.HTTPReqProperties]*Aggregate
+	timesToFirstByte map[metrics.HTTPReqProperties]*Aggregate
+	inflightRequests map[metrics.HTTPProperties]int
+	panics           map[metrics.HTTPProperties]int
+	clientAborts     map[metrics.HTTPProperties]int
+	streamFlushSizes map[metrics.HTTPReqProperties]*Aggregate
+	streamFlushIntv  map[metrics.HTTPReqProperties]*Aggregate
+	streamDurations  map[metrics.HTTPReqProperties]*Aggregate
+	hijackedConns    map[metrics.HTTPProperties]int
+	hijackedDurs     map[metrics.HTTPReqProperties]*Aggregate
+	hijackedRead     map[metrics.HTTPReqProperties]*Aggregate
+	hijackedWritten  map[metrics.HTTPReqProperties]*Aggregate
+}
+
+// NewRecorder returns a new in memory metrics recorder.
+func NewRecorder(cfg Config) *Recorder {
+	r := &Recorder{cfg: cfg}
+	r.Reset()
+
+	return r
+}
+
+// ObserveHTTPRequestDuration satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPRequestDuration(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricRequestDuration, ReqProps: p, Value: duration.Seconds()})
+	addAggregate(r.requestDurations, p, duration.Seconds())
+}
+
+// ObserveHTTPResponseSize satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPResponseSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricResponseSize, ReqProps: p, Value: float64(sizeBytes)})
+	addAggregate(r.responseSizes, p, float64(sizeBytes))
+}
+
+// AddInflightRequests satisfies metrics.Recorder interface.
+func (r *Recorder) AddInflightRequests(_ context.Context, p metrics.HTTPProperties, quantity int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricInflightRequests, Props: p, Value: float64(quantity)})
+	r.inflightRequests[p] += quantity
+}
+
+// ObserveHTTPRequestSize satisfies metrics.RequestSizeRecorder interface.
+func (r *Recorder) ObserveHTTPRequestSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricRequestSize, ReqProps: p, Value: float64(sizeBytes)})
+	addAggregate(r.requestSizes, p, float64(sizeBytes))
+}
+
+// ObserveHTTPTimeToFirstByte satisfies metrics.TimeToFirstByteRecorder interface.
+func (r *Recorder) ObserveHTTPTimeToFirstByte(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricTimeToFirstByte, ReqProps: p, Value: duration.Seconds()})
+	addAggregate(r.timesToFirstByte, p, duration.Seconds())
+}
+
+// IncHTTPPanics satisfies metrics.PanicRecorder interface.
+func (r *Recorder) IncHTTPPanics(_ context.Context, p metrics.HTTPProperties) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricPanics, Props: p, Value: 1})
+	r.panics[p]++
+}
+
+// IncHTTPClientAborts satisfies metrics.ClientAbortRecorder interface.
+func (r *Recorder) IncHTTPClientAborts(_ context.Context, p metrics.HTTPProperties) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricClientAborts, Props: p, Value: 1})
+	r.clientAborts[p]++
+}
+
+// ObserveHTTPStreamFlush satisfies metrics.StreamRecorder interface.
+func (r *Recorder) ObserveHTTPStreamFlush(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricStreamFlushSize, ReqProps: p, Value: float64(sizeBytes)})
+	r.addObservation(Observation{Metric: MetricStreamFlushInterval, ReqProps: p, Value: interval.Seconds()})
+	addAggregate(r.streamFlushSizes, p, float64(sizeBytes))
+	addAggregate(r.streamFlushIntv, p, interval.Seconds())
+}
+
+// ObserveHTTPStreamDuration satisfies metrics.StreamRecorder interface.
+func (r *Recorder) ObserveHTTPStreamDuration(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricStreamDuration, ReqProps: p, Value: duration.Seconds()})
+	addAggregate(r.streamDurations, p, duration.Seconds())
+}
+
+// AddHijackedConnections satisfies metrics.HijackRecorder interface.
+func (r *Recorder) AddHijackedConnections(_ context.Context, p metrics.HTTPProperties, quantity int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricHijackedConnections, Props: p, Value: float64(quantity)})
+	r.hijackedConns[p] += quantity
+}
+
+// ObserveHTTPHijackedConnection satisfies metrics.HijackRecorder interface.
+func (r *Recorder) ObserveHTTPHijackedConnection(_ context.Context, p metrics.HTTPReqProperties, conn metrics.HijackedConnection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addObservation(Observation{Metric: MetricHijackedConnDuration, ReqProps: p, Value: conn.Duration.Seconds()})
+	r.addObservation(Observation{Metric: MetricHijackedConnBytesRead, ReqProps: p, Value: float64(conn.BytesRead)})
+	r.addObservation(Observation{Metric: MetricHijackedConnBytesWritten, ReqProps: p, Value: float64(conn.BytesWritten)})
+	addAggregate(r.hijackedDurs, p, conn.Duration.Seconds())
+	addAggregate(r.hijackedRead, p, float64(conn.BytesRead))
+	addAggregate(r.hijackedWritten, p, float64(conn.BytesWritten))
+}
+
+// Observations returns the stored observations in recording order.
+func (r *Recorder) Observations() []Observation {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.copyObservations()
+}
+
+// RequestCount returns the number of measured requests that match the query.
+func (r *Recorder) RequestCount(q Query) int {
+	return r.RequestDuration(q).Count
+}
+
+// RequestDuration returns the aggregated request durations (in seconds) that match the query.
+func (r *Recorder) RequestDuration(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.requestDurations, q)
+}
+
+// ResponseSize returns the aggregated response sizes (in bytes) that match the query.
+func (r *Recorder) ResponseSize(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.responseSizes, q)
+}
+
+// RequestSize returns the aggregated request sizes (in bytes) that match the query.
+func (r *Recorder) RequestSize(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.requestSizes, q)
+}
+
+// TimeToFirstByte returns the aggregated times to first byte (in seconds) that match the query.
+func (r *Recorder) TimeToFirstByte(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.timesToFirstByte, q)
+}
+
+// StreamFlushSize returns the aggregated streaming response flush sizes (in bytes) that match
+// the query, the count is the number of flushes.
+func (r *Recorder) StreamFlushSize(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.streamFlushSizes, q)
+}
+
+// StreamFlushInterval returns the aggregated streaming response flush intervals (in seconds)
+// that match the query.
+func (r *Recorder) StreamFlushInterval(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.streamFlushIntv, q)
+}
+
+// StreamDuration returns the aggregated streaming response durations (in seconds) that match
+// the query.
+func (r *Recorder) StreamDuration(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.streamDurations, q)
+}
+
+// HijackedConnDuration returns the aggregated closed hijacked connection durations (in seconds)
+// that match the query, the count is the number of closed connections.
+func (r *Recorder) HijackedConnDuration(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.hijackedDurs, q)
+}
+
+// HijackedConnBytesRead returns the aggregated closed hijacked connection read bytes that
+// match the query.
+func (r *Recorder) HijackedConnBytesRead(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.hijackedRead, q)
+}
+
+// HijackedConnBytesWritten returns the aggregated closed hijacked connection written bytes
+// that match the query.
+func (r *Recorder) HijackedConnBytesWritten(q Query) Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregate(r.hijackedWritten, q)
+}
+
+// RequestDurationBy returns the aggregated request durations (in seconds) that match the
+// query, broken down by the values of the label.
+func (r *Recorder) RequestDurationBy(label Label, q Query) map[string]Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregateBy(r.requestDurations, label, q)
+}
+
+// ResponseSizeBy returns the aggregated response sizes (in bytes) that match the
+// query, broken down by the values of the label.
+func (r *Recorder) ResponseSizeBy(label Label, q Query) map[string]Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregateBy(r.responseSizes, label, q)
+}
+
+// RequestSizeBy returns the aggregated request sizes (in bytes) that match the
+// query, broken down by the values of the label.
+func (r *Recorder) RequestSizeBy(label Label, q Query) map[string]Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregateBy(r.requestSizes, label, q)
+}
+
+// TimeToFirstByteBy returns the aggregated times to first byte (in seconds) that match the
+// query, broken down by the values of the label.
+func (r *Recorder) TimeToFirstByteBy(label Label, q Query) map[string]Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return queryAggregateBy(r.timesToFirstByte, label, q)
+}
+
+// InflightRequests returns the current number of inflight requests.
+func (r *Recorder) InflightRequests(p metrics.HTTPProperties) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.inflightRequests[p]
+}
+
+// Panics returns the number of handler panics.
+func (r *Recorder) Panics(p metrics.HTTPProperties) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.panics[p]
+}
+
+// ClientAborts returns the number of requests aborted by the clients.
+func (r *Recorder) ClientAborts(p metrics.HTTPProperties) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.clientAborts[p]
+}
+
+// HijackedConnections returns the current number of open hijacked connections.
+func (r *Recorder) HijackedConnections(p metrics.HTTPProperties) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.hijackedConns[p]
+}
+
+// Snapshot returns a copy of the current recorder data.
+func (r *Recorder) Snapshot() Snapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := Snapshot{
+		Observations:     r.copyObservations(),
+		RequestDurations: copyAggregates(r.requestDurations),
+		ResponseSizes:    copyAggregates(r.responseSizes),
+		RequestSizes:     copyAggregates(r.requestSizes),
+		TimesToFirstByte: copyAggregates(r.timesToFirstByte),
+		InflightRequests: map[metrics.HTTPProperties]int{},
+		Panics:           map[metrics.HTTPProperties]int{},
+		ClientAborts:     map[metrics.HTTPProperties]int{},
+
+		StreamFlushSizes:     copyAggregates(r.streamFlushSizes),
+		StreamFlushIntervals: copyAggregates(r.streamFlushIntv),
+		StreamDurations:      copyAggregates(r.streamDurations),
+
+		HijackedConnections:      map[metrics.HTTPProperties]int{},
+		HijackedConnDurations:    copyAggregates(r.hijackedDurs),
+		HijackedConnBytesRead:    copyAggregates(r.hijackedRead),
+		HijackedConnBytesWritten: copyAggregates(r.hijackedWritten),
+	}
+	for p, v := range r.inflightRequests {
+		s.InflightRequests[p] = v
+	}
+	for p, v := range r.panics {
+		s.Panics[p] = v
+	}
+	for p, v := range r.clientAborts {
+		s.ClientAborts[p] = v
+	}
+	for p, v := range r.hijackedConns {
+		s.HijackedConnections[p] = v
+	}
+
+	return s
+}
+
+// Reset removes all the recorded data.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.observations = nil
+	r.observationsHead = 0
+	r.requestDurations = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.responseSizes = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.requestSizes = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.timesToFirstByte = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.inflightRequests = map[metrics.HTTPProperties]int{}
+	r.panics = map[metrics.HTTPProperties]int{}
+	r.clientAborts = map[metrics.HTTPProperties]int{}
+	r.streamFlushSizes = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.streamFlushIntv = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.streamDurations = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.hijackedConns = map[metrics.HTTPProperties]int{}
+	r.hijackedDurs = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.hijackedRead = map[metrics.HTTPReqProperties]*Aggregate{}
+	r.hijackedWritten = map[metrics.HTTPReqProperties]*Aggregate{}
+}
+
+// addObservation stores the observation, when the max observations are reached, the
+// observations are a ring buffer where the head is the oldest observation.
+func (r *Recorder) addObservation(o Observation) {
+	if r.cfg.MaxObservations > 0 && len(r.observations) >= r.cfg.MaxObservations {
+		// Replace the oldest one.
+		r.observations[r.observationsHead] = o
+		r.observationsHead = (r.observationsHead + 1) % len(r.observations)
+		return
+	}
+	r.observations = append(r.observations, o)
+}
+
+// copyObservations returns a copy of the stored observations in recording order.
+func (r *Recorder) copyObservations() []Observation {
+	obs := make([]Observation, 0, len(r.observations))
+	obs = append(obs, r.observations[r.observationsHead:]...)
+	return append(obs, r.observations[:r.observationsHead]...)
+}
+
+func addAggregate(aggs map[metrics.HTTPReqProperties]*Aggregate, p metrics.HTTPReqProperties, v float64) {
+	agg, ok := aggs[p]
+	if !ok {
+		agg = &Aggregate{}
+		aggs[p] = agg
+	}
+	agg.add(v)
+}
+
+func queryAggregate(aggs map[metrics.HTTPReqProperties]*Aggregate, q Query) Aggregate {
+	res := Aggregate{}
+	for p, agg := range aggs {
+		if q.match(p) {
+			res.merge(*agg)
+		}
+	}
+
+	return res
+}
+
+func queryAggregateBy(aggs map[metrics.HTTPReqProperties]*Aggregate, label Label, q Query) map[string]Aggregate {
+	res := map[string]Aggregate{}
+	for p, agg := range aggs {
+		if !q.match(p) {
+			continue
+		}
+
+		var value string
+		switch label {
+		case LabelService:
+			value = p.Service
+		case LabelID:
+			value = p.ID
+		case LabelMethod:
+			value = p.Method
+		case LabelCode:
+			value = p.Code
+		}
+
+		labelAgg := res[value]
+		labelAgg.merge(*agg)
+		res[value] = labelAgg
+	}
+
+	return res
+}
+
+func copyAggregates(aggs map[metrics.HTTPReqProperties]*Aggregate) map[metrics.HTTPReqProperties]Aggregate {
+	res := make(map[metrics.HTTPReqProperties]Aggregate, len(aggs))
+	for p, agg := range aggs {
+		res[p] = *agg
+	}
+
+	return res
+}
+
+var (
+	_ metrics.Recorder                = &Recorder{}
+	_ metrics.RequestSizeRecorder     = &Recorder{}
+	_ metrics.TimeToFirstByteRecorder = &Recorder{}
+	_ metrics.PanicRecorder           = &Recorder{}
+	_ metrics.ClientAbortRecorder     = &Recorder{}
+	_ metrics.StreamRecorder          = &Recorder{}
+	_ metrics.HijackRecorder          = &Recorder{}
+)
@@ -0,0 +1,257 @@
+package memory_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+)
+
+func recordDefault(r metrics.Recorder) {
+	r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 5*time.Second)
+	r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 250*time.Millisecond)
+	r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "500"}, 1*time.Second)
+	r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc2", ID: "test2", Method: http.MethodPost, Code: "201"}, 2*time.Second)
+	r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 100)
+	r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 300)
+	r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 5)
+	r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, -3)
+}
+
+func TestMemoryRecorder(t *testing.T) {
+	tests := map[string]struct {
+		config        memory.Config
+		recordMetrics func(r metrics.Recorder)
+		check         func(t *testing.T, r *memory.Recorder)
+	}{
+		"Counting requests should return the number of requests that match the query.": {
+			recordMetrics: recordDefault,
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Equal(t, 4, r.RequestCount(memory.Query{}))
+				assert.Equal(t, 3, r.RequestCount(memory.Query{Service: "svc1"}))
+				assert.Equal(t, 2, r.RequestCount(memory.Query{ID: "test1", Code: "200"}))
+				assert.Equal(t, 0, r.RequestCount(memory.Query{Method: http.MethodDelete}))
+			},
+		},
+
+		"Aggregating durations and sizes should return the aggregated values that match the query.": {
+			recordMetrics: recordDefault,
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Equal(t, memory.Aggregate{Count: 3, Sum: 6.25, Min: 0.25, Max: 5}, r.RequestDuration(memory.Query{Service: "svc1"}))
+				assert.Equal(t, memory.Aggregate{Count: 2, Sum: 400, Min: 100, Max: 300}, r.ResponseSize(memory.Query{}))
+				assert.Equal(t, memory.Aggregate{}, r.ResponseSize(memory.Query{Service: "svc2"}))
+			},
+		},
+
+		"Breaking down by label should return the aggregated values by each label value.": {
+			recordMetrics: recordDefault,
+			check: func(t *testing.T, r *memory.Recorder) {
+				exp := map[string]memory.Aggregate{
+					"200": {Count: 2, Sum: 5.25, Min: 0.25, Max: 5},
+					"500": {Count: 1, Sum: 1, Min: 1, Max: 1},
+				}
+				assert.Equal(t, exp, r.RequestDurationBy(memory.LabelCode, memory.Query{Service: "svc1"}))
+
+				exp = map[string]memory.Aggregate{
+					"svc1": {Count: 3, Sum: 6.25, Min: 0.25, Max: 5},
+					"svc2": {Count: 1, Sum: 2, Min: 2, Max: 2},
+				}
+				assert.Equal(t, exp, r.RequestDurationBy(memory.LabelService, memory.Query{}))
+
+				exp = map[string]memory.Aggregate{
+					http.MethodGet: {Count: 2, Sum: 400, Min: 100, Max: 300},
+				}
+				assert.Equal(t, exp, r.ResponseSizeBy(memory.LabelMethod, memory.Query{}))
+			},
+		},
+
+		"Aggregating request sizes should return the aggregated values that match the query.": {
+			recordMetrics: func(r metrics.Recorder) {
+				rr := r.(metrics.RequestSizeRecorder)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodPost, Code: "201"}, 1000)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test2", Method: http.MethodPut, Code: "200"}, 10)
+			},
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Equal(t, memory.Aggregate{Count: 2, Sum: 1010, Min: 10, Max: 1000}, r.RequestSize(memory.Query{}))
+				exp := map[string]memory.Aggregate{
+					"test1": {Count: 1, Sum: 1000, Min: 1000, Max: 1000},
+					"test2": {Count: 1, Sum: 10, Min: 10, Max: 10},
+				}
+				assert.Equal(t, exp, r.RequestSizeBy(memory.LabelID, memory.Query{}))
+			},
+		},
+
+		"Aggregating times to first byte should return the aggregated values that match the query.": {
+			recordMetrics: func(r metrics.Recorder) {
+				rr := r.(metrics.TimeToFirstByteRecorder)
+				rr.ObserveHTTPTimeToFirstByte(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 250*time.Millisecond)
+				rr.ObserveHTTPTimeToFirstByte(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test2", Method: http.MethodGet, Code: "200"}, 2*time.Second)
+			},
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Equal(t, memory.Aggregate{Count: 2, Sum: 2.25, Min: 0.25, Max: 2}, r.TimeToFirstByte(memory.Query{}))
+				exp := map[string]memory.Aggregate{
+					"test1": {Count: 1, Sum: 0.25, Min: 0.25, Max: 0.25},
+					"test2": {Count: 1, Sum: 2, Min: 2, Max: 2},
+				}
+				assert.Equal(t, exp, r.TimeToFirstByteBy(memory.LabelID, memory.Query{}))
+			},
+		},
+
+		"Inflight requests should return the current inflight value.": {
+			recordMetrics: recordDefault,
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Equal(t, 2, r.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: "test1"}))
+				assert.Equal(t, 0, r.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: "test2"}))
+			},
+		},
+
+		"Panics should return the counted panics.": {
+			recordMetrics: func(r metrics.Recorder) {
+				pr := r.(metrics.PanicRecorder)
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+			},
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Equal(t, 2, r.Panics(metrics.HTTPProperties{Service: "svc1", ID: "test1"}))
+				assert.Equal(t, 0, r.Panics(metrics.HTTPProperties{Service: "svc1", ID: "test2"}))
+			},
+		},
+
+		"Client aborts should return the counted client aborts.": {
+			recordMetrics: func(r metrics.Recorder) {
+				r.(metrics.ClientAbortRecorder).IncHTTPClientAborts(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+			},
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Equal(t, 1, r.ClientAborts(metrics.HTTPProperties{Service: "svc1", ID: "test1"}))
+				assert.Equal(t, 0, r.ClientAborts(metrics.HTTPProperties{Service: "svc1", ID: "test2"}))
+			},
+		},
+
+		"Snapshots should have all the observations and aggregates.": {
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 5*time.Second)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 100)
+				r.(metrics.RequestSizeRecorder).ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 50)
+				r.(metrics.TimeToFirstByteRecorder).ObserveHTTPTimeToFirstByte(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, time.Second)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				r.(metrics.PanicRecorder).IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				r.(metrics.StreamRecorder).ObserveHTTPStreamFlush(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 10, 2*time.Second)
+				r.(metrics.StreamRecorder).ObserveHTTPStreamDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 3*time.Second)
+			},
+			check: func(t *testing.T, r *memory.Recorder) {
+				reqProps := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}
+				props := metrics.HTTPProperties{Service: "svc1", ID: "test1"}
+				exp := memory.Snapshot{
+					Observations: []memory.Observation{
+						{Metric: memory.MetricRequestDuration, ReqProps: reqProps, Value: 5},
+						{Metric: memory.MetricResponseSize, ReqProps: reqProps, Value: 100},
+						{Metric: memory.MetricRequestSize, ReqProps: reqProps, Value: 50},
+						{Metric: memory.MetricTimeToFirstByte, ReqProps: reqProps, Value: 1},
+						{Metric: memory.MetricInflightRequests, Props: props, Value: 1},
+						{Metric: memory.MetricPanics, Props: props, Value: 1},
+						{Metric: memory.MetricStreamFlushSize, ReqProps: reqProps, Value: 10},
+						{Metric: memory.MetricStreamFlushInterval, ReqProps: reqProps, Value: 2},
+						{Metric: memory.MetricStreamDuration, ReqProps: reqProps, Value: 3},
+					},
+					RequestDurations: map[metrics.HTTPReqProperties]memory.Aggregate{reqProps: {Count: 1, Sum: 5, Min: 5, Max: 5}},
+					ResponseSizes:    map[metrics.HTTPReqProperties]memory.Aggregate{reqProps: {Count: 1, Sum: 100, Min: 100, Max: 100}},
+					RequestSizes:     map[metrics.HTTPReqProperties]memory.Aggregate{reqProps: {Count: 1, Sum: 50, Min: 50, Max: 50}},
+					TimesToFirstByte: map[metrics.HTTPReqProperties]memory.Aggregate{reqProps: {Count: 1, Sum: 1, Min: 1, Max: 1}},
+					InflightRequests: map[metrics.HTTPProperties]int{props: 1},
+					Panics:           map[metrics.HTTPProperties]int{props: 1},
+					ClientAborts:     map[metrics.HTTPProperties]int{},
+
+					StreamFlushSizes:     map[metrics.HTTPReqProperties]memory.Aggregate{reqProps: {Count: 1, Sum: 10, Min: 10, Max: 10}},
+					StreamFlushIntervals: map[metrics.HTTPReqProperties]memory.Aggregate{reqProps: {Count: 1, Sum: 2, Min: 2, Max: 2}},
+					StreamDurations:      map[metrics.HTTPReqProperties]memory.Aggregate{reqProps: {Count: 1, Sum: 3, Min: 3, Max: 3}},
+
+					HijackedConnections:      map[metrics.HTTPProperties]int{},
+					HijackedConnDurations:    map[metrics.HTTPReqProperties]memory.Aggregate{},
+					HijackedConnBytesRead:    map[metrics.HTTPReqProperties]memory.Aggregate{},
+					HijackedConnBytesWritten: map[metrics.HTTPReqProperties]memory.Aggregate{},
+				}
+				assert.Equal(t, exp, r.Snapshot())
+			},
+		},
+
+		"Having a max number of observations should discard the oldest observations but not the aggregates.": {
+			config:        memory.Config{MaxObservations: 2},
+			recordMetrics: recordDefault,
+			check: func(t *testing.T, r *memory.Recorder) {
+				props := metrics.HTTPProperties{Service: "svc1", ID: "test1"}
+				exp := []memory.Observation{
+					{Metric: memory.MetricInflightRequests, Props: props, Value: 5},
+					{Metric: memory.MetricInflightRequests, Props: props, Value: -3},
+				}
+				assert.Equal(t, exp, r.Observations())
+				assert.Equal(t, 4, r.RequestCount(memory.Query{}))
+			},
+		},
+
+		"Having a max number of observations should keep the newest observations in recording order.": {
+			config: memory.Config{MaxObservations: 3},
+			recordMetrics: func(r metrics.Recorder) {
+				for i := 1; i <= 5; i++ {
+					r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{ID: "test1"}, time.Duration(i)*time.Second)
+				}
+			},
+			check: func(t *testing.T, r *memory.Recorder) {
+				props := metrics.HTTPReqProperties{ID: "test1"}
+				exp := []memory.Observation{
+					{Metric: memory.MetricRequestDuration, ReqProps: props, Value: 3},
+					{Metric: memory.MetricRequestDuration, ReqProps: props, Value: 4},
+					{Metric: memory.MetricRequestDuration, ReqProps: props, Value: 5},
+				}
+				assert.Equal(t, exp, r.Observations())
+				assert.Equal(t, exp, r.Snapshot().Observations)
+			},
+		},
+
+		"Resetting should remove all the recorded data.": {
+			recordMetrics: func(r metrics.Recorder) {
+				recordDefault(r)
+				r.(*memory.Recorder).Reset()
+			},
+			check: func(t *testing.T, r *memory.Recorder) {
+				assert.Empty(t, r.Observations())
+				assert.Equal(t, 0, r.RequestCount(memory.Query{}))
+				assert.Equal(t, 0, r.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: "test1"}))
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := memory.NewRecorder(test.config)
+			test.recordMetrics(r)
+			test.check(t, r)
+		})
+	}
+}
+
+func TestMemoryRecorderConcurrency(t *testing.T) {
+	r := memory.NewRecorder(memory.Config{})
+	props := metrics.HTTPProperties{Service: "svc1", ID: "test1"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.AddInflightRequests(context.TODO(), props, 1)
+			r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1"}, time.Second)
+			_ = r.Snapshot()
+			r.AddInflightRequests(context.TODO(), props, -1)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, r.RequestCount(memory.Query{}))
+	assert.Equal(t, 0, r.InflightRequests(props))
+}
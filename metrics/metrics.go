@@ -16,6 +16,13 @@ type HTTPReqProperties struct {
 	Method string
 	// Code is the response of the request.
 	Code string
+	// Proto is the HTTP protocol version of the request (`HTTP/1.0`, `HTTP/1.1`, `HTTP/2`
+	// or `HTTP/3`), empty if not measured.
+	Proto string
+	// Scheme is the scheme of the request (`http` or `https`), empty if not measured.
+	Scheme string
+	// Labels are the extra labels of the request.
+	Labels Labels
 }
 
 // HTTPProperties are the metric properties for the global server metrics.
@@ -24,6 +31,8 @@ type HTTPProperties struct {
 	Service string
 	// ID is the id of the request handler.
 	ID string
+	// Labels are the extra labels of the request.
+	Labels Labels
 }
 
 // Recorder knows how to record and measure the metrics. This
@@ -39,13 +48,97 @@ type Recorder interface {
 	AddInflightRequests(ctx context.Context, props HTTPProperties, quantity int)
 }
 
+// RequestSizeRecorder knows how to record the size of the HTTP requests. This is an
+// optional capability of a Recorder, the middlewares will only measure the request
+// size if the Recorder implements it.
+type RequestSizeRecorder interface {
+	// ObserveHTTPRequestSize measures the size of an HTTP request body in bytes.
+	ObserveHTTPRequestSize(ctx context.Context, props HTTPReqProperties, sizeBytes int64)
+}
+
+// TimeToFirstByteRecorder knows how to record the time to first byte of the HTTP
+// responses. This is an optional capability of a Recorder, the middlewares will only
+// measure the time to first byte if the Recorder implements it.
+type TimeToFirstByteRecorder interface {
+	// ObserveHTTPTimeToFirstByte measures the time from the start of an HTTP request
+	// until the first byte of the response (headers or body) is written.
+	ObserveHTTPTimeToFirstByte(ctx context.Context, props HTTPReqProperties, duration time.Duration)
+}
+
+// PanicRecorder knows how to record the panics of the HTTP handlers. This is an optional
+// capability of a Recorder, the middlewares will only count the panics if the Recorder
+// implements it.
+type PanicRecorder interface {
+	// IncHTTPPanics increments the number of panics of an HTTP handler.
+	IncHTTPPanics(ctx context.Context, props HTTPProperties)
+}
+
+// ClientAbortRecorder knows how to record the HTTP requests aborted by the clients. This is
+// an optional capability of a Recorder, the middlewares will only count the client aborts if
+// the Recorder implements it.
+type ClientAbortRecorder interface {
+	// IncHTTPClientAborts increments the number of requests of an HTTP handler that the
+	// clients canceled before the handler finished.
+	IncHTTPClientAborts(ctx context.Context, props HTTPProperties)
+}
+
+// StreamRecorder knows how to record the streaming HTTP responses (e.g Server-Sent Events),
+// the responses flushed by the handlers while they are written. This is an optional capability
+// of a Recorder, the middlewares will only measure the streams if the Recorder implements it.
+type StreamRecorder interface {
+	// ObserveHTTPStreamFlush measures a flush of a streaming HTTP response, with the size of
+	// the bytes written since the previous flush and the interval since the previous flush (or
+	// the start of the request for the first one).
+	ObserveHTTPStreamFlush(ctx context.Context, props HTTPReqProperties, sizeBytes int64, interval time.Duration)
+	// ObserveHTTPStreamDuration measures the lifetime of a streaming HTTP response, from the
+	// start of the request until the handler finishes.
+	ObserveHTTPStreamDuration(ctx context.Context, props HTTPReqProperties, duration time.Duration)
+}
+
+// HijackRecorder knows how to record the HTTP connections hijacked by the handlers (e.g
+// WebSockets). This is an optional capability of a Recorder, the middlewares will only
+// measure the hijacked connections if the Recorder implements it.
+type HijackRecorder interface {
+	// AddHijackedConnections increments or decrements the number of open hijacked connections
+	// of an HTTP handler.
+	AddHijackedConnections(ctx context.Context, props HTTPProperties, quantity int)
+	// ObserveHTTPHijackedConnection measures a closed hijacked connection.
+	ObserveHTTPHijackedConnection(ctx context.Context, props HTTPReqProperties, conn HijackedConnection)
+}
+
+// HijackedConnection is the measurement of a closed hijacked connection.
+type HijackedConnection struct {
+	// Duration is the lifetime of the connection since it was hijacked.
+	Duration time.Duration
+	// BytesRead is the number of bytes read from the connection.
+	BytesRead int64
+	// BytesWritten is the number of bytes written to the connection.
+	BytesWritten int64
+}
+
 // Dummy is a dummy recorder.
 const Dummy = dummy(0)
 
 type dummy int
 
-func (dummy) ObserveHTTPRequestDuration(_ context.Context, _ HTTPReqProperties, _ time.Duration) {}
-func (dummy) ObserveHTTPResponseSize(_ context.Context, _ HTTPReqProperties, _ int64)            {}
-func (dummy) AddInflightRequests(_ context.Context, _ HTTPProperties, _ int)                     {}
+func (dummy) ObserveHTTPRequestDuration(_ context.Context, _ HTTPReqProperties, _ time.Duration)   {}
+func (dummy) ObserveHTTPResponseSize(_ context.Context, _ HTTPReqProperties, _ int64)              {}
+func (dummy) AddInflightRequests(_ context.Context, _ HTTPProperties, _ int)                       {}
+func (dummy) ObserveHTTPRequestSize(_ context.Context, _ HTTPReqProperties, _ int64)               {}
+func (dummy) ObserveHTTPTimeToFirstByte(_ context.Context, _ HTTPReqProperties, _ time.Duration)   {}
+func (dummy) IncHTTPPanics(_ context.Context, _ HTTPProperties)                                    {}
+func (dummy) IncHTTPClientAborts(_ context.Context, _ HTTPProperties)                              {}
+func (dummy) ObserveHTTPStreamFlush(context.Context, HTTPReqProperties, int64, time.Duration)      {}
+func (dummy) ObserveHTTPStreamDuration(_ context.Context, _ HTTPReqProperties, _ time.Duration)    {}
+func (dummy) AddHijackedConnections(_ context.Context, _ HTTPProperties, _ int)                    {}
+func (dummy) ObserveHTTPHijackedConnection(context.Context, HTTPReqProperties, HijackedConnection) {}
 
-var _ Recorder = Dummy
+var (
+	_ Recorder                = Dummy
+	_ RequestSizeRecorder     = Dummy
+	_ TimeToFirstByteRecorder = Dummy
+	_ PanicRecorder           = Dummy
+	_ ClientAbortRecorder     = Dummy
+	_ StreamRecorder          = Dummy
+	_ HijackRecorder          = Dummy
+)
@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// MetricKind is a kind of metric measured by a Recorder, multiple kinds
+// can be combined (e.g `MetricKindRequestDuration | MetricKindResponseSize`).
+type MetricKind uint
+
+const (
+	// MetricKindRequestDuration is the HTTP request duration metric kind.
+	MetricKindRequestDuration MetricKind = 1 << iota
+	// MetricKindResponseSize is the HTTP response size metric kind.
+	MetricKindResponseSize
+	// MetricKindInflightRequests is the HTTP inflight requests metric kind.
+	MetricKindInflightRequests
+	// MetricKindRequestSize is the HTTP request size metric kind.
+	MetricKindRequestSize
+	// MetricKindTimeToFirstByte is the HTTP time to first byte metric kind.
+	MetricKindTimeToFirstByte
+	// MetricKindPanics is the HTTP handler panics metric kind.
+	MetricKindPanics
+	// MetricKindClientAborts is the HTTP client aborted requests metric kind.
+	MetricKindClientAborts
+	// MetricKindStreams is the HTTP streaming responses metrics kind (flushes and duration).
+	MetricKindStreams
+	// MetricKindHijackedConnections is the HTTP hijacked connections metrics kind (open connections
+	// and closed connections).
+	MetricKindHijackedConnections
+)
+
+// metricKindAll are all the metric kinds.
+const metricKindAll = ^MetricKind(0)
+
+// MultiRecorderTarget is a recorder that will receive the observations of a multi recorder.
+type MultiRecorderTarget struct {
+	// Recorder is the recorder that will receive the observations.
+	Recorder Recorder
+	// Metrics are the kinds of metrics the recorder will receive, by default (zero) it will
+	// receive all of them.
+	Metrics MetricKind
+	// IsolatePanics will recover the panics of the recorder, so the rest of the recorders
+	// still receive the observation and the panic doesn't reach the HTTP handler.
+	IsolatePanics bool
+}
+
+// MultiRecorderConfig is the configuration of the multi recorder.
+type MultiRecorderConfig struct {
+	// Recorders are the recorders that will receive the observations, in order.
+	Recorders []MultiRecorderTarget
+	// OnPanic will be called with the recorder index and the recovered value when
+	// a recorder with isolated panics panics, by default the panic is ignored.
+	OnPanic func(index int, recovered any)
+}
+
+func (c *MultiRecorderConfig) defaults() {
+	if c.OnPanic == nil {
+		c.OnPanic = func(int, any) {}
+	}
+
+	for i, t := range c.Recorders {
+		if t.Metrics == 0 {
+			c.Recorders[i].Metrics = metricKindAll
+		}
+	}
+}
+
+type multiRecorder struct {
+	targets []MultiRecorderTarget
+	onPanic func(index int, recovered any)
+}
+
+// NewMultiRecorder returns a recorder that forwards every observation to multiple recorders.
+//
+// Every target receives the same calls as if it was used alone, this keeps the
+// inflight increments and decrements balanced on each of them.
+func NewMultiRecorder(cfg MultiRecorderConfig) Recorder {
+	// Copy the targets so the user can't modify them after creation.
+	cfg.Recorders = append([]MultiRecorderTarget{}, cfg.Recorders...)
+	cfg.defaults()
+
+	return multiRecorder{
+		targets: cfg.Recorders,
+		onPanic: cfg.OnPanic,
+	}
+}
+
+func (m multiRecorder) ObserveHTTPRequestDuration(ctx context.Context, props HTTPReqProperties, duration time.Duration) {
+	m.forEach(MetricKindRequestDuration, func(r Recorder) { r.ObserveHTTPRequestDuration(ctx, props, duration) })
+}
+
+func (m multiRecorder) ObserveHTTPResponseSize(ctx context.Context, props HTTPReqProperties, sizeBytes int64) {
+	m.forEach(MetricKindResponseSize, func(r Recorder) { r.ObserveHTTPResponseSize(ctx, props, sizeBytes) })
+}
+
+func (m multiRecorder) AddInflightRequests(ctx context.Context, props HTTPProperties, quantity int) {
+	m.forEach(MetricKindInflightRequests, func(r Recorder) { r.AddInflightRequests(ctx, props, quantity) })
+}
+
+func (m multiRecorder) ObserveHTTPRequestSize(ctx context.Context, props HTTPReqProperties, sizeBytes int64) {
+	m.forEach(MetricKindRequestSize, func(r Recorder) {
+		if rr, ok := r.(RequestSizeRecorder); ok {
+			rr.ObserveHTTPRequestSize(ctx, props, sizeBytes)
+		}
+	})
+}
+
+func (m multiRecorder) ObserveHTTPTimeToFirstByte(ctx context.Context, props HTTPReqProperties, duration time.Duration) {
+	m.forEach(MetricKindTimeToFirstByte, func(r Recorder) {
+		if rr, ok := r.(TimeToFirstByteRecorder); ok {
+			rr.ObserveHTTPTimeToFirstByte(ctx, props, duration)
+		}
+	})
+}
+
+func (m multiRecorder) IncHTTPPanics(ctx context.Context, props HTTPProperties) {
+	m.forEach(MetricKindPanics, func(r Recorder) {
+		if rr, ok := r.(PanicRecorder); ok {
+			rr.IncHTTPPanics(ctx, props)
+		}
+	})
+}
+
+func (m multiRecorder) IncHTTPClientAborts(ctx context.Context, props HTTPProperties) {
+	m.forEach(MetricKindClientAborts, func(r Recorder) {
+		if rr, ok := r.(ClientAbortRecorder); ok {
+			rr.IncHTTPClientAborts(ctx, props)
+		}
+	})
+}
+
+func (m multiRecorder) ObserveHTTPStreamFlush(ctx context.Context, props HTTPReqProperties, sizeBytes int64, interval time.Duration) {
+	m.forEach(MetricKindStreams, func(r Recorder) {
+		if rr, ok := r.(StreamRecorder); ok {
+			rr.ObserveHTTPStreamFlush(ctx, props, sizeBytes, interval)
+		}
+	})
+}
+
+func (m multiRecorder) ObserveHTTPStreamDuration(ctx context.Context, props HTTPReqProperties, duration time.Duration) {
+	m.forEach(MetricKindStreams, func(r Recorder) {
+		if rr, ok := r.(StreamRecorder); ok {
+			rr.ObserveHTTPStreamDuration(ctx, props, duration)
+		}
+	})
+}
+
+func (m multiRecorder) AddHijackedConnections(ctx context.Context, props HTTPProperties, quantity int) {
+	m.forEach(MetricKindHijackedConnections, func(r Recorder) {
+		if rr, ok := r.(HijackRecorder); ok {
+			rr.AddHijackedConnections(ctx, props, quantity)
+		}
+	})
+}
+
+func (m multiRecorder) ObserveHTTPHijackedConnection(ctx context.Context, props HTTPReqProperties, conn HijackedConnection) {
+	m.forEach(MetricKindHijackedConnections, func(r Recorder) {
+		if rr, ok := r.(HijackRecorder); ok {
+			rr.ObserveHTTPHijackedConnection(ctx, props, conn)
+		}
+	})
+}
+
+func (m multiRecorder) forEach(kind MetricKind, f func(r Recorder)) {
+	for i, t := range m.targets {
+		if t.Metrics&kind == 0 {
+			continue
+		}
+
+		if !t.IsolatePanics {
+			f(t.Recorder)
+			continue
+		}
+
+		func() {
+			defer func() {
+				if rec := recover(); rec != nil {
+					m.onPanic(i, rec)
+				}
+			}()
+			f(t.Recorder)
+		}()
+	}
+}
+
+var (
+	_ Recorder                = multiRecorder{}
+	_ RequestSizeRecorder     = multiRecorder{}
+	_ TimeToFirstByteRecorder = multiRecorder{}
+	_ PanicRecorder           = multiRecorder{}
+	_ ClientAbortRecorder     = multiRecorder{}
+	_ StreamRecorder          = multiRecorder{}
+	_ HijackRecorder          = multiRecorder{}
+)
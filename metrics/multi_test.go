@@ -0,0 +1,131 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+)
+
+type panicRecorder struct{}
+
+func (panicRecorder) ObserveHTTPRequestDuration(context.Context, metrics.HTTPReqProperties, time.Duration) {
+	panic("duration")
+}
+func (panicRecorder) ObserveHTTPResponseSize(context.Context, metrics.HTTPReqProperties, int64) {
+	panic("size")
+}
+func (panicRecorder) AddInflightRequests(context.Context, metrics.HTTPProperties, int) {
+	panic("inflight")
+}
+
+func TestMultiRecorder(t *testing.T) {
+	reqProps := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}
+	props := metrics.HTTPProperties{Service: "svc1", ID: "test1"}
+	record := func(r metrics.Recorder) {
+		r.AddInflightRequests(context.TODO(), props, 1)
+		r.ObserveHTTPRequestDuration(context.TODO(), reqProps, 2*time.Second)
+		r.ObserveHTTPResponseSize(context.TODO(), reqProps, 42)
+		r.(metrics.RequestSizeRecorder).ObserveHTTPRequestSize(context.TODO(), reqProps, 7)
+		r.AddInflightRequests(context.TODO(), props, 1)
+		r.AddInflightRequests(context.TODO(), props, -1)
+	}
+
+	tests := map[string]struct {
+		config    func(recs []*memory.Recorder) metrics.MultiRecorderConfig
+		expPanic  bool
+		expPanics []int
+		check     func(t *testing.T, recs []*memory.Recorder)
+	}{
+		"Without filters, all the recorders should receive all the observations.": {
+			config: func(recs []*memory.Recorder) metrics.MultiRecorderConfig {
+				return metrics.MultiRecorderConfig{Recorders: []metrics.MultiRecorderTarget{
+					{Recorder: recs[0]},
+					{Recorder: recs[1]},
+				}}
+			},
+			check: func(t *testing.T, recs []*memory.Recorder) {
+				for _, r := range recs {
+					assert.Equal(t, 1, r.RequestCount(memory.Query{}))
+					assert.Equal(t, memory.Aggregate{Count: 1, Sum: 42, Min: 42, Max: 42}, r.ResponseSize(memory.Query{}))
+					assert.Equal(t, memory.Aggregate{Count: 1, Sum: 7, Min: 7, Max: 7}, r.RequestSize(memory.Query{}))
+					assert.Equal(t, 1, r.InflightRequests(props))
+				}
+			},
+		},
+
+		"Having filters, the recorders should only receive the filtered metric kinds.": {
+			config: func(recs []*memory.Recorder) metrics.MultiRecorderConfig {
+				return metrics.MultiRecorderConfig{Recorders: []metrics.MultiRecorderTarget{
+					{Recorder: recs[0], Metrics: metrics.MetricKindRequestDuration | metrics.MetricKindResponseSize},
+					{Recorder: recs[1], Metrics: metrics.MetricKindInflightRequests},
+				}}
+			},
+			check: func(t *testing.T, recs []*memory.Recorder) {
+				assert.Equal(t, 1, recs[0].RequestCount(memory.Query{}))
+				assert.Equal(t, 1, recs[0].ResponseSize(memory.Query{}).Count)
+				assert.Equal(t, 0, recs[0].InflightRequests(props))
+				assert.Len(t, recs[0].Observations(), 2)
+
+				assert.Equal(t, 0, recs[1].RequestCount(memory.Query{}))
+				assert.Equal(t, 0, recs[1].ResponseSize(memory.Query{}).Count)
+				assert.Equal(t, 1, recs[1].InflightRequests(props))
+				assert.Len(t, recs[1].Observations(), 3)
+			},
+		},
+
+		"Having a recorder with isolated panics, the panics should be recovered and the rest of the recorders should receive the observations.": {
+			config: func(recs []*memory.Recorder) metrics.MultiRecorderConfig {
+				return metrics.MultiRecorderConfig{Recorders: []metrics.MultiRecorderTarget{
+					{Recorder: recs[0]},
+					{Recorder: panicRecorder{}, IsolatePanics: true, Metrics: metrics.MetricKindRequestDuration},
+					{Recorder: recs[1]},
+				}}
+			},
+			expPanics: []int{1},
+			check: func(t *testing.T, recs []*memory.Recorder) {
+				for _, r := range recs {
+					assert.Equal(t, 1, r.RequestCount(memory.Query{}))
+					assert.Equal(t, 1, r.InflightRequests(props))
+				}
+			},
+		},
+
+		"Having a recorder without isolated panics, the panic should not be recovered.": {
+			config: func(recs []*memory.Recorder) metrics.MultiRecorderConfig {
+				return metrics.MultiRecorderConfig{Recorders: []metrics.MultiRecorderTarget{
+					{Recorder: recs[0]},
+					{Recorder: panicRecorder{}},
+				}}
+			},
+			expPanic: true,
+			check:    func(t *testing.T, recs []*memory.Recorder) {},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			recs := []*memory.Recorder{memory.NewRecorder(memory.Config{}), memory.NewRecorder(memory.Config{})}
+			cfg := test.config(recs)
+			var gotPanics []int
+			cfg.OnPanic = func(index int, _ any) { gotPanics = append(gotPanics, index) }
+			r := metrics.NewMultiRecorder(cfg)
+
+			if test.expPanic {
+				assert.Panics(func() { record(r) })
+				return
+			}
+
+			record(r)
+			assert.Equal(test.expPanics, gotPanics)
+			test.check(t, recs)
+		})
+	}
+}
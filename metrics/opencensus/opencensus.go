@@ -14,16 +14,21 @@ import (
 var (
 	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
 	sizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000, 100000000, 1000000000}
+	streamBuckets   = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600}
 )
 
 // Config has the dependencies and values of the recorder.
 type Config struct {
-	// DurationBuckets are the buckets used for the HTTP request duration metrics,
+	// DurationBuckets are the buckets used for the HTTP request duration and time to first byte metrics,
 	// by default uses default buckets (from 5ms to 10s).
 	DurationBuckets []float64
-	// SizeBuckets are the buckets for the HTTP response size metrics,
+	// SizeBuckets are the buckets for the HTTP request and response size metrics,
 	// by default uses a exponential buckets from 100B to 1GB.
 	SizeBuckets []float64
+	// StreamDurationBuckets are the buckets for the HTTP streaming response duration and hijacked
+	// connection duration metrics, by default from 1s to 1h. The streaming response flush interval
+	// and size metrics use the `DurationBuckets` and `SizeBuckets`.
+	StreamDurationBuckets []float64
 	// HandlerIDLabel is the name that will be set to the handler ID label, by default is `handler`.
 	HandlerIDLabel string
 	// StatusCodeLabel is the name that will be set to the status code label, by default is `code`.
@@ -32,6 +37,20 @@ type Config struct {
 	MethodLabel string
 	// ServiceLabel is the name that will be set to the service label, by default is `service`.
 	ServiceLabel string
+	// ProtoLabel is the name that will be set to the HTTP protocol version label, by default is `proto`.
+	ProtoLabel string
+	// SchemeLabel is the name that will be set to the scheme label, by default is `scheme`.
+	SchemeLabel string
+	// EnableProtoLabel will add the HTTP protocol version label to the request metrics (e.g `HTTP/2`),
+	// the middleware needs to measure it (`MeasureProtocol` option). By default is disabled.
+	EnableProtoLabel bool
+	// EnableSchemeLabel will add the scheme label to the request metrics (e.g `https`), the middleware
+	// needs to measure it (`MeasureProtocol` option). By default is disabled.
+	EnableSchemeLabel bool
+	// ExtraLabels are the names of the extra labels (`metrics.Labels`) that will be set on all the
+	// metrics, they need to be declared beforehand so the views have a static set of tags. The
+	// undeclared extra labels will be ignored. By default there are no extra labels.
+	ExtraLabels []string
 	// UnregisterViewsBeforeRegister will unregister the previous Recorder views before registering
 	// again. This is required on cases where multiple instances of recorder will be made due to how
 	// Opencensus is implemented (everything is at global state). Sadly this option is a kind of hack
@@ -49,6 +68,10 @@ func (c *Config) defaults() {
 		c.SizeBuckets = sizeBuckets
 	}
 
+	if len(c.StreamDurationBuckets) == 0 {
+		c.StreamDurationBuckets = streamBuckets
+	}
+
 	if c.HandlerIDLabel == "" {
 		c.HandlerIDLabel = "handler"
 	}
@@ -64,6 +87,19 @@ func (c *Config) defaults() {
 	if c.ServiceLabel == "" {
 		c.ServiceLabel = "service"
 	}
+
+	if c.ProtoLabel == "" {
+		c.ProtoLabel = "proto"
+	}
+
+	if c.SchemeLabel == "" {
+		c.SchemeLabel = "scheme"
+	}
+}
+
+type extraKey struct {
+	name string
+	key  tag.Key
 }
 
 type recorder struct {
@@ -72,11 +108,25 @@ type recorder struct {
 	methodKey  tag.Key
 	handlerKey tag.Key
 	serviceKey tag.Key
+	protoKey   *tag.Key
+	schemeKey  *tag.Key
+	extraKeys  []extraKey
 
 	// Measures.
 	latencySecs   *stats.Float64Measure
 	sizeBytes     *stats.Int64Measure
+	reqSizeBytes  *stats.Int64Measure
+	ttfbSecs      *stats.Float64Measure
 	inflightCount *stats.Int64Measure
+	panicCount    *stats.Int64Measure
+	abortCount    *stats.Int64Measure
+	flushBytes    *stats.Int64Measure
+	flushIntvSecs *stats.Float64Measure
+	streamSecs    *stats.Float64Measure
+	hijackedCount *stats.Int64Measure
+	hijackedSecs  *stats.Float64Measure
+	hijackedRead  *stats.Int64Measure
+	hijackedWrite *stats.Int64Measure
 }
 
 // NewRecorder returns a new Recorder that uses OpenCensus stats
@@ -125,6 +175,30 @@ func (r *recorder) createKeys(cfg Config) error {
 	}
 	r.serviceKey = service
 
+	if cfg.EnableProtoLabel {
+		proto, err := tag.NewKey(cfg.ProtoLabel)
+		if err != nil {
+			return err
+		}
+		r.protoKey = &proto
+	}
+
+	if cfg.EnableSchemeLabel {
+		scheme, err := tag.NewKey(cfg.SchemeLabel)
+		if err != nil {
+			return err
+		}
+		r.schemeKey = &scheme
+	}
+
+	for _, name := range cfg.ExtraLabels {
+		key, err := tag.NewKey(name)
+		if err != nil {
+			return err
+		}
+		r.extraKeys = append(r.extraKeys, extraKey{name: name, key: key})
+	}
+
 	return nil
 }
 
@@ -137,43 +211,182 @@ func (r *recorder) createMeasurements() {
 		"http_response_size_bytes",
 		"The size of the HTTP responses",
 		stats.UnitBytes)
+	r.reqSizeBytes = stats.Int64(
+		"http_request_size_bytes",
+		"The size of the HTTP requests",
+		stats.UnitBytes)
+	r.ttfbSecs = stats.Float64(
+		"http_time_to_first_byte_seconds",
+		"The time until the first byte of the HTTP responses is written",
+		"s")
 	r.inflightCount = stats.Int64(
 		"http_requests_inflight",
 		"The number of inflight requests being handled at the same time",
 		stats.UnitNone)
+	r.panicCount = stats.Int64(
+		"http_panics_total",
+		"The number of panics of the HTTP handlers",
+		stats.UnitNone)
+	r.abortCount = stats.Int64(
+		"http_client_aborts_total",
+		"The number of HTTP requests canceled by the clients",
+		stats.UnitNone)
+	r.flushBytes = stats.Int64(
+		"http_response_stream_flush_size_bytes",
+		"The size written between the flushes of the HTTP streaming responses",
+		stats.UnitBytes)
+	r.flushIntvSecs = stats.Float64(
+		"http_response_stream_flush_interval_seconds",
+		"The interval between the flushes of the HTTP streaming responses",
+		"s")
+	r.streamSecs = stats.Float64(
+		"http_response_stream_duration_seconds",
+		"The lifetime of the HTTP streaming responses",
+		"s")
+	r.hijackedCount = stats.Int64(
+		"http_hijacked_connections_open",
+		"The number of open hijacked connections (e.g WebSockets)",
+		stats.UnitNone)
+	r.hijackedSecs = stats.Float64(
+		"http_hijacked_connection_duration_seconds",
+		"The lifetime of the hijacked connections",
+		"s")
+	r.hijackedRead = stats.Int64(
+		"http_hijacked_connection_read_bytes",
+		"The size read from the hijacked connections",
+		stats.UnitBytes)
+	r.hijackedWrite = stats.Int64(
+		"http_hijacked_connection_written_bytes",
+		"The size written to the hijacked connections",
+		stats.UnitBytes)
 }
 
 func (r recorder) registerViews(cfg Config) error {
 
+	tagKeys := []tag.Key{r.serviceKey, r.handlerKey}
+	reqTagKeys := []tag.Key{r.serviceKey, r.handlerKey, r.methodKey, r.codeKey}
+	if r.protoKey != nil {
+		reqTagKeys = append(reqTagKeys, *r.protoKey)
+	}
+	if r.schemeKey != nil {
+		reqTagKeys = append(reqTagKeys, *r.schemeKey)
+	}
+	for _, ek := range r.extraKeys {
+		tagKeys = append(tagKeys, ek.key)
+		reqTagKeys = append(reqTagKeys, ek.key)
+	}
+
 	// OpenCensus uses global states, sadly we can't have view instance.
 	durationView := &view.View{
 		Name:        "http_request_duration_seconds",
 		Description: "The latency of the HTTP requests",
-		TagKeys:     []tag.Key{r.serviceKey, r.handlerKey, r.methodKey, r.codeKey},
+		TagKeys:     reqTagKeys,
 		Measure:     r.latencySecs,
 		Aggregation: view.Distribution(cfg.DurationBuckets...),
 	}
 	sizeView := &view.View{
 		Name:        "http_response_size_bytes",
 		Description: "The size of the HTTP responses",
-		TagKeys:     []tag.Key{r.serviceKey, r.handlerKey, r.methodKey, r.codeKey},
+		TagKeys:     reqTagKeys,
 		Measure:     r.sizeBytes,
 		Aggregation: view.Distribution(cfg.SizeBuckets...),
 	}
+	reqSizeView := &view.View{
+		Name:        "http_request_size_bytes",
+		Description: "The size of the HTTP requests",
+		TagKeys:     reqTagKeys,
+		Measure:     r.reqSizeBytes,
+		Aggregation: view.Distribution(cfg.SizeBuckets...),
+	}
+	ttfbView := &view.View{
+		Name:        "http_time_to_first_byte_seconds",
+		Description: "The time until the first byte of the HTTP responses is written",
+		TagKeys:     reqTagKeys,
+		Measure:     r.ttfbSecs,
+		Aggregation: view.Distribution(cfg.DurationBuckets...),
+	}
 	inflightView := &view.View{
 		Name:        "http_requests_inflight",
 		Description: "The number of inflight requests being handled at the same time",
-		TagKeys:     []tag.Key{r.serviceKey, r.handlerKey},
+		TagKeys:     tagKeys,
 		Measure:     r.inflightCount,
 		Aggregation: view.Sum(),
 	}
+	panicsView := &view.View{
+		Name:        "http_panics_total",
+		Description: "The number of panics of the HTTP handlers",
+		TagKeys:     tagKeys,
+		Measure:     r.panicCount,
+		Aggregation: view.Count(),
+	}
+	abortsView := &view.View{
+		Name:        "http_client_aborts_total",
+		Description: "The number of HTTP requests canceled by the clients",
+		TagKeys:     tagKeys,
+		Measure:     r.abortCount,
+		Aggregation: view.Count(),
+	}
+	flushSizeView := &view.View{
+		Name:        "http_response_stream_flush_size_bytes",
+		Description: "The size written between the flushes of the HTTP streaming responses",
+		TagKeys:     reqTagKeys,
+		Measure:     r.flushBytes,
+		Aggregation: view.Distribution(cfg.SizeBuckets...),
+	}
+	flushIntervalView := &view.View{
+		Name:        "http_response_stream_flush_interval_seconds",
+		Description: "The interval between the flushes of the HTTP streaming responses",
+		TagKeys:     reqTagKeys,
+		Measure:     r.flushIntvSecs,
+		Aggregation: view.Distribution(cfg.DurationBuckets...),
+	}
+	streamDurationView := &view.View{
+		Name:        "http_response_stream_duration_seconds",
+		Description: "The lifetime of the HTTP streaming responses",
+		TagKeys:     reqTagKeys,
+		Measure:     r.streamSecs,
+		Aggregation: view.Distribution(cfg.StreamDurationBuckets...),
+	}
+	hijackedView := &view.View{
+		Name:        "http_hijacked_connections_open",
+		Description: "The number of open hijacked connections (e.g WebSockets)",
+		TagKeys:     tagKeys,
+		Measure:     r.hijackedCount,
+		Aggregation: view.Sum(),
+	}
+	hijackedDurationView := &view.View{
+		Name:        "http_hijacked_connection_duration_seconds",
+		Description: "The lifetime of the hijacked connections",
+		TagKeys:     reqTagKeys,
+		Measure:     r.hijackedSecs,
+		Aggregation: view.Distribution(cfg.StreamDurationBuckets...),
+	}
+	hijackedReadView := &view.View{
+		Name:        "http_hijacked_connection_read_bytes",
+		Description: "The size read from the hijacked connections",
+		TagKeys:     reqTagKeys,
+		Measure:     r.hijackedRead,
+		Aggregation: view.Distribution(cfg.SizeBuckets...),
+	}
+	hijackedWrittenView := &view.View{
+		Name:        "http_hijacked_connection_written_bytes",
+		Description: "The size written to the hijacked connections",
+		TagKeys:     reqTagKeys,
+		Measure:     r.hijackedWrite,
+		Aggregation: view.Distribution(cfg.SizeBuckets...),
+	}
+	views := []*view.View{
+		durationView, sizeView, reqSizeView, ttfbView, inflightView, panicsView, abortsView,
+		flushSizeView, flushIntervalView, streamDurationView,
+		hijackedView, hijackedDurationView, hijackedReadView, hijackedWrittenView,
+	}
 
 	// Do we need to unregister the same views before registering.
 	if cfg.UnregisterViewsBeforeRegister {
-		view.Unregister(durationView, sizeView, inflightView)
+		view.Unregister(views...)
 	}
 
-	err := view.Register(durationView, sizeView, inflightView)
+	err := view.Register(views...)
 	if err != nil {
 		return err
 	}
@@ -191,25 +404,81 @@ func (r recorder) ObserveHTTPResponseSize(ctx context.Context, p metrics.HTTPReq
 	stats.Record(ctx, r.sizeBytes.M(sizeBytes))
 }
 
+func (r recorder) ObserveHTTPRequestSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	ctx = r.ctxWithTagFromHTTPReqProperties(ctx, p)
+	stats.Record(ctx, r.reqSizeBytes.M(sizeBytes))
+}
+
+func (r recorder) ObserveHTTPTimeToFirstByte(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	ctx = r.ctxWithTagFromHTTPReqProperties(ctx, p)
+	stats.Record(ctx, r.ttfbSecs.M(duration.Seconds()))
+}
+
 func (r recorder) AddInflightRequests(ctx context.Context, p metrics.HTTPProperties, quantity int) {
 	ctx = r.ctxWithTagFromHTTPProperties(ctx, p)
 	stats.Record(ctx, r.inflightCount.M(int64(quantity)))
 }
 
+func (r recorder) IncHTTPPanics(ctx context.Context, p metrics.HTTPProperties) {
+	ctx = r.ctxWithTagFromHTTPProperties(ctx, p)
+	stats.Record(ctx, r.panicCount.M(1))
+}
+
+func (r recorder) IncHTTPClientAborts(ctx context.Context, p metrics.HTTPProperties) {
+	ctx = r.ctxWithTagFromHTTPProperties(ctx, p)
+	stats.Record(ctx, r.abortCount.M(1))
+}
+
+func (r recorder) ObserveHTTPStreamFlush(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64, interval time.Duration) {
+	ctx = r.ctxWithTagFromHTTPReqProperties(ctx, p)
+	stats.Record(ctx, r.flushBytes.M(sizeBytes), r.flushIntvSecs.M(interval.Seconds()))
+}
+
+func (r recorder) ObserveHTTPStreamDuration(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	ctx = r.ctxWithTagFromHTTPReqProperties(ctx, p)
+	stats.Record(ctx, r.streamSecs.M(duration.Seconds()))
+}
+
+func (r recorder) AddHijackedConnections(ctx context.Context, p metrics.HTTPProperties, quantity int) {
+	ctx = r.ctxWithTagFromHTTPProperties(ctx, p)
+	stats.Record(ctx, r.hijackedCount.M(int64(quantity)))
+}
+
+func (r recorder) ObserveHTTPHijackedConnection(ctx context.Context, p metrics.HTTPReqProperties, conn metrics.HijackedConnection) {
+	ctx = r.ctxWithTagFromHTTPReqProperties(ctx, p)
+	stats.Record(ctx, r.hijackedSecs.M(conn.Duration.Seconds()), r.hijackedRead.M(conn.BytesRead), r.hijackedWrite.M(conn.BytesWritten))
+}
+
 func (r recorder) ctxWithTagFromHTTPReqProperties(ctx context.Context, p metrics.HTTPReqProperties) context.Context {
-	newCtx, _ := tag.New(ctx,
+	mutators := []tag.Mutator{
 		tag.Upsert(r.serviceKey, p.Service),
 		tag.Upsert(r.handlerKey, p.ID),
 		tag.Upsert(r.methodKey, p.Method),
 		tag.Upsert(r.codeKey, p.Code),
-	)
+	}
+	if r.protoKey != nil {
+		mutators = append(mutators, tag.Upsert(*r.protoKey, p.Proto))
+	}
+	if r.schemeKey != nil {
+		mutators = append(mutators, tag.Upsert(*r.schemeKey, p.Scheme))
+	}
+
+	newCtx, _ := tag.New(ctx, r.withExtraTags(mutators, p.Labels)...)
 	return newCtx
 }
 
 func (r recorder) ctxWithTagFromHTTPProperties(ctx context.Context, p metrics.HTTPProperties) context.Context {
-	newCtx, _ := tag.New(ctx,
+	newCtx, _ := tag.New(ctx, r.withExtraTags([]tag.Mutator{
 		tag.Upsert(r.serviceKey, p.Service),
 		tag.Upsert(r.handlerKey, p.ID),
-	)
+	}, p.Labels)...)
 	return newCtx
 }
+
+// withExtraTags adds the tags of the declared extra labels.
+func (r recorder) withExtraTags(mutators []tag.Mutator, labels metrics.Labels) []tag.Mutator {
+	for _, ek := range r.extraKeys {
+		mutators = append(mutators, tag.Upsert(ek.key, labels.Get(ek.name)))
+	}
+	return mutators
+}
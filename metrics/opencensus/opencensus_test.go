@@ -109,6 +109,159 @@ func TestOpenCensusRecorder(t *testing.T) {
 				`http_requests_inflight{handler="test2",service="svc2"} 9`,
 			},
 		},
+		{
+			name:   "Measuring request sizes should measure the request size metric.",
+			config: ocmetrics.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				rr := r.(metrics.RequestSizeRecorder)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodPost, Code: "201"}, 5000)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodPost, Code: "201"}, 50)
+			},
+			expMetrics: []string{
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="100"} 1`,
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="1000"} 1`,
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="10000"} 2`,
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="+Inf"} 2`,
+				`http_request_size_bytes_count{code="201",handler="test1",method="POST",service="svc1"} 2`,
+			},
+		},
+		{
+			name: "Using extra labels in the configuration should measure with the extra labels.",
+			config: ocmetrics.Config{
+				ExtraLabels: []string{"tier", "version"},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				labels := metrics.NewLabels(map[string]string{"version": "v2", "undeclared": "ignored"})
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Labels: labels}, 50)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: labels}, 1)
+			},
+			expMetrics: []string{
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",service="svc1",tier="",version="v2"} 1`,
+				`http_requests_inflight{handler="test1",service="svc1",tier="",version="v2"} 1`,
+			},
+		},
+		{
+			name: "Enabling the protocol labels should measure the request metrics with the protocol and scheme.",
+			config: ocmetrics.Config{
+				EnableProtoLabel:  true,
+				EnableSchemeLabel: true,
+				ExtraLabels:       []string{"version"},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				labels := metrics.NewLabels(map[string]string{"version": "v2"})
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Proto: "HTTP/2", Scheme: "https", Labels: labels}, 50)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Proto: "HTTP/1.1", Scheme: "http", Labels: labels}, 50)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: labels}, 1)
+			},
+			expMetrics: []string{
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",proto="HTTP/2",scheme="https",service="svc1",version="v2"} 1`,
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",proto="HTTP/1.1",scheme="http",service="svc1",version="v2"} 1`,
+				`http_requests_inflight{handler="test1",service="svc1",version="v2"} 1`,
+			},
+		},
+		{
+			name: "Using custom protocol label names should measure with the custom label names.",
+			config: ocmetrics.Config{
+				ProtoLabel:       "protocol",
+				EnableProtoLabel: true,
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Proto: "HTTP/3", Scheme: "https"}, 50)
+			},
+			expMetrics: []string{
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",protocol="HTTP/3",service="svc1"} 1`,
+			},
+		},
+		{
+			name:   "Counting client aborts should measure the client aborts metric.",
+			config: ocmetrics.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				ar := r.(metrics.ClientAbortRecorder)
+				ar.IncHTTPClientAborts(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				ar.IncHTTPClientAborts(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+			},
+			expMetrics: []string{
+				`http_client_aborts_total{handler="test1",service="svc1"} 2`,
+			},
+		},
+		{
+			name: "Measuring streams should measure the stream flushes and duration metrics.",
+			config: ocmetrics.Config{
+				SizeBuckets:           []float64{10, 100},
+				DurationBuckets:       []float64{1, 5},
+				StreamDurationBuckets: []float64{60, 600},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				sr := r.(metrics.StreamRecorder)
+				props := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}
+				sr.ObserveHTTPStreamFlush(context.TODO(), props, 5, 500*time.Millisecond)
+				sr.ObserveHTTPStreamFlush(context.TODO(), props, 50, 2*time.Second)
+				sr.ObserveHTTPStreamDuration(context.TODO(), props, 120*time.Second)
+			},
+			expMetrics: []string{
+				`http_response_stream_flush_size_bytes_bucket{code="200",handler="test1",method="GET",service="svc1",le="10"} 1`,
+				`http_response_stream_flush_size_bytes_bucket{code="200",handler="test1",method="GET",service="svc1",le="100"} 2`,
+				`http_response_stream_flush_size_bytes_count{code="200",handler="test1",method="GET",service="svc1"} 2`,
+				`http_response_stream_flush_interval_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="1"} 1`,
+				`http_response_stream_flush_interval_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="5"} 2`,
+				`http_response_stream_duration_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="60"} 0`,
+				`http_response_stream_duration_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="600"} 1`,
+			},
+		},
+		{
+			name: "Measuring hijacked connections should measure the open connections and closed connection metrics.",
+			config: ocmetrics.Config{
+				SizeBuckets:           []float64{10, 100},
+				StreamDurationBuckets: []float64{60, 600},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				hr := r.(metrics.HijackRecorder)
+				hr.AddHijackedConnections(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				hr.AddHijackedConnections(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				hr.AddHijackedConnections(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, -1)
+				props := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "101"}
+				hr.ObserveHTTPHijackedConnection(context.TODO(), props, metrics.HijackedConnection{Duration: 120 * time.Second, BytesRead: 5, BytesWritten: 50})
+			},
+			expMetrics: []string{
+				`http_hijacked_connections_open{handler="test1",service="svc1"} 1`,
+				`http_hijacked_connection_duration_seconds_bucket{code="101",handler="test1",method="GET",service="svc1",le="60"} 0`,
+				`http_hijacked_connection_duration_seconds_bucket{code="101",handler="test1",method="GET",service="svc1",le="600"} 1`,
+				`http_hijacked_connection_read_bytes_bucket{code="101",handler="test1",method="GET",service="svc1",le="10"} 1`,
+				`http_hijacked_connection_written_bytes_bucket{code="101",handler="test1",method="GET",service="svc1",le="10"} 0`,
+				`http_hijacked_connection_written_bytes_bucket{code="101",handler="test1",method="GET",service="svc1",le="100"} 1`,
+			},
+		},
+		{
+			name:   "Counting panics should measure the panics metric.",
+			config: ocmetrics.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				pr := r.(metrics.PanicRecorder)
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test2"})
+			},
+			expMetrics: []string{
+				`http_panics_total{handler="test1",service="svc1"} 2`,
+				`http_panics_total{handler="test2",service="svc1"} 1`,
+			},
+		},
+		{
+			name:   "Measuring times to first byte should measure the time to first byte metric.",
+			config: ocmetrics.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				rr := r.(metrics.TimeToFirstByteRecorder)
+				rr.ObserveHTTPTimeToFirstByte(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 75*time.Millisecond)
+				rr.ObserveHTTPTimeToFirstByte(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 3*time.Second)
+			},
+			expMetrics: []string{
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="0.05"} 0`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="0.1"} 1`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="2.5"} 1`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="5"} 2`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="+Inf"} 2`,
+				`http_time_to_first_byte_seconds_count{code="200",handler="test1",method="GET",service="svc1"} 2`,
+			},
+		},
 		{
 			name: "Using custom buckets in the configuration should measure with custom buckets.",
 			config: ocmetrics.Config{
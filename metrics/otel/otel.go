@@ -0,0 +1,191 @@
+package otel
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+const instrumentationName = "github.com/slok/go-http-metrics/metrics/otel"
+
+var (
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000, 100000000, 1000000000}
+)
+
+// Config has the dependencies and values of the recorder.
+type Config struct {
+	// MeterProvider is the OpenTelemetry meter provider used to create the instruments,
+	// by default it will use the global meter provider.
+	MeterProvider metric.MeterProvider
+	// DurationBuckets are the explicit bucket boundaries used for the HTTP request duration
+	// histogram, by default uses default buckets (from 5ms to 10s).
+	DurationBuckets []float64
+	// SizeBuckets are the explicit bucket boundaries used for the HTTP request and response size
+	// histograms, by default uses a exponential buckets from 100B to 1GB.
+	SizeBuckets []float64
+	// DurationMetricName is the name of the HTTP request duration histogram, by default
+	// is `http_request_duration_seconds`.
+	DurationMetricName string
+	// SizeMetricName is the name of the HTTP response size histogram, by default
+	// is `http_response_size_bytes`.
+	SizeMetricName string
+	// RequestSizeMetricName is the name of the HTTP request size histogram, by default
+	// is `http_request_size_bytes`.
+	RequestSizeMetricName string
+	// InflightMetricName is the name of the inflight requests up-down counter, by default
+	// is `http_requests_inflight`.
+	InflightMetricName string
+	// HandlerIDLabel is the name that will be set to the handler ID attribute, by default is `handler`.
+	HandlerIDLabel string
+	// StatusCodeLabel is the name that will be set to the status code attribute, by default is `code`.
+	StatusCodeLabel string
+	// MethodLabel is the name that will be set to the method attribute, by default is `method`.
+	MethodLabel string
+	// ServiceLabel is the name that will be set to the service attribute, by default is `service`.
+	ServiceLabel string
+}
+
+func (c *Config) defaults() {
+	if c.MeterProvider == nil {
+		c.MeterProvider = otel.GetMeterProvider()
+	}
+
+	if len(c.DurationBuckets) == 0 {
+		c.DurationBuckets = durationBuckets
+	}
+
+	if len(c.SizeBuckets) == 0 {
+		c.SizeBuckets = sizeBuckets
+	}
+
+	if c.DurationMetricName == "" {
+		c.DurationMetricName = "http_request_duration_seconds"
+	}
+
+	if c.SizeMetricName == "" {
+		c.SizeMetricName = "http_response_size_bytes"
+	}
+
+	if c.RequestSizeMetricName == "" {
+		c.RequestSizeMetricName = "http_request_size_bytes"
+	}
+
+	if c.InflightMetricName == "" {
+		c.InflightMetricName = "http_requests_inflight"
+	}
+
+	if c.HandlerIDLabel == "" {
+		c.HandlerIDLabel = "handler"
+	}
+
+	if c.StatusCodeLabel == "" {
+		c.StatusCodeLabel = "code"
+	}
+
+	if c.MethodLabel == "" {
+		c.MethodLabel = "method"
+	}
+
+	if c.ServiceLabel == "" {
+		c.ServiceLabel = "service"
+	}
+}
+
+type recorder struct {
+	handlerKey string
+	codeKey    string
+	methodKey  string
+	serviceKey string
+
+	httpRequestDurHistogram   metric.Float64Histogram
+	httpResponseSizeHistogram metric.Int64Histogram
+	httpRequestSizeHistogram  metric.Int64Histogram
+	httpRequestsInflight      metric.Int64UpDownCounter
+}
+
+// NewRecorder returns a new metrics recorder that implements the recorder
+// using OpenTelemetry metrics as the backend.
+func NewRecorder(cfg Config) (metrics.Recorder, error) {
+	cfg.defaults()
+
+	meter := cfg.MeterProvider.Meter(instrumentationName)
+
+	r := &recorder{
+		handlerKey: cfg.HandlerIDLabel,
+		codeKey:    cfg.StatusCodeLabel,
+		methodKey:  cfg.MethodLabel,
+		serviceKey: cfg.ServiceLabel,
+	}
+
+	var err error
+	r.httpRequestDurHistogram, err = meter.Float64Histogram(cfg.DurationMetricName,
+		metric.WithDescription("The latency of the HTTP requests."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(cfg.DurationBuckets...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	r.httpResponseSizeHistogram, err = meter.Int64Histogram(cfg.SizeMetricName,
+		metric.WithDescription("The size of the HTTP responses."),
+		metric.WithUnit("By"),
+		metric.WithExplicitBucketBoundaries(cfg.SizeBuckets...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	r.httpRequestSizeHistogram, err = meter.Int64Histogram(cfg.RequestSizeMetricName,
+		metric.WithDescription("The size of the HTTP requests."),
+		metric.WithUnit("By"),
+		metric.WithExplicitBucketBoundaries(cfg.SizeBuckets...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	r.httpRequestsInflight, err = meter.Int64UpDownCounter(cfg.InflightMetricName,
+		metric.WithDescription("The number of inflight requests being handled at the same time."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r recorder) ObserveHTTPRequestDuration(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.httpRequestDurHistogram.Record(ctx, duration.Seconds(), r.httpReqAttributes(p))
+}
+
+func (r recorder) ObserveHTTPResponseSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.httpResponseSizeHistogram.Record(ctx, sizeBytes, r.httpReqAttributes(p))
+}
+
+func (r recorder) ObserveHTTPRequestSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.httpRequestSizeHistogram.Record(ctx, sizeBytes, r.httpReqAttributes(p))
+}
+
+func (r recorder) AddInflightRequests(ctx context.Context, p metrics.HTTPProperties, quantity int) {
+	r.httpRequestsInflight.Add(ctx, int64(quantity), metric.WithAttributes(
+		attribute.String(r.serviceKey, p.Service),
+		attribute.String(r.handlerKey, p.ID),
+	))
+}
+
+func (r recorder) httpReqAttributes(p metrics.HTTPReqProperties) metric.MeasurementOption {
+	return metric.WithAttributes(
+		attribute.String(r.serviceKey, p.Service),
+		attribute.String(r.handlerKey, p.ID),
+		attribute.String(r.methodKey, p.Method),
+		attribute.String(r.codeKey, p.Code),
+	)
+}
@@ -0,0 +1,216 @@
+package otel_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	"github.com/slok/go-http-metrics/metrics"
+	otelmetrics "github.com/slok/go-http-metrics/metrics/otel"
+)
+
+func TestOTelRecorder(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        otelmetrics.Config
+		recordMetrics func(r metrics.Recorder)
+		expMetrics    []metricdata.Metrics
+	}{
+		{
+			name: "Default configuration should measure with the default metric style.",
+			config: otelmetrics.Config{
+				DurationBuckets: []float64{1, 5},
+				SizeBuckets:     []float64{100, 1000},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 5*time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc2", ID: "test3", Method: http.MethodPost, Code: "500"}, 7*time.Second)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test4", Method: http.MethodPost, Code: "500"}, 529930)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test4", Method: http.MethodPost, Code: "500"}, 231)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 5)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, -3)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc2", ID: "test2"}, 9)
+			},
+			expMetrics: []metricdata.Metrics{
+				{
+					Name:        "http_request_duration_seconds",
+					Description: "The latency of the HTTP requests.",
+					Unit:        "s",
+					Data: metricdata.Histogram[float64]{
+						Temporality: metricdata.CumulativeTemporality,
+						DataPoints: []metricdata.HistogramDataPoint[float64]{
+							{
+								Attributes:   attribute.NewSet(attribute.String("service", "svc1"), attribute.String("handler", "test1"), attribute.String("method", "GET"), attribute.String("code", "200")),
+								Bounds:       []float64{1, 5},
+								BucketCounts: []uint64{1, 1, 0},
+								Count:        2,
+								Sum:          5.175,
+								Min:          metricdata.NewExtrema(0.175),
+								Max:          metricdata.NewExtrema(5.0),
+							},
+							{
+								Attributes:   attribute.NewSet(attribute.String("service", "svc2"), attribute.String("handler", "test3"), attribute.String("method", "POST"), attribute.String("code", "500")),
+								Bounds:       []float64{1, 5},
+								BucketCounts: []uint64{0, 0, 1},
+								Count:        1,
+								Sum:          7,
+								Min:          metricdata.NewExtrema(7.0),
+								Max:          metricdata.NewExtrema(7.0),
+							},
+						},
+					},
+				},
+				{
+					Name:        "http_response_size_bytes",
+					Description: "The size of the HTTP responses.",
+					Unit:        "By",
+					Data: metricdata.Histogram[int64]{
+						Temporality: metricdata.CumulativeTemporality,
+						DataPoints: []metricdata.HistogramDataPoint[int64]{
+							{
+								Attributes:   attribute.NewSet(attribute.String("service", "svc1"), attribute.String("handler", "test4"), attribute.String("method", "POST"), attribute.String("code", "500")),
+								Bounds:       []float64{100, 1000},
+								BucketCounts: []uint64{0, 1, 1},
+								Count:        2,
+								Sum:          530161,
+								Min:          metricdata.NewExtrema[int64](231),
+								Max:          metricdata.NewExtrema[int64](529930),
+							},
+						},
+					},
+				},
+				{
+					Name:        "http_requests_inflight",
+					Description: "The number of inflight requests being handled at the same time.",
+					Unit:        "{request}",
+					Data: metricdata.Sum[int64]{
+						Temporality: metricdata.CumulativeTemporality,
+						IsMonotonic: false,
+						DataPoints: []metricdata.DataPoint[int64]{
+							{Attributes: attribute.NewSet(attribute.String("service", "svc1"), attribute.String("handler", "test1")), Value: 2},
+							{Attributes: attribute.NewSet(attribute.String("service", "svc2"), attribute.String("handler", "test2")), Value: 9},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Measuring request sizes should measure the request size metric.",
+			config: otelmetrics.Config{
+				SizeBuckets: []float64{100, 1000},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				rr := r.(metrics.RequestSizeRecorder)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodPost, Code: "201"}, 5000)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodPost, Code: "201"}, 50)
+			},
+			expMetrics: []metricdata.Metrics{
+				{
+					Name:        "http_request_size_bytes",
+					Description: "The size of the HTTP requests.",
+					Unit:        "By",
+					Data: metricdata.Histogram[int64]{
+						Temporality: metricdata.CumulativeTemporality,
+						DataPoints: []metricdata.HistogramDataPoint[int64]{
+							{
+								Attributes:   attribute.NewSet(attribute.String("service", "svc1"), attribute.String("handler", "test1"), attribute.String("method", "POST"), attribute.String("code", "201")),
+								Bounds:       []float64{100, 1000},
+								BucketCounts: []uint64{1, 0, 1},
+								Count:        2,
+								Sum:          5050,
+								Min:          metricdata.NewExtrema[int64](50),
+								Max:          metricdata.NewExtrema[int64](5000),
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Using custom instrument names and attribute keys in the configuration should measure with those.",
+			config: otelmetrics.Config{
+				DurationBuckets:    []float64{1, 5},
+				DurationMetricName: "http.server.request.duration",
+				InflightMetricName: "http.server.active_requests",
+				HandlerIDLabel:     "http.route",
+				StatusCodeLabel:    "http.response.status_code",
+				MethodLabel:        "http.request.method",
+				ServiceLabel:       "service.name",
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 2*time.Second)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+			},
+			expMetrics: []metricdata.Metrics{
+				{
+					Name:        "http.server.request.duration",
+					Description: "The latency of the HTTP requests.",
+					Unit:        "s",
+					Data: metricdata.Histogram[float64]{
+						Temporality: metricdata.CumulativeTemporality,
+						DataPoints: []metricdata.HistogramDataPoint[float64]{
+							{
+								Attributes:   attribute.NewSet(attribute.String("service.name", "svc1"), attribute.String("http.route", "test1"), attribute.String("http.request.method", "GET"), attribute.String("http.response.status_code", "200")),
+								Bounds:       []float64{1, 5},
+								BucketCounts: []uint64{0, 1, 0},
+								Count:        1,
+								Sum:          2,
+								Min:          metricdata.NewExtrema(2.0),
+								Max:          metricdata.NewExtrema(2.0),
+							},
+						},
+					},
+				},
+				{
+					Name:        "http.server.active_requests",
+					Description: "The number of inflight requests being handled at the same time.",
+					Unit:        "{request}",
+					Data: metricdata.Sum[int64]{
+						Temporality: metricdata.CumulativeTemporality,
+						IsMonotonic: false,
+						DataPoints: []metricdata.DataPoint[int64]{
+							{Attributes: attribute.NewSet(attribute.String("service.name", "svc1"), attribute.String("http.route", "test1")), Value: 1},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			reader := sdkmetric.NewManualReader()
+			test.config.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+			mrecorder, err := otelmetrics.NewRecorder(test.config)
+			require.NoError(err)
+			test.recordMetrics(mrecorder)
+
+			// Collect the metrics.
+			var rm metricdata.ResourceMetrics
+			err = reader.Collect(context.TODO(), &rm)
+			require.NoError(err)
+			require.Len(rm.ScopeMetrics, 1)
+
+			// Check all metrics are present.
+			gotMetrics := map[string]metricdata.Metrics{}
+			for _, m := range rm.ScopeMetrics[0].Metrics {
+				gotMetrics[m.Name] = m
+			}
+			for _, expMetric := range test.expMetrics {
+				gotMetric, ok := gotMetrics[expMetric.Name]
+				require.True(ok, "metric not present on the result")
+				metricdatatest.AssertEqual(t, expMetric, gotMetric, metricdatatest.IgnoreTimestamp())
+			}
+		})
+	}
+}
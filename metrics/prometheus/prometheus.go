@@ -2,9 +2,12 @@ package prometheus
 
 import (
 	"context"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 
 	"github.com/slok/go-http-metrics/metrics"
 )
@@ -13,12 +16,36 @@ import (
 type Config struct {
 	// Prefix is the prefix that will be set on the metrics, by default it will be empty.
 	Prefix string
-	// DurationBuckets are the buckets used by Prometheus for the HTTP request duration metrics,
+	// DurationBuckets are the buckets used by Prometheus for the HTTP request duration and time to first byte metrics,
 	// by default uses Prometheus default buckets (from 5ms to 10s).
 	DurationBuckets []float64
-	// SizeBuckets are the buckets used by Prometheus for the HTTP response size metrics,
+	// SizeBuckets are the buckets used by Prometheus for the HTTP request and response size metrics,
 	// by default uses a exponential buckets from 100B to 1GB.
 	SizeBuckets []float64
+	// StreamDurationBuckets are the buckets used by Prometheus for the HTTP streaming response duration
+	// and hijacked connection duration metrics, by default from 1s to 1h. The streaming response flush
+	// interval and size metrics use the `DurationBuckets` and `SizeBuckets`.
+	StreamDurationBuckets []float64
+	// NativeHistogram will enable Prometheus native (sparse) histograms on all the histogram
+	// metrics (durations, sizes, streams...). By default the classic buckets
+	// are still exposed alongside the native ones, this eases the migration, use
+	// `DisableClassicHistogram` to only expose native histograms. By default native histograms
+	// are disabled.
+	NativeHistogram bool
+	// NativeHistogramBucketFactor is the max growth factor between one native histogram bucket and
+	// the next one, by default is 1.1.
+	NativeHistogramBucketFactor float64
+	// NativeHistogramMaxBucketNumber is the max number of native histogram buckets, by default is 160.
+	NativeHistogramMaxBucketNumber uint32
+	// NativeHistogramMinResetDuration is the min time before resetting a native histogram when
+	// the max number of buckets is reached, by default is 1h.
+	NativeHistogramMinResetDuration time.Duration
+	// NativeHistogramZeroThreshold is the width of the native histogram zero bucket, by default uses
+	// the Prometheus default threshold.
+	NativeHistogramZeroThreshold float64
+	// DisableClassicHistogram will disable the classic buckets when native histograms are enabled,
+	// by default the classic buckets are exposed.
+	DisableClassicHistogram bool
 	// Registry is the registry that will be used by the recorder to store the metrics,
 	// if the default registry is not used then it will use the default one.
 	Registry prometheus.Registerer
@@ -30,6 +57,27 @@ type Config struct {
 	MethodLabel string
 	// ServiceLabel is the name that will be set to the service label, by default is `service`.
 	ServiceLabel string
+	// ProtoLabel is the name that will be set to the HTTP protocol version label, by default is `proto`.
+	ProtoLabel string
+	// SchemeLabel is the name that will be set to the scheme label, by default is `scheme`.
+	SchemeLabel string
+	// EnableProtoLabel will add the HTTP protocol version label to the request metrics (e.g `HTTP/2`),
+	// the middleware needs to measure it (`MeasureProtocol` option). By default is disabled.
+	EnableProtoLabel bool
+	// EnableSchemeLabel will add the scheme label to the request metrics (e.g `https`), the middleware
+	// needs to measure it (`MeasureProtocol` option). By default is disabled.
+	EnableSchemeLabel bool
+	// ExtraLabels are the names of the extra labels (`metrics.Labels`) that will be set on all the
+	// metrics, they need to be declared beforehand so the metrics have a static set of labels. The
+	// extra labels missing on a request will have an empty value, and the undeclared ones will
+	// be ignored. By default there are no extra labels.
+	ExtraLabels []string
+	// ExemplarFromContext returns the exemplar labels (e.g trace and span IDs) that will be attached
+	// to the HTTP request duration and response size observations, it receives the context passed
+	// to the recorder (usually the request context). When it returns no labels or invalid ones (e.g
+	// exceeding the Prometheus 128 runes limit), the observation will be measured without exemplar.
+	// By default exemplars are not measured.
+	ExemplarFromContext func(ctx context.Context) prometheus.Labels
 }
 
 func (c *Config) defaults() {
@@ -41,6 +89,22 @@ func (c *Config) defaults() {
 		c.SizeBuckets = prometheus.ExponentialBuckets(100, 10, 8)
 	}
 
+	if len(c.StreamDurationBuckets) == 0 {
+		c.StreamDurationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600}
+	}
+
+	if c.NativeHistogramBucketFactor <= 1 {
+		c.NativeHistogramBucketFactor = 1.1
+	}
+
+	if c.NativeHistogramMaxBucketNumber == 0 {
+		c.NativeHistogramMaxBucketNumber = 160
+	}
+
+	if c.NativeHistogramMinResetDuration == 0 {
+		c.NativeHistogramMinResetDuration = time.Hour
+	}
+
 	if c.Registry == nil {
 		c.Registry = prometheus.DefaultRegisterer
 	}
@@ -60,12 +124,62 @@ func (c *Config) defaults() {
 	if c.ServiceLabel == "" {
 		c.ServiceLabel = "service"
 	}
+
+	if c.ProtoLabel == "" {
+		c.ProtoLabel = "proto"
+	}
+
+	if c.SchemeLabel == "" {
+		c.SchemeLabel = "scheme"
+	}
+}
+
+// histogramOpts returns the histogram options for the HTTP histograms, using classic
+// and/or native buckets based on the configuration.
+func (c Config) histogramOpts(name, help string, buckets []float64) prometheus.HistogramOpts {
+	opts := prometheus.HistogramOpts{
+		Namespace: c.Prefix,
+		Subsystem: "http",
+		Name:      name,
+		Help:      help,
+		Buckets:   buckets,
+	}
+
+	if !c.NativeHistogram {
+		return opts
+	}
+
+	opts.NativeHistogramBucketFactor = c.NativeHistogramBucketFactor
+	opts.NativeHistogramMaxBucketNumber = c.NativeHistogramMaxBucketNumber
+	opts.NativeHistogramMinResetDuration = c.NativeHistogramMinResetDuration
+	opts.NativeHistogramZeroThreshold = c.NativeHistogramZeroThreshold
+	if c.DisableClassicHistogram {
+		opts.Buckets = nil
+	}
+
+	return opts
 }
 
 type recorder struct {
 	httpRequestDurHistogram   *prometheus.HistogramVec
 	httpResponseSizeHistogram *prometheus.HistogramVec
+	httpRequestSizeHistogram  *prometheus.HistogramVec
+	httpTTFBHistogram         *prometheus.HistogramVec
 	httpRequestsInflight      *prometheus.GaugeVec
+	httpPanics                *prometheus.CounterVec
+	httpClientAborts          *prometheus.CounterVec
+	httpStreamFlushSize       *prometheus.HistogramVec
+	httpStreamFlushInterval   *prometheus.HistogramVec
+	httpStreamDuration        *prometheus.HistogramVec
+	httpHijackedConns         *prometheus.GaugeVec
+	httpHijackedDuration      *prometheus.HistogramVec
+	httpHijackedBytesRead     *prometheus.HistogramVec
+	httpHijackedBytesWritten  *prometheus.HistogramVec
+
+	protoLabel          bool
+	schemeLabel         bool
+	extraLabels         []string
+	exemplarFromContext func(ctx context.Context) prometheus.Labels
 }
 
 // NewRecorder returns a new metrics recorder that implements the recorder
@@ -73,48 +187,204 @@ type recorder struct {
 func NewRecorder(cfg Config) metrics.Recorder {
 	cfg.defaults()
 
+	labels := append([]string{cfg.ServiceLabel, cfg.HandlerIDLabel}, cfg.ExtraLabels...)
+	reqLabels := []string{cfg.ServiceLabel, cfg.HandlerIDLabel, cfg.MethodLabel, cfg.StatusCodeLabel}
+	if cfg.EnableProtoLabel {
+		reqLabels = append(reqLabels, cfg.ProtoLabel)
+	}
+	if cfg.EnableSchemeLabel {
+		reqLabels = append(reqLabels, cfg.SchemeLabel)
+	}
+	reqLabels = append(reqLabels, cfg.ExtraLabels...)
+
 	r := &recorder{
-		httpRequestDurHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		httpRequestDurHistogram: prometheus.NewHistogramVec(
+			cfg.histogramOpts("request_duration_seconds", "The latency of the HTTP requests.", cfg.DurationBuckets),
+			reqLabels),
+
+		httpResponseSizeHistogram: prometheus.NewHistogramVec(
+			cfg.histogramOpts("response_size_bytes", "The size of the HTTP responses.", cfg.SizeBuckets),
+			reqLabels),
+
+		httpRequestSizeHistogram: prometheus.NewHistogramVec(
+			cfg.histogramOpts("request_size_bytes", "The size of the HTTP requests.", cfg.SizeBuckets),
+			reqLabels),
+
+		httpTTFBHistogram: prometheus.NewHistogramVec(
+			cfg.histogramOpts("time_to_first_byte_seconds", "The time until the first byte of the HTTP responses is written.", cfg.DurationBuckets),
+			reqLabels),
+
+		httpRequestsInflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: cfg.Prefix,
 			Subsystem: "http",
-			Name:      "request_duration_seconds",
-			Help:      "The latency of the HTTP requests.",
-			Buckets:   cfg.DurationBuckets,
-		}, []string{cfg.ServiceLabel, cfg.HandlerIDLabel, cfg.MethodLabel, cfg.StatusCodeLabel}),
+			Name:      "requests_inflight",
+			Help:      "The number of inflight requests being handled at the same time.",
+		}, labels),
 
-		httpResponseSizeHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		httpPanics: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: cfg.Prefix,
 			Subsystem: "http",
-			Name:      "response_size_bytes",
-			Help:      "The size of the HTTP responses.",
-			Buckets:   cfg.SizeBuckets,
-		}, []string{cfg.ServiceLabel, cfg.HandlerIDLabel, cfg.MethodLabel, cfg.StatusCodeLabel}),
+			Name:      "panics_total",
+			Help:      "The number of panics of the HTTP handlers.",
+		}, labels),
 
-		httpRequestsInflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		httpClientAborts: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: cfg.Prefix,
 			Subsystem: "http",
-			Name:      "requests_inflight",
-			Help:      "The number of inflight requests being handled at the same time.",
-		}, []string{cfg.ServiceLabel, cfg.HandlerIDLabel}),
+			Name:      "client_aborts_total",
+			Help:      "The number of HTTP requests canceled by the clients.",
+		}, labels),
+
+		httpStreamFlushSize: prometheus.NewHistogramVec(
+			cfg.histogramOpts("response_stream_flush_size_bytes", "The size written between the flushes of the HTTP streaming responses.", cfg.SizeBuckets),
+			reqLabels),
+
+		httpStreamFlushInterval: prometheus.NewHistogramVec(
+			cfg.histogramOpts("response_stream_flush_interval_seconds", "The interval between the flushes of the HTTP streaming responses.", cfg.DurationBuckets),
+			reqLabels),
+
+		httpStreamDuration: prometheus.NewHistogramVec(
+			cfg.histogramOpts("response_stream_duration_seconds", "The lifetime of the HTTP streaming responses.", cfg.StreamDurationBuckets),
+			reqLabels),
+
+		httpHijackedConns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cfg.Prefix,
+			Subsystem: "http",
+			Name:      "hijacked_connections_open",
+			Help:      "The number of open hijacked connections (e.g WebSockets).",
+		}, labels),
+
+		httpHijackedDuration: prometheus.NewHistogramVec(
+			cfg.histogramOpts("hijacked_connection_duration_seconds", "The lifetime of the hijacked connections.", cfg.StreamDurationBuckets),
+			reqLabels),
+
+		httpHijackedBytesRead: prometheus.NewHistogramVec(
+			cfg.histogramOpts("hijacked_connection_read_bytes", "The size read from the hijacked connections.", cfg.SizeBuckets),
+			reqLabels),
+
+		httpHijackedBytesWritten: prometheus.NewHistogramVec(
+			cfg.histogramOpts("hijacked_connection_written_bytes", "The size written to the hijacked connections.", cfg.SizeBuckets),
+			reqLabels),
+
+		protoLabel:          cfg.EnableProtoLabel,
+		schemeLabel:         cfg.EnableSchemeLabel,
+		extraLabels:         cfg.ExtraLabels,
+		exemplarFromContext: cfg.ExemplarFromContext,
 	}
 
 	cfg.Registry.MustRegister(
 		r.httpRequestDurHistogram,
 		r.httpResponseSizeHistogram,
+		r.httpRequestSizeHistogram,
+		r.httpTTFBHistogram,
 		r.httpRequestsInflight,
+		r.httpPanics,
+		r.httpClientAborts,
+		r.httpStreamFlushSize,
+		r.httpStreamFlushInterval,
+		r.httpStreamDuration,
+		r.httpHijackedConns,
+		r.httpHijackedDuration,
+		r.httpHijackedBytesRead,
+		r.httpHijackedBytesWritten,
 	)
 
 	return r
 }
 
-func (r recorder) ObserveHTTPRequestDuration(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
-	r.httpRequestDurHistogram.WithLabelValues(p.Service, p.ID, p.Method, p.Code).Observe(duration.Seconds())
+func (r recorder) ObserveHTTPRequestDuration(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.observe(ctx, r.httpRequestDurHistogram.WithLabelValues(r.reqLabelValues(p)...), duration.Seconds())
 }
 
-func (r recorder) ObserveHTTPResponseSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
-	r.httpResponseSizeHistogram.WithLabelValues(p.Service, p.ID, p.Method, p.Code).Observe(float64(sizeBytes))
+func (r recorder) ObserveHTTPResponseSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.observe(ctx, r.httpResponseSizeHistogram.WithLabelValues(r.reqLabelValues(p)...), float64(sizeBytes))
+}
+
+func (r recorder) ObserveHTTPRequestSize(ctx context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.observe(ctx, r.httpRequestSizeHistogram.WithLabelValues(r.reqLabelValues(p)...), float64(sizeBytes))
+}
+
+func (r recorder) ObserveHTTPTimeToFirstByte(ctx context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.observe(ctx, r.httpTTFBHistogram.WithLabelValues(r.reqLabelValues(p)...), duration.Seconds())
 }
 
 func (r recorder) AddInflightRequests(_ context.Context, p metrics.HTTPProperties, quantity int) {
-	r.httpRequestsInflight.WithLabelValues(p.Service, p.ID).Add(float64(quantity))
+	r.httpRequestsInflight.WithLabelValues(r.labelValues(p)...).Add(float64(quantity))
+}
+
+func (r recorder) IncHTTPPanics(_ context.Context, p metrics.HTTPProperties) {
+	r.httpPanics.WithLabelValues(r.labelValues(p)...).Inc()
+}
+
+func (r recorder) IncHTTPClientAborts(_ context.Context, p metrics.HTTPProperties) {
+	r.httpClientAborts.WithLabelValues(r.labelValues(p)...).Inc()
+}
+
+func (r recorder) ObserveHTTPStreamFlush(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64, interval time.Duration) {
+	values := r.reqLabelValues(p)
+	r.httpStreamFlushSize.WithLabelValues(values...).Observe(float64(sizeBytes))
+	r.httpStreamFlushInterval.WithLabelValues(values...).Observe(interval.Seconds())
+}
+
+func (r recorder) ObserveHTTPStreamDuration(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	r.httpStreamDuration.WithLabelValues(r.reqLabelValues(p)...).Observe(duration.Seconds())
+}
+
+func (r recorder) AddHijackedConnections(_ context.Context, p metrics.HTTPProperties, quantity int) {
+	r.httpHijackedConns.WithLabelValues(r.labelValues(p)...).Add(float64(quantity))
+}
+
+func (r recorder) ObserveHTTPHijackedConnection(_ context.Context, p metrics.HTTPReqProperties, conn metrics.HijackedConnection) {
+	values := r.reqLabelValues(p)
+	r.httpHijackedDuration.WithLabelValues(values...).Observe(conn.Duration.Seconds())
+	r.httpHijackedBytesRead.WithLabelValues(values...).Observe(float64(conn.BytesRead))
+	r.httpHijackedBytesWritten.WithLabelValues(values...).Observe(float64(conn.BytesWritten))
+}
+
+// reqLabelValues returns the label values of the request metrics.
+func (r recorder) reqLabelValues(p metrics.HTTPReqProperties) []string {
+	values := []string{p.Service, p.ID, p.Method, p.Code}
+	if r.protoLabel {
+		values = append(values, p.Proto)
+	}
+	if r.schemeLabel {
+		values = append(values, p.Scheme)
+	}
+	return append(values, p.Labels.Values(r.extraLabels)...)
+}
+
+// labelValues returns the label values of the global server metrics.
+func (r recorder) labelValues(p metrics.HTTPProperties) []string {
+	return append([]string{p.Service, p.ID}, p.Labels.Values(r.extraLabels)...)
+}
+
+// observe will observe the value attaching an exemplar if the context has one.
+func (r recorder) observe(ctx context.Context, o prometheus.Observer, value float64) {
+	if r.exemplarFromContext != nil {
+		if exemplar := r.exemplarFromContext(ctx); len(exemplar) > 0 && validExemplar(exemplar) {
+			if eo, ok := o.(prometheus.ExemplarObserver); ok {
+				eo.ObserveWithExemplar(value, exemplar)
+				return
+			}
+		}
+	}
+
+	o.Observe(value)
+}
+
+// validExemplar returns if the exemplar labels are valid, Prometheus panics observing
+// invalid exemplars.
+func validExemplar(labels prometheus.Labels) bool {
+	runes := 0
+	for name, value := range labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return false
+		}
+		if !utf8.ValidString(value) {
+			return false
+		}
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+	}
+
+	return runes <= prometheus.ExemplarMaxRunes
 }
@@ -5,12 +5,16 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/slok/go-http-metrics/metrics"
 	libprometheus "github.com/slok/go-http-metrics/metrics/prometheus"
@@ -108,6 +112,161 @@ func TestPrometheusRecorder(t *testing.T) {
 				`http_requests_inflight{handler="test2",service="svc2"} 9`,
 			},
 		},
+		{
+			name:   "Measuring request sizes should measure the request size metric.",
+			config: libprometheus.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				rr := r.(metrics.RequestSizeRecorder)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodPost, Code: "201"}, 5000)
+				rr.ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodPost, Code: "201"}, 50)
+			},
+			expMetrics: []string{
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="100"} 1`,
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="1000"} 1`,
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="10000"} 2`,
+				`http_request_size_bytes_bucket{code="201",handler="test1",method="POST",service="svc1",le="+Inf"} 2`,
+				`http_request_size_bytes_count{code="201",handler="test1",method="POST",service="svc1"} 2`,
+				`http_request_size_bytes_sum{code="201",handler="test1",method="POST",service="svc1"} 5050`,
+			},
+		},
+		{
+			name: "Using extra labels in the configuration should measure with the extra labels.",
+			config: libprometheus.Config{
+				ExtraLabels: []string{"tier", "version"},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				labels := metrics.NewLabels(map[string]string{"version": "v2", "undeclared": "ignored"})
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Labels: labels}, 50)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: labels}, 1)
+			},
+			expMetrics: []string{
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",service="svc1",tier="",version="v2"} 1`,
+				`http_requests_inflight{handler="test1",service="svc1",tier="",version="v2"} 1`,
+			},
+		},
+		{
+			name: "Enabling the protocol labels should measure the request metrics with the protocol and scheme.",
+			config: libprometheus.Config{
+				EnableProtoLabel:  true,
+				EnableSchemeLabel: true,
+				ExtraLabels:       []string{"version"},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				labels := metrics.NewLabels(map[string]string{"version": "v2"})
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Proto: "HTTP/2", Scheme: "https", Labels: labels}, 50)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Proto: "HTTP/1.1", Scheme: "http", Labels: labels}, 50)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: labels}, 1)
+			},
+			expMetrics: []string{
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",proto="HTTP/2",scheme="https",service="svc1",version="v2"} 1`,
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",proto="HTTP/1.1",scheme="http",service="svc1",version="v2"} 1`,
+				`http_requests_inflight{handler="test1",service="svc1",version="v2"} 1`,
+			},
+		},
+		{
+			name: "Using custom protocol label names should measure with the custom label names.",
+			config: libprometheus.Config{
+				ProtoLabel:       "protocol",
+				EnableProtoLabel: true,
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200", Proto: "HTTP/3", Scheme: "https"}, 50)
+			},
+			expMetrics: []string{
+				`http_response_size_bytes_count{code="200",handler="test1",method="GET",protocol="HTTP/3",service="svc1"} 1`,
+			},
+		},
+		{
+			name:   "Counting client aborts should measure the client aborts metric.",
+			config: libprometheus.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				ar := r.(metrics.ClientAbortRecorder)
+				ar.IncHTTPClientAborts(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				ar.IncHTTPClientAborts(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+			},
+			expMetrics: []string{
+				`http_client_aborts_total{handler="test1",service="svc1"} 2`,
+			},
+		},
+		{
+			name: "Measuring streams should measure the stream flushes and duration metrics.",
+			config: libprometheus.Config{
+				SizeBuckets:           []float64{10, 100},
+				DurationBuckets:       []float64{1, 5},
+				StreamDurationBuckets: []float64{60, 600},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				sr := r.(metrics.StreamRecorder)
+				props := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}
+				sr.ObserveHTTPStreamFlush(context.TODO(), props, 5, 500*time.Millisecond)
+				sr.ObserveHTTPStreamFlush(context.TODO(), props, 50, 2*time.Second)
+				sr.ObserveHTTPStreamDuration(context.TODO(), props, 120*time.Second)
+			},
+			expMetrics: []string{
+				`http_response_stream_flush_size_bytes_bucket{code="200",handler="test1",method="GET",service="svc1",le="10"} 1`,
+				`http_response_stream_flush_size_bytes_bucket{code="200",handler="test1",method="GET",service="svc1",le="100"} 2`,
+				`http_response_stream_flush_size_bytes_count{code="200",handler="test1",method="GET",service="svc1"} 2`,
+				`http_response_stream_flush_interval_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="1"} 1`,
+				`http_response_stream_flush_interval_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="5"} 2`,
+				`http_response_stream_duration_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="60"} 0`,
+				`http_response_stream_duration_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="600"} 1`,
+			},
+		},
+		{
+			name: "Measuring hijacked connections should measure the open connections and closed connection metrics.",
+			config: libprometheus.Config{
+				SizeBuckets:           []float64{10, 100},
+				StreamDurationBuckets: []float64{60, 600},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				hr := r.(metrics.HijackRecorder)
+				hr.AddHijackedConnections(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				hr.AddHijackedConnections(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				hr.AddHijackedConnections(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, -1)
+				props := metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "101"}
+				hr.ObserveHTTPHijackedConnection(context.TODO(), props, metrics.HijackedConnection{Duration: 120 * time.Second, BytesRead: 5, BytesWritten: 50})
+			},
+			expMetrics: []string{
+				`http_hijacked_connections_open{handler="test1",service="svc1"} 1`,
+				`http_hijacked_connection_duration_seconds_bucket{code="101",handler="test1",method="GET",service="svc1",le="60"} 0`,
+				`http_hijacked_connection_duration_seconds_bucket{code="101",handler="test1",method="GET",service="svc1",le="600"} 1`,
+				`http_hijacked_connection_read_bytes_bucket{code="101",handler="test1",method="GET",service="svc1",le="10"} 1`,
+				`http_hijacked_connection_written_bytes_bucket{code="101",handler="test1",method="GET",service="svc1",le="10"} 0`,
+				`http_hijacked_connection_written_bytes_bucket{code="101",handler="test1",method="GET",service="svc1",le="100"} 1`,
+			},
+		},
+		{
+			name:   "Counting panics should measure the panics metric.",
+			config: libprometheus.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				pr := r.(metrics.PanicRecorder)
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"})
+				pr.IncHTTPPanics(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test2"})
+			},
+			expMetrics: []string{
+				`http_panics_total{handler="test1",service="svc1"} 2`,
+				`http_panics_total{handler="test2",service="svc1"} 1`,
+			},
+		},
+		{
+			name:   "Measuring times to first byte should measure the time to first byte metric.",
+			config: libprometheus.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				rr := r.(metrics.TimeToFirstByteRecorder)
+				rr.ObserveHTTPTimeToFirstByte(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 75*time.Millisecond)
+				rr.ObserveHTTPTimeToFirstByte(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 3*time.Second)
+			},
+			expMetrics: []string{
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="0.05"} 0`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="0.1"} 1`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="2.5"} 1`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="5"} 2`,
+				`http_time_to_first_byte_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="+Inf"} 2`,
+				`http_time_to_first_byte_seconds_count{code="200",handler="test1",method="GET",service="svc1"} 2`,
+				`http_time_to_first_byte_seconds_sum{code="200",handler="test1",method="GET",service="svc1"} 3.075`,
+			},
+		},
 		{
 			name: "Using a prefix in the configuration should measure with prefix.",
 			config: libprometheus.Config{
@@ -214,3 +373,218 @@ func TestPrometheusRecorder(t *testing.T) {
 		})
 	}
 }
+
+func TestPrometheusRecorderNativeHistograms(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        libprometheus.Config
+		recordMetrics func(r metrics.Recorder)
+		expMetrics    func(t *testing.T, mfs map[string]*dto.MetricFamily)
+	}{
+		{
+			name:   "Default configuration should only measure classic histograms.",
+			config: libprometheus.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+			},
+			expMetrics: func(t *testing.T, mfs map[string]*dto.MetricFamily) {
+				h := mfs["http_request_duration_seconds"].GetMetric()[0].GetHistogram()
+				assert.Len(t, h.GetBucket(), len(prometheus.DefBuckets))
+				assert.Empty(t, h.GetPositiveSpan())
+				assert.Zero(t, h.GetSchema())
+			},
+		},
+		{
+			name: "Enabling native histograms should measure native histograms alongside the classic ones.",
+			config: libprometheus.Config{
+				NativeHistogram: true,
+				SizeBuckets:     []float64{100, 1000},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 5*time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 529930)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 0)
+			},
+			expMetrics: func(t *testing.T, mfs map[string]*dto.MetricFamily) {
+				dh := mfs["http_request_duration_seconds"].GetMetric()[0].GetHistogram()
+				assert.Equal(t, uint64(2), dh.GetSampleCount())
+				assert.Len(t, dh.GetBucket(), len(prometheus.DefBuckets))
+				assert.Equal(t, int32(3), dh.GetSchema())
+				assert.Equal(t, prometheus.DefNativeHistogramZeroThreshold, dh.GetZeroThreshold())
+				assert.Len(t, dh.GetPositiveDelta(), 2)
+
+				sh := mfs["http_response_size_bytes"].GetMetric()[0].GetHistogram()
+				assert.Equal(t, uint64(2), sh.GetSampleCount())
+				assert.Len(t, sh.GetBucket(), 2)
+				assert.Equal(t, int32(3), sh.GetSchema())
+				assert.Equal(t, uint64(1), sh.GetZeroCount())
+				assert.Len(t, sh.GetPositiveDelta(), 1)
+			},
+		},
+		{
+			name: "Native histograms with custom settings and classic histograms disabled should only measure native histograms.",
+			config: libprometheus.Config{
+				NativeHistogram:              true,
+				NativeHistogramBucketFactor:  2,
+				NativeHistogramZeroThreshold: 0.001,
+				DisableClassicHistogram:      true,
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 5*time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 500*time.Microsecond)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 529930)
+			},
+			expMetrics: func(t *testing.T, mfs map[string]*dto.MetricFamily) {
+				dh := mfs["http_request_duration_seconds"].GetMetric()[0].GetHistogram()
+				assert.Equal(t, uint64(2), dh.GetSampleCount())
+				assert.Empty(t, dh.GetBucket())
+				assert.Equal(t, int32(0), dh.GetSchema())
+				assert.Equal(t, 0.001, dh.GetZeroThreshold())
+				assert.Equal(t, uint64(1), dh.GetZeroCount())
+
+				sh := mfs["http_response_size_bytes"].GetMetric()[0].GetHistogram()
+				assert.Equal(t, uint64(1), sh.GetSampleCount())
+				assert.Empty(t, sh.GetBucket())
+				assert.Equal(t, int32(0), sh.GetSchema())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			reg := prometheus.NewRegistry()
+			test.config.Registry = reg
+			mrecorder := libprometheus.NewRecorder(test.config)
+			test.recordMetrics(mrecorder)
+
+			// Get the metrics handler and serve using the protobuf format, native
+			// histograms are only exposed with this format.
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+			promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, req)
+
+			resp := rec.Result()
+			require.Equal(http.StatusOK, resp.StatusCode)
+
+			// Decode the metric families.
+			mfs := map[string]*dto.MetricFamily{}
+			dec := expfmt.NewDecoder(resp.Body, expfmt.ResponseFormat(resp.Header))
+			for {
+				mf := &dto.MetricFamily{}
+				err := dec.Decode(mf)
+				if err == io.EOF {
+					break
+				}
+				require.NoError(err)
+				mfs[mf.GetName()] = mf
+			}
+
+			test.expMetrics(t, mfs)
+		})
+	}
+}
+
+type traceIDCtxKey struct{}
+
+func TestPrometheusRecorderExemplars(t *testing.T) {
+	exemplarFromContext := func(ctx context.Context) prometheus.Labels {
+		traceID, _ := ctx.Value(traceIDCtxKey{}).(string)
+		if traceID == "" {
+			return nil
+		}
+		return prometheus.Labels{"trace_id": traceID}
+	}
+
+	tests := []struct {
+		name          string
+		config        libprometheus.Config
+		recordMetrics func(r metrics.Recorder)
+		expMetrics    []string
+		expNoMetrics  []string
+	}{
+		{
+			name:   "Default configuration should not measure exemplars.",
+			config: libprometheus.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				ctx := context.WithValue(context.TODO(), traceIDCtxKey{}, "abc123")
+				r.ObserveHTTPRequestDuration(ctx, metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+			},
+			expMetrics: []string{
+				`http_request_duration_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="0.25"} 1` + "\n",
+			},
+			expNoMetrics: []string{
+				`trace_id="abc123"`,
+			},
+		},
+		{
+			name: "Using an exemplar extractor should measure exemplars from the context.",
+			config: libprometheus.Config{
+				ExemplarFromContext: exemplarFromContext,
+				SizeBuckets:         []float64{100, 1000},
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				ctx := context.WithValue(context.TODO(), traceIDCtxKey{}, "abc123")
+				r.ObserveHTTPRequestDuration(ctx, metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+				r.ObserveHTTPResponseSize(ctx, metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 231)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test2", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+			},
+			expMetrics: []string{
+				`http_request_duration_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="0.25"} 1 # {trace_id="abc123"} 0.175 `,
+				`http_response_size_bytes_bucket{code="200",handler="test1",method="GET",service="svc1",le="1000.0"} 1 # {trace_id="abc123"} 231.0 `,
+				`http_request_duration_seconds_bucket{code="200",handler="test2",method="GET",service="svc1",le="0.25"} 1` + "\n",
+			},
+		},
+		{
+			name: "Invalid exemplars should measure the observations without exemplar.",
+			config: libprometheus.Config{
+				ExemplarFromContext: exemplarFromContext,
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				ctx := context.WithValue(context.TODO(), traceIDCtxKey{}, strings.Repeat("a", 200))
+				r.ObserveHTTPRequestDuration(ctx, metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+				ctx = context.WithValue(context.TODO(), traceIDCtxKey{}, "\xff")
+				r.ObserveHTTPRequestDuration(ctx, metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+			},
+			expMetrics: []string{
+				`http_request_duration_seconds_bucket{code="200",handler="test1",method="GET",service="svc1",le="0.25"} 2` + "\n",
+			},
+			expNoMetrics: []string{
+				`trace_id=`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			reg := prometheus.NewRegistry()
+			test.config.Registry = reg
+			mrecorder := libprometheus.NewRecorder(test.config)
+			test.recordMetrics(mrecorder)
+
+			// Get the metrics handler and serve using OpenMetrics format, exemplars
+			// are not exposed using the Prometheus text format.
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeOpenMetrics)))
+			promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(rec, req)
+
+			resp := rec.Result()
+			require.Equal(http.StatusOK, resp.StatusCode)
+
+			body, _ := io.ReadAll(resp.Body)
+			for _, expMetric := range test.expMetrics {
+				assert.Contains(string(body), expMetric, "metric not present on the result")
+			}
+			for _, expNoMetric := range test.expNoMetrics {
+				assert.NotContains(string(body), expNoMetric, "metric present on the result")
+			}
+		})
+	}
+}
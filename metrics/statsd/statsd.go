@@ -0,0 +1,353 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// Format is the wire format used to send the metrics.
+type Format int
+
+const (
+	// FormatStatsD is the plain StatsD format, as it doesn't support tags, the tag
+	// values are appended to the metric name (e.g `http.request_duration.svc1.test1.GET.200`).
+	FormatStatsD Format = iota
+	// FormatDogStatsD is the DogStatsD format, the properties are sent as tags
+	// (e.g `http.request_duration:12|ms|#service:svc1,handler:test1`).
+	FormatDogStatsD
+)
+
+// Config has the dependencies and values of the recorder.
+type Config struct {
+	// Address is the address of the StatsD agent (e.g `127.0.0.1:8125` or `/var/run/datadog/dsd.socket`).
+	Address string
+	// Network is the network used to connect to the StatsD agent, `udp` and `unixgram`
+	// are supported, by default is `udp`.
+	Network string
+	// Format is the format of the sent metrics, by default is plain StatsD.
+	Format Format
+	// Prefix is the prefix that will be set on the metrics, by default it will be empty.
+	Prefix string
+	// MaxPacketSize is the max size in bytes of a packet, the metrics will be batched
+	// on the client until this size is reached, by default is 1432 (safe for UDP over Ethernet).
+	MaxPacketSize int
+	// FlushInterval is the interval where the batched metrics will be sent regardless
+	// of the packet size, by default is 100ms.
+	FlushInterval time.Duration
+	// HandlerIDLabel is the name that will be set to the handler ID tag, by default is `handler`.
+	HandlerIDLabel string
+	// StatusCodeLabel is the name that will be set to the status code tag, by default is `code`.
+	StatusCodeLabel string
+	// MethodLabel is the name that will be set to the method tag, by default is `method`.
+	MethodLabel string
+	// ServiceLabel is the name that will be set to the service tag, by default is `service`.
+	ServiceLabel string
+}
+
+func (c *Config) defaults() error {
+	if c.Address == "" {
+		return errors.New("address is required")
+	}
+
+	if c.Network == "" {
+		c.Network = "udp"
+	}
+
+	if c.Network != "udp" && c.Network != "unixgram" {
+		return fmt.Errorf("unsupported network %q", c.Network)
+	}
+
+	if c.Format != FormatStatsD && c.Format != FormatDogStatsD {
+		return fmt.Errorf("unsupported format %d", c.Format)
+	}
+
+	if c.MaxPacketSize <= 0 {
+		c.MaxPacketSize = 1432
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = 100 * time.Millisecond
+	}
+
+	if c.HandlerIDLabel == "" {
+		c.HandlerIDLabel = "handler"
+	}
+
+	if c.StatusCodeLabel == "" {
+		c.StatusCodeLabel = "code"
+	}
+
+	if c.MethodLabel == "" {
+		c.MethodLabel = "method"
+	}
+
+	if c.ServiceLabel == "" {
+		c.ServiceLabel = "service"
+	}
+
+	return nil
+}
+
+// Recorder is a metrics recorder that sends the metrics to a StatsD agent.
+//
+// The metrics are batched on the client and flushed when the max packet size
+// is reached or on every flush interval. The inflight requests are tracked on
+// the client and sent as absolute gauges, so they are compatible with
+// agents that don't support relative gauges.
+type Recorder struct {
+	cfg  Config
+	conn net.Conn
+
+	durationName string
+	sizeName     string
+	reqSizeName  string
+	inflightName string
+
+	mu       sync.Mutex
+	buf      []byte
+	inflight map[metrics.HTTPProperties]int64
+
+	stopC chan struct{}
+	doneC chan struct{}
+	once  sync.Once
+}
+
+// NewRecorder returns a new metrics recorder that implements the recorder
+// using StatsD as the backend.
+func NewRecorder(cfg Config) (*Recorder, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	conn, err := net.Dial(cfg.Network, cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to StatsD: %w", err)
+	}
+
+	prefix := "http."
+	if cfg.Prefix != "" {
+		prefix = cfg.Prefix + "." + prefix
+	}
+
+	r := &Recorder{
+		cfg:          cfg,
+		conn:         conn,
+		durationName: prefix + "request_duration",
+		sizeName:     prefix + "response_size_bytes",
+		reqSizeName:  prefix + "request_size_bytes",
+		inflightName: prefix + "requests_inflight",
+		buf:          make([]byte, 0, cfg.MaxPacketSize),
+		inflight:     map[metrics.HTTPProperties]int64{},
+		stopC:        make(chan struct{}),
+		doneC:        make(chan struct{}),
+	}
+
+	go r.flushLoop()
+
+	return r, nil
+}
+
+// ObserveHTTPRequestDuration satisfies metrics.Recorder interface. The durations are sent
+// as timers, in milliseconds.
+func (r *Recorder) ObserveHTTPRequestDuration(_ context.Context, p metrics.HTTPReqProperties, duration time.Duration) {
+	value := strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', -1, 64)
+	r.write(r.durationName, value, "ms", r.httpReqTags(p))
+}
+
+// ObserveHTTPResponseSize satisfies metrics.Recorder interface.
+func (r *Recorder) ObserveHTTPResponseSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.write(r.sizeName, strconv.FormatInt(sizeBytes, 10), r.distributionType(), r.httpReqTags(p))
+}
+
+// ObserveHTTPRequestSize satisfies metrics.RequestSizeRecorder interface.
+func (r *Recorder) ObserveHTTPRequestSize(_ context.Context, p metrics.HTTPReqProperties, sizeBytes int64) {
+	r.write(r.reqSizeName, strconv.FormatInt(sizeBytes, 10), r.distributionType(), r.httpReqTags(p))
+}
+
+// distributionType returns the metric type used for the size distributions.
+func (r *Recorder) distributionType() string {
+	// Plain StatsD doesn't have histograms, timers are the distribution type.
+	if r.cfg.Format == FormatDogStatsD {
+		return "h"
+	}
+	return "ms"
+}
+
+// AddInflightRequests satisfies metrics.Recorder interface.
+func (r *Recorder) AddInflightRequests(_ context.Context, p metrics.HTTPProperties, quantity int) {
+	tags := []tag{
+		{key: r.cfg.ServiceLabel, value: p.Service},
+		{key: r.cfg.HandlerIDLabel, value: p.ID},
+	}
+
+	// The extra labels are not sent, so the inflight requests of all the labels
+	// of a handler are aggregated on the same gauge.
+	key := metrics.HTTPProperties{Service: p.Service, ID: p.ID}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inflight := r.inflight[key] + int64(quantity)
+	// The handlers without inflight requests are removed, so the high cardinality
+	// handler IDs don't grow the map.
+	if inflight == 0 {
+		delete(r.inflight, key)
+	} else {
+		r.inflight[key] = inflight
+	}
+	r.writeLocked(r.formatLine(r.inflightName, strconv.FormatInt(inflight, 10), "g", tags))
+}
+
+// Flush sends the batched metrics to the StatsD agent.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.flushLocked()
+}
+
+// Close stops the recorder flushing the pending metrics and closing the connection.
+// The recorder should not be used after closing it.
+func (r *Recorder) Close() error {
+	r.once.Do(func() { close(r.stopC) })
+	<-r.doneC
+
+	err := r.Flush()
+	if cerr := r.conn.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (r *Recorder) flushLoop() {
+	defer close(r.doneC)
+
+	t := time.NewTicker(r.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stopC:
+			return
+		case <-t.C:
+			_ = r.Flush()
+		}
+	}
+}
+
+type tag struct {
+	key   string
+	value string
+}
+
+func (r *Recorder) httpReqTags(p metrics.HTTPReqProperties) []tag {
+	return []tag{
+		{key: r.cfg.ServiceLabel, value: p.Service},
+		{key: r.cfg.HandlerIDLabel, value: p.ID},
+		{key: r.cfg.MethodLabel, value: p.Method},
+		{key: r.cfg.StatusCodeLabel, value: p.Code},
+	}
+}
+
+func (r *Recorder) write(name, value, metricType string, tags []tag) {
+	line := r.formatLine(name, value, metricType, tags)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeLocked(line)
+}
+
+func (r *Recorder) writeLocked(line string) {
+	// Send the current batch if the new metric doesn't fit in the packet.
+	if len(r.buf) > 0 && len(r.buf)+1+len(line) > r.cfg.MaxPacketSize {
+		_ = r.flushLocked()
+	}
+
+	if len(r.buf) > 0 {
+		r.buf = append(r.buf, '\n')
+	}
+	r.buf = append(r.buf, line...)
+}
+
+func (r *Recorder) flushLocked() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+
+	_, err := r.conn.Write(r.buf)
+	r.buf = r.buf[:0]
+
+	return err
+}
+
+func (r *Recorder) formatLine(name, value, metricType string, tags []tag) string {
+	var b strings.Builder
+
+	switch r.cfg.Format {
+	case FormatDogStatsD:
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(value)
+		b.WriteByte('|')
+		b.WriteString(metricType)
+		b.WriteString("|#")
+		for i, t := range tags {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(sanitizeTag(t.key))
+			b.WriteByte(':')
+			b.WriteString(sanitizeTag(t.value))
+		}
+	default:
+		b.WriteString(name)
+		for _, t := range tags {
+			b.WriteByte('.')
+			b.WriteString(sanitizeName(t.value))
+		}
+		b.WriteByte(':')
+		b.WriteString(value)
+		b.WriteByte('|')
+		b.WriteString(metricType)
+	}
+
+	return b.String()
+}
+
+// sanitizeName sanitizes a value so it can be used as part of a plain StatsD metric name.
+func sanitizeName(s string) string {
+	if s == "" {
+		return "_"
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', '#', ',', '/', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// sanitizeTag sanitizes a value so it can be used as part of a DogStatsD tag.
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+var (
+	_ metrics.Recorder            = &Recorder{}
+	_ metrics.RequestSizeRecorder = &Recorder{}
+)
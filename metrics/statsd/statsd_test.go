@@ -0,0 +1,203 @@
+package statsd_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/go-http-metrics/metrics"
+	statsdmetrics "github.com/slok/go-http-metrics/metrics/statsd"
+)
+
+func TestStatsDRecorder(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        statsdmetrics.Config
+		recordMetrics func(r metrics.Recorder)
+		expPackets    []string
+	}{
+		{
+			name:   "Default configuration should send plain StatsD metrics.",
+			config: statsdmetrics.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "/test/1", Method: http.MethodGet, Code: "200"}, 175*time.Millisecond)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "/test/1", Method: http.MethodGet, Code: "200"}, 231)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{ID: "test2"}, 5)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{ID: "test2"}, -3)
+			},
+			expPackets: []string{
+				"http.request_duration.svc1._test_1.GET.200:175|ms\n" +
+					"http.response_size_bytes.svc1._test_1.GET.200:231|ms\n" +
+					"http.requests_inflight._.test2:5|g\n" +
+					"http.requests_inflight._.test2:2|g",
+			},
+		},
+		{
+			name: "DogStatsD format should send the properties as tags.",
+			config: statsdmetrics.Config{
+				Format: statsdmetrics.FormatDogStatsD,
+				Prefix: "batman",
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "/test/1", Method: http.MethodGet, Code: "200"}, 1500*time.Microsecond)
+				r.ObserveHTTPResponseSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "/test/1", Method: http.MethodGet, Code: "200"}, 231)
+				r.(metrics.RequestSizeRecorder).ObserveHTTPRequestSize(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "/test/1", Method: http.MethodGet, Code: "200"}, 512)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test2"}, 1)
+			},
+			expPackets: []string{
+				"batman.http.request_duration:1.5|ms|#service:svc1,handler:/test/1,method:GET,code:200\n" +
+					"batman.http.response_size_bytes:231|h|#service:svc1,handler:/test/1,method:GET,code:200\n" +
+					"batman.http.request_size_bytes:512|h|#service:svc1,handler:/test/1,method:GET,code:200\n" +
+					"batman.http.requests_inflight:1|g|#service:svc1,handler:test2",
+			},
+		},
+		{
+			name: "Using custom labels in the configuration should send the tags with those names.",
+			config: statsdmetrics.Config{
+				Format:          statsdmetrics.FormatDogStatsD,
+				HandlerIDLabel:  "route_id",
+				StatusCodeLabel: "status_code",
+				MethodLabel:     "http_method",
+				ServiceLabel:    "http_service",
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 2*time.Second)
+			},
+			expPackets: []string{
+				"http.request_duration:2000|ms|#http_service:svc1,route_id:test1,http_method:GET,status_code:200",
+			},
+		},
+		{
+			name:   "Inflight requests with extra labels should be aggregated by service and handler.",
+			config: statsdmetrics.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: metrics.NewLabels(map[string]string{"version": "v1"})}, 1)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: metrics.NewLabels(map[string]string{"version": "v2"})}, 2)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1", Labels: metrics.NewLabels(map[string]string{"version": "v1"})}, -1)
+			},
+			expPackets: []string{
+				"http.requests_inflight.svc1.test1:1|g\n" +
+					"http.requests_inflight.svc1.test1:3|g\n" +
+					"http.requests_inflight.svc1.test1:4|g\n" +
+					"http.requests_inflight.svc1.test1:3|g",
+			},
+		},
+		{
+			name:   "Inflight requests that go back to zero should start again from zero.",
+			config: statsdmetrics.Config{},
+			recordMetrics: func(r metrics.Recorder) {
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, -1)
+				r.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 2)
+			},
+			expPackets: []string{
+				"http.requests_inflight.svc1.test1:1|g\n" +
+					"http.requests_inflight.svc1.test1:0|g\n" +
+					"http.requests_inflight.svc1.test1:2|g",
+			},
+		},
+		{
+			name: "Metrics that don't fit in the packet size should be batched in multiple packets.",
+			config: statsdmetrics.Config{
+				MaxPacketSize: 120,
+			},
+			recordMetrics: func(r metrics.Recorder) {
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 1*time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 2*time.Second)
+				r.ObserveHTTPRequestDuration(context.TODO(), metrics.HTTPReqProperties{Service: "svc1", ID: "test1", Method: http.MethodGet, Code: "200"}, 3*time.Second)
+			},
+			expPackets: []string{
+				"http.request_duration.svc1.test1.GET.200:1000|ms\n" +
+					"http.request_duration.svc1.test1.GET.200:2000|ms",
+				"http.request_duration.svc1.test1.GET.200:3000|ms",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Listen as the StatsD agent.
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(err)
+			defer conn.Close()
+
+			test.config.Address = conn.LocalAddr().String()
+			test.config.FlushInterval = time.Hour
+			mrecorder, err := statsdmetrics.NewRecorder(test.config)
+			require.NoError(err)
+			test.recordMetrics(mrecorder)
+			require.NoError(mrecorder.Close())
+
+			// Check all the packets are received.
+			gotPackets := readPackets(t, conn, len(test.expPackets))
+			assert.Equal(test.expPackets, gotPackets)
+		})
+	}
+}
+
+func TestStatsDRecorderFlushInterval(t *testing.T) {
+	require := require.New(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(err)
+	defer conn.Close()
+
+	mrecorder, err := statsdmetrics.NewRecorder(statsdmetrics.Config{
+		Address:       conn.LocalAddr().String(),
+		FlushInterval: 10 * time.Millisecond,
+	})
+	require.NoError(err)
+	defer mrecorder.Close()
+
+	// Without flushing manually, the interval should send the metrics.
+	mrecorder.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 1)
+	gotPackets := readPackets(t, conn, 1)
+	assert.Equal(t, []string{"http.requests_inflight.svc1.test1:1|g"}, gotPackets)
+}
+
+func TestStatsDRecorderUnixgram(t *testing.T) {
+	require := require.New(t)
+
+	addr := filepath.Join(t.TempDir(), "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", addr)
+	require.NoError(err)
+	defer conn.Close()
+
+	mrecorder, err := statsdmetrics.NewRecorder(statsdmetrics.Config{
+		Address: addr,
+		Network: "unixgram",
+		Format:  statsdmetrics.FormatDogStatsD,
+	})
+	require.NoError(err)
+	mrecorder.AddInflightRequests(context.TODO(), metrics.HTTPProperties{Service: "svc1", ID: "test1"}, 3)
+	require.NoError(mrecorder.Close())
+
+	gotPackets := readPackets(t, conn, 1)
+	assert.Equal(t, []string{"http.requests_inflight:3|g|#service:svc1,handler:test1"}, gotPackets)
+}
+
+func readPackets(t *testing.T, conn net.PacketConn, n int) []string {
+	t.Helper()
+
+	packets := []string{}
+	buf := make([]byte, 65535)
+	for i := 0; i < n; i++ {
+		err := conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		require.NoError(t, err)
+		l, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		packets = append(packets, string(buf[:l]))
+	}
+
+	return packets
+}
@@ -0,0 +1,42 @@
+// Package chi is a helper package to get a chi compatible middleware that measures
+// the requests using the chi route patterns as the handler IDs.
+package chi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/slok/go-http-metrics/middleware"
+	"github.com/slok/go-http-metrics/middleware/std"
+)
+
+// unmatchedHandlerID is the default handler ID of the requests not matched by a route.
+const unmatchedHandlerID = "unmatched"
+
+// Handler returns a chi measuring middleware that uses the route pattern of the request
+// (e.g `/users/{id}`) as the handler ID, including the patterns of the mounted routers and
+// subrouters (e.g `/api/v1/users/{id}`). chi doesn't have route names.
+//
+// The middleware needs to be used on the chi router (e.g `r.Use(...)`), chi routes the request
+// after the middleware, so the handler ID is resolved after the handler finishes. The requests
+// not matched by a route are measured with the fallbackID, by default `unmatched`, and because
+// the requests are counted as inflight before being routed, the inflight requests metric always
+// uses the fallbackID. The handler ID set by the handlers with `middleware.SetHandlerID` is kept.
+func Handler(fallbackID string, m middleware.Middleware) func(http.Handler) http.Handler {
+	if fallbackID == "" {
+		fallbackID = unmatchedHandlerID
+	}
+
+	return func(next http.Handler) http.Handler {
+		return std.Handler(fallbackID, m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if pattern := chi.RouteContext(r.Context()).RoutePattern(); pattern != "" {
+					middleware.SetDefaultHandlerID(r.Context(), pattern)
+				}
+			}()
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
@@ -0,0 +1,135 @@
+package chi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+	chimiddleware "github.com/slok/go-http-metrics/middleware/chi"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		fallbackID string
+		router     func(mdlw func(http.Handler) http.Handler) http.Handler
+		req        *http.Request
+		expID      string
+		expCode    string
+	}{
+		"A routed request should be measured with the route pattern.": {
+			router: func(mdlw func(http.Handler) http.Handler) http.Handler {
+				r := chi.NewRouter()
+				r.Use(mdlw)
+				r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				return r
+			},
+			req:     httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expID:   "/users/{id}",
+			expCode: "200",
+		},
+
+		"A routed request whose handler sets the handler ID should be measured with the handler ID.": {
+			router: func(mdlw func(http.Handler) http.Handler) http.Handler {
+				r := chi.NewRouter()
+				r.Use(mdlw)
+				r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+					middleware.SetHandlerID(r.Context(), "get-user")
+				})
+				return r
+			},
+			req:     httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expID:   "get-user",
+			expCode: "200",
+		},
+
+		"A request routed by a subrouter should be measured with the full route pattern.": {
+			router: func(mdlw func(http.Handler) http.Handler) http.Handler {
+				r := chi.NewRouter()
+				r.Use(mdlw)
+				r.Route("/api/{version}", func(r chi.Router) {
+					r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				})
+				return r
+			},
+			req:     httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil),
+			expID:   "/api/{version}/users/{id}",
+			expCode: "200",
+		},
+
+		"A request routed by a mounted router should be measured with the full route pattern.": {
+			router: func(mdlw func(http.Handler) http.Handler) http.Handler {
+				users := chi.NewRouter()
+				users.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+				r := chi.NewRouter()
+				r.Use(mdlw)
+				r.Mount("/users", users)
+				return r
+			},
+			req:     httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expID:   "/users/{id}",
+			expCode: "200",
+		},
+
+		"A middleware on a mounted router should be measured with the full route pattern.": {
+			router: func(mdlw func(http.Handler) http.Handler) http.Handler {
+				users := chi.NewRouter()
+				users.Use(mdlw)
+				users.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+				r := chi.NewRouter()
+				r.Mount("/users", users)
+				return r
+			},
+			req:     httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expID:   "/users/{id}",
+			expCode: "200",
+		},
+
+		"A request not routed should be measured with the default fallback ID.": {
+			router: func(mdlw func(http.Handler) http.Handler) http.Handler {
+				r := chi.NewRouter()
+				r.Use(mdlw)
+				r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				return r
+			},
+			req:     httptest.NewRequest(http.MethodGet, "/posts/42", nil),
+			expID:   "unmatched",
+			expCode: "404",
+		},
+
+		"A request not routed should be measured with the fallback ID.": {
+			fallbackID: "not-found",
+			router: func(mdlw func(http.Handler) http.Handler) http.Handler {
+				r := chi.NewRouter()
+				r.Use(mdlw)
+				r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				return r
+			},
+			req:     httptest.NewRequest(http.MethodGet, "/posts/42", nil),
+			expID:   "not-found",
+			expCode: "404",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec})
+			h := test.router(chimiddleware.Handler(test.fallbackID, mdlw))
+			h.ServeHTTP(httptest.NewRecorder(), test.req)
+
+			snapshot := mrec.Snapshot()
+			assert.Len(snapshot.RequestDurations, 1)
+			assert.Contains(snapshot.RequestDurations, metrics.HTTPReqProperties{ID: test.expID, Method: test.req.Method, Code: test.expCode})
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package chi_test
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
+	"github.com/slok/go-http-metrics/middleware"
+	chimiddleware "github.com/slok/go-http-metrics/middleware/chi"
+)
+
+// ChiMiddleware shows how you would create a default middleware factory and use it
+// to create a chi compatible middleware that measures the route patterns.
+func Example_chiMiddleware() {
+	// Create our middleware factory with the default settings.
+	mdlw := middleware.New(middleware.Config{
+		Recorder: metrics.NewRecorder(metrics.Config{}),
+	})
+
+	// Create our chi router and add our middleware.
+	r := chi.NewRouter()
+	r.Use(chimiddleware.Handler("", mdlw))
+
+	// Add our handler, it will be measured as `/users/{id}`.
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("Hello " + chi.URLParam(r, "id")))
+	})
+
+	// Serve metrics from the default prometheus registry.
+	log.Printf("serving metrics at: %s", ":8081")
+	go func() {
+		_ = http.ListenAndServe(":8081", promhttp.Handler())
+	}()
+
+	// Serve our handler.
+	log.Printf("listening at: %s", ":8080")
+	if err := http.ListenAndServe(":8080", r); err != nil {
+		log.Panicf("error while serving: %s", err)
+	}
+}
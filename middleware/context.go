@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// overridesKey is the request context key of the measurement overrides.
+type overridesKey struct{}
+
+// overrides are the measurement values that the handlers can set while they
+// are being measured.
+type overrides struct {
+	mu        sync.Mutex
+	handlerID string
+	labels    map[string]string
+	skip      bool
+}
+
+// apply returns the handler ID and labels with the overrides applied, and if the
+// measurement has been skipped.
+func (o *overrides) apply(handlerID string, labels metrics.Labels) (string, metrics.Labels, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.handlerID != "" {
+		handlerID = o.handlerID
+	}
+
+	if len(o.labels) > 0 {
+		kvs := labels.Map()
+		for k, v := range o.labels {
+			kvs[k] = v
+		}
+		labels = metrics.NewLabels(kvs)
+	}
+
+	return handlerID, labels, o.skip
+}
+
+// SetHandlerID sets the handler ID of the request being measured, the handlers can use
+// it when the middleware doesn't know the handler ID (e.g the route template is only known
+// by the handler). The ID is used when the request finishes, so the duration and size metrics
+// will use it, the inflight requests metric will still use the initial handler ID.
+//
+// The context must be the request context that the handler receives, if the request
+// is not being measured or the Reporter doesn't implement `ContextValueSetter`, it will
+// do nothing. When multiple measuring middlewares are nested, it sets the ID of the
+// innermost one.
+func SetHandlerID(ctx context.Context, id string) {
+	o, ok := ctx.Value(overridesKey{}).(*overrides)
+	if !ok {
+		return
+	}
+
+	o.mu.Lock()
+	o.handlerID = id
+	o.mu.Unlock()
+}
+
+// SetDefaultHandlerID sets the handler ID of the request being measured like `SetHandlerID`,
+// unless it has already been set. The middlewares that resolve the handler ID after the handler
+// (e.g with the route patterns) use it, so the ID set by the handlers is not overridden.
+func SetDefaultHandlerID(ctx context.Context, id string) {
+	o, ok := ctx.Value(overridesKey{}).(*overrides)
+	if !ok {
+		return
+	}
+
+	o.mu.Lock()
+	if o.handlerID == "" {
+		o.handlerID = id
+	}
+	o.mu.Unlock()
+}
+
+// SetLabel sets an extra label (`metrics.Labels`) of the request being measured, the handlers
+// can use it for the labels only known by the handler (e.g the route name). Like `SetHandlerID`,
+// the label is used when the request finishes, so the inflight requests metric will not have it.
+// The value must be a bounded set to not explode the metrics cardinality.
+//
+// The context must be the request context that the handler receives, if the request
+// is not being measured or the Reporter doesn't implement `ContextValueSetter`, it will
+// do nothing.
+func SetLabel(ctx context.Context, key, value string) {
+	o, ok := ctx.Value(overridesKey{}).(*overrides)
+	if !ok {
+		return
+	}
+
+	o.mu.Lock()
+	if o.labels == nil {
+		o.labels = map[string]string{}
+	}
+	o.labels[key] = value
+	o.mu.Unlock()
+}
+
+// SkipMeasurement skips the measurement of the request, the handlers can use it to opt out
+// from being measured. Like the requests skipped by the `Skipper`, the request will still be
+// counted in the RequestsInflight metric.
+//
+// The context must be the request context that the handler receives, if the request
+// is not being measured or the Reporter doesn't implement `ContextValueSetter`, it will
+// do nothing. When multiple measuring middlewares are nested, it skips the innermost one.
+func SkipMeasurement(ctx context.Context) {
+	o, ok := ctx.Value(overridesKey{}).(*overrides)
+	if !ok {
+		return
+	}
+
+	o.mu.Lock()
+	o.skip = true
+	o.mu.Unlock()
+}
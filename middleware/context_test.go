@@ -0,0 +1,153 @@
+package middleware_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mockmiddleware "github.com/slok/go-http-metrics/internal/mocks/middleware"
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+)
+
+type contextReporter struct {
+	*mockmiddleware.Reporter
+	ctx context.Context
+}
+
+func (r *contextReporter) Context() context.Context { return r.ctx }
+
+func (r *contextReporter) SetContextValue(key, value any) {
+	r.ctx = context.WithValue(r.ctx, key, value)
+}
+
+func TestMiddlewareContextOverrides(t *testing.T) {
+	tests := map[string]struct {
+		handler     func(ctx context.Context)
+		expInflight map[string]int
+		expCounts   map[string]int
+	}{
+		"Without overrides, the request should be measured with the initial handler ID.": {
+			handler:     func(ctx context.Context) {},
+			expInflight: map[string]int{"initial": 1},
+			expCounts:   map[string]int{"initial": 1},
+		},
+
+		"Setting the handler ID should measure the request with the new handler ID, and inflights with the initial one.": {
+			handler: func(ctx context.Context) {
+				middleware.SetHandlerID(ctx, "/users/{id}")
+			},
+			expInflight: map[string]int{"initial": 1, "/users/{id}": 0},
+			expCounts:   map[string]int{"initial": 0, "/users/{id}": 1},
+		},
+
+		"Setting the handler ID multiple times should use the last one.": {
+			handler: func(ctx context.Context) {
+				middleware.SetHandlerID(ctx, "/users/{id}")
+				middleware.SetHandlerID(ctx, "/users/{id}/posts")
+			},
+			expInflight: map[string]int{"initial": 1},
+			expCounts:   map[string]int{"initial": 0, "/users/{id}": 0, "/users/{id}/posts": 1},
+		},
+
+		"Setting the default handler ID should measure the request with the new handler ID.": {
+			handler: func(ctx context.Context) {
+				middleware.SetDefaultHandlerID(ctx, "/users/{id}")
+			},
+			expInflight: map[string]int{"initial": 1},
+			expCounts:   map[string]int{"initial": 0, "/users/{id}": 1},
+		},
+
+		"Setting the default handler ID should not override the handler ID already set.": {
+			handler: func(ctx context.Context) {
+				middleware.SetHandlerID(ctx, "get-user")
+				middleware.SetDefaultHandlerID(ctx, "/users/{id}")
+			},
+			expInflight: map[string]int{"initial": 1},
+			expCounts:   map[string]int{"initial": 0, "get-user": 1, "/users/{id}": 0},
+		},
+
+		"Skipping the measurement should not measure the request, but count it as inflight.": {
+			handler: func(ctx context.Context) {
+				middleware.SetHandlerID(ctx, "/users/{id}")
+				middleware.SkipMeasurement(ctx)
+			},
+			expInflight: map[string]int{"initial": 1},
+			expCounts:   map[string]int{"initial": 0, "/users/{id}": 0},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(0))
+			mrep.On("URLPath").Return("/users/42")
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec})
+
+			reporter := &contextReporter{Reporter: mrep, ctx: context.TODO()}
+			inflight := map[string]int{}
+			mdlw.Measure("initial", reporter, func() {
+				test.handler(reporter.Context())
+				for id := range test.expInflight {
+					inflight[id] = mrec.InflightRequests(metrics.HTTPProperties{ID: id})
+				}
+			})
+
+			require.Equal(test.expInflight, inflight)
+			for id, exp := range test.expCounts {
+				assert.Equal(exp, mrec.RequestCount(memory.Query{ID: id}), id)
+			}
+			assert.Equal(0, mrec.InflightRequests(metrics.HTTPProperties{ID: "initial"}))
+		})
+	}
+}
+
+func TestContextOverridesWithoutMeasurement(t *testing.T) {
+	// Calling them out of a measured request should do nothing.
+	assert.NotPanics(t, func() {
+		middleware.SetHandlerID(context.TODO(), "test")
+		middleware.SetDefaultHandlerID(context.TODO(), "test")
+		middleware.SkipMeasurement(context.TODO())
+	})
+}
+
+func TestMiddlewareSetLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	mrep := &mockmiddleware.Reporter{}
+	mrep.On("StatusCode").Return(200)
+	mrep.On("Method").Return("GET")
+	mrep.On("BytesWritten").Return(int64(0))
+	mrep.On("URLPath").Return("/users/42")
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{
+		Recorder: mrec,
+		LabelExtractors: []middleware.LabelExtractor{
+			func(middleware.Reporter) map[string]string { return map[string]string{"version": "v1"} },
+		},
+	})
+
+	reporter := &contextReporter{Reporter: mrep, ctx: context.TODO()}
+	inflightLabels := metrics.NewLabels(map[string]string{"version": "v1"})
+	inflight := 0
+	mdlw.Measure("test", reporter, func() {
+		middleware.SetLabel(reporter.Context(), "route", "get-user")
+		inflight = mrec.InflightRequests(metrics.HTTPProperties{ID: "test", Labels: inflightLabels})
+	})
+
+	// The inflight requests should not have the labels set by the handler.
+	assert.Equal(1, inflight)
+	labels := metrics.NewLabels(map[string]string{"version": "v1", "route": "get-user"})
+	assert.Contains(mrec.Snapshot().RequestDurations, metrics.HTTPReqProperties{ID: "test", Method: "GET", Code: "200", Labels: labels})
+}
@@ -3,35 +3,167 @@ package echo
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/slok/go-http-metrics/middleware"
+	"github.com/slok/go-http-metrics/middleware/internal/bodycounter"
+	"github.com/slok/go-http-metrics/middleware/internal/protocol"
 )
 
+// Error outcome label values, see `ErrorLabel`.
+const (
+	// ErrorOutcomeHTTP is the outcome of the handlers that returned an `*echo.HTTPError`.
+	ErrorOutcomeHTTP = "http"
+	// ErrorOutcomeInternal is the outcome of the handlers that returned any other error.
+	ErrorOutcomeInternal = "internal"
+)
+
+// ErrorLabel is the extra label (`metrics.Labels`) of the error outcome of the handlers, the
+// requests whose handler returned an error will have it with the `ErrorOutcome...` values. The
+// recorders need it declared as an extra label to record it (e.g Prometheus `ExtraLabels` option).
+const ErrorLabel = "error"
+
 // Handler returns a Echo measuring middleware.
+//
+// Echo writes the response of the errors returned by the handlers after the middlewares, using
+// the `HTTPErrorHandler`. If the response has not been written, the status code is resolved from
+// the returned error like the Echo default error handler does: the code of the `*echo.HTTPError`
+// errors and 500 for the rest. The response size of these requests is unknown (0), use
+// `HandlerWithErrorHandler` to measure the real responses.
 func Handler(handlerID string, m middleware.Middleware) echo.MiddlewareFunc {
+	return handler(handlerID, m, false)
+}
+
+// HandlerWithErrorHandler returns a Echo measuring middleware that calls the Echo `HTTPErrorHandler`
+// with the errors returned by the handlers before measuring, so the responses written by the error
+// handler are measured. The handled errors are not returned to the previous middlewares.
+func HandlerWithErrorHandler(handlerID string, m middleware.Middleware) echo.MiddlewareFunc {
+	return handler(handlerID, m, true)
+}
+
+func handler(handlerID string, m middleware.Middleware, handleErrors bool) echo.MiddlewareFunc {
 	return func(h echo.HandlerFunc) echo.HandlerFunc {
 		return echo.HandlerFunc(func(c echo.Context) error {
 			r := &reporter{c: c}
+			c.Response().Before(func() {
+				if r.firstByteTime.IsZero() {
+					r.firstByteTime = time.Now()
+				}
+			})
 			var err error
 			m.Measure(handlerID, r, func() {
 				err = h(c)
+				if err == nil {
+					return
+				}
+
+				middleware.SetLabel(r.Context(), ErrorLabel, errorOutcome(err))
+				if handleErrors {
+					c.Echo().HTTPErrorHandler(err, c)
+					err = nil
+				}
+				r.err = err
 			})
 			return err
 		})
 	}
 }
 
+// errorOutcome returns the error outcome of a handler error.
+func errorOutcome(err error) string {
+	if _, ok := err.(*echo.HTTPError); ok {
+		return ErrorOutcomeHTTP
+	}
+	return ErrorOutcomeInternal
+}
+
+// errorStatusCode returns the status code of a handler error, in the same way the Echo
+// default HTTP error handler does.
+func errorStatusCode(err error) int {
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	if ihe, ok := he.Internal.(*echo.HTTPError); ok {
+		return ihe.Code
+	}
+	return he.Code
+}
+
 type reporter struct {
-	c echo.Context
+	c             echo.Context
+	body          *bodycounter.Body
+	firstByteTime time.Time
+	// err is the not handled error returned by the handler.
+	err error
 }
 
 func (r *reporter) Method() string { return r.c.Request().Method }
 
 func (r *reporter) Context() context.Context { return r.c.Request().Context() }
 
+func (r *reporter) SetContextValue(key, value any) {
+	req := r.c.Request()
+	r.c.SetRequest(req.WithContext(context.WithValue(req.Context(), key, value)))
+}
+
 func (r *reporter) URLPath() string { return r.c.Request().URL.Path }
 
-func (r *reporter) StatusCode() int { return r.c.Response().Status }
+func (r *reporter) StatusCode() int {
+	// The not handled errors will be written after measuring.
+	if r.err != nil && !r.c.Response().Committed {
+		return errorStatusCode(r.err)
+	}
+	return r.c.Response().Status
+}
 
 func (r *reporter) BytesWritten() int64 { return r.c.Response().Size }
+
+func (r *reporter) BytesRead() int64 { return r.body.BytesRead() }
+
+func (r *reporter) CountBytesRead() { r.body = bodycounter.Wrap(r.c.Request()) }
+
+func (r *reporter) RequestHeader(name string) string { return r.c.Request().Header.Get(name) }
+
+func (r *reporter) Proto() string { return protocol.Proto(r.c.Request()) }
+
+func (r *reporter) Scheme() string { return protocol.Scheme(r.c.Request()) }
+
+func (r *reporter) FirstByteTime() time.Time { return r.firstByteTime }
+
+func (r *reporter) OnFlush(f func()) {
+	resp := r.c.Response()
+	resp.Writer = &flushWriter{ResponseWriter: resp.Writer, onFlush: f}
+}
+
+func (r *reporter) RespondPanic(statusCode int, body []byte) {
+	if r.c.Response().Committed {
+		return
+	}
+	_ = r.c.Blob(statusCode, echo.MIMETextPlainCharsetUTF8, body)
+}
+
+// flushWriter calls the flush hook after the flushes of the streaming responses, Echo
+// flushes the responses using `http.ResponseController`.
+type flushWriter struct {
+	http.ResponseWriter
+	onFlush func()
+}
+
+func (w *flushWriter) FlushError() error {
+	err := http.NewResponseController(w.ResponseWriter).Flush()
+	if err != nil {
+		return err
+	}
+
+	w.onFlush()
+	return nil
+}
+
+func (w *flushWriter) Flush() { _ = w.FlushError() }
+
+// Unwrap returns the wrapped ResponseWriter, used by `http.ResponseController`.
+func (w *flushWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
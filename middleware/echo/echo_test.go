@@ -1,18 +1,23 @@
 package echo_test
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	echomw "github.com/labstack/echo/v4/middleware"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	mmetrics "github.com/slok/go-http-metrics/internal/mocks/metrics"
 	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
 	"github.com/slok/go-http-metrics/middleware"
 	echoMiddleware "github.com/slok/go-http-metrics/middleware/echo"
 )
@@ -89,3 +94,235 @@ func TestMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareRequestSize(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureRequestSize: true})
+	e := echo.New()
+	e.POST("/test", func(c echo.Context) error {
+		_, _ = io.ReadAll(c.Request().Body)
+		return c.NoContent(http.StatusAccepted)
+	}, echoMiddleware.Handler("", mdlw))
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("0123456789")))
+
+	assert.Equal(memory.Aggregate{Count: 1, Sum: 10, Min: 10, Max: 10}, mrec.RequestSize(memory.Query{ID: "/test"}))
+}
+
+func TestMiddlewareTimeToFirstByte(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureTimeToFirstByte: true})
+	e := echo.New()
+	e.GET("/test", func(c echo.Context) error {
+		err := c.String(http.StatusOK, "test")
+		time.Sleep(20 * time.Millisecond)
+		return err
+	}, echoMiddleware.Handler("", mdlw))
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	ttfb := mrec.TimeToFirstByte(memory.Query{ID: "/test"})
+	assert.Equal(1, ttfb.Count)
+	assert.Less(ttfb.Sum, 0.02)
+}
+
+func TestMiddlewarePanics(t *testing.T) {
+	tests := map[string]struct {
+		recoverPanics bool
+		expBody       string
+	}{
+		"A panicking handler should be measured as a 500 and recovered by the framework.": {
+			recoverPanics: false,
+			expBody:       "{\"message\":\"Internal Server Error\"}\n",
+		},
+
+		"A panicking handler with panic recovery should be measured as a 500 and respond with a 500.": {
+			recoverPanics: true,
+			expBody:       "Internal Server Error",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec, RecoverPanics: test.recoverPanics})
+			e := echo.New()
+			e.Use(echomw.RecoverWithConfig(echomw.RecoverConfig{DisablePrintStack: true}))
+			e.GET("/test", func(c echo.Context) error {
+				panic("boom")
+			}, echoMiddleware.Handler("", mdlw))
+
+			resp := httptest.NewRecorder()
+			e.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			assert.Equal(http.StatusInternalServerError, resp.Code)
+			assert.Equal(test.expBody, resp.Body.String())
+			assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Code: "500"}))
+			assert.Equal(1, mrec.Panics(metrics.HTTPProperties{ID: "/test"}))
+		})
+	}
+}
+
+func TestMiddlewareSetHandlerID(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec})
+	e := echo.New()
+	e.Use(echoMiddleware.Handler("", mdlw))
+	e.GET("/users/:id", func(c echo.Context) error {
+		middleware.SetHandlerID(c.Request().Context(), c.Path())
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/healthz", func(c echo.Context) error {
+		middleware.SkipMeasurement(c.Request().Context())
+		return c.NoContent(http.StatusOK)
+	})
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/users/:id"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/users/42"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/healthz"}))
+}
+
+func TestMiddlewareProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureProtocol: true})
+	e := echo.New()
+	e.GET("/test", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, echoMiddleware.Handler("", mdlw))
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/test", nil))
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Proto: "HTTP/1.1", Scheme: "https"}))
+}
+
+func TestMiddlewareHandlerErrors(t *testing.T) {
+	tests := map[string]struct {
+		handleErrors bool
+		handler      echo.HandlerFunc
+		expCode      string
+		expLabels    map[string]string
+		expRespCode  int
+		expRespSize  float64
+	}{
+		"A handler without errors should be measured without the error label.": {
+			handler:     func(c echo.Context) error { return c.String(http.StatusOK, "ok") },
+			expCode:     "200",
+			expLabels:   map[string]string{},
+			expRespCode: http.StatusOK,
+			expRespSize: 2,
+		},
+
+		"A handler returning an HTTP error should be measured with the error status code.": {
+			handler:     func(c echo.Context) error { return echo.NewHTTPError(http.StatusNotFound) },
+			expCode:     "404",
+			expLabels:   map[string]string{echoMiddleware.ErrorLabel: echoMiddleware.ErrorOutcomeHTTP},
+			expRespCode: http.StatusNotFound,
+		},
+
+		"A handler returning an HTTP error with an internal HTTP error should be measured with the internal status code.": {
+			handler: func(c echo.Context) error {
+				return echo.NewHTTPError(http.StatusBadRequest).SetInternal(echo.NewHTTPError(http.StatusConflict))
+			},
+			expCode:     "409",
+			expLabels:   map[string]string{echoMiddleware.ErrorLabel: echoMiddleware.ErrorOutcomeHTTP},
+			expRespCode: http.StatusConflict,
+		},
+
+		"A handler returning a generic error should be measured as an internal server error.": {
+			handler:     func(c echo.Context) error { return errors.New("wanted") },
+			expCode:     "500",
+			expLabels:   map[string]string{echoMiddleware.ErrorLabel: echoMiddleware.ErrorOutcomeInternal},
+			expRespCode: http.StatusInternalServerError,
+		},
+
+		"A handler returning an error after writing the response should be measured with the written status code.": {
+			handler: func(c echo.Context) error {
+				_ = c.String(http.StatusAccepted, "ok")
+				return errors.New("wanted")
+			},
+			expCode:     "202",
+			expLabels:   map[string]string{echoMiddleware.ErrorLabel: echoMiddleware.ErrorOutcomeInternal},
+			expRespCode: http.StatusAccepted,
+			expRespSize: 2,
+		},
+
+		"Handling the errors, a handler returning an HTTP error should be measured with the error handler response.": {
+			handleErrors: true,
+			handler:      func(c echo.Context) error { return echo.NewHTTPError(http.StatusNotFound) },
+			expCode:      "404",
+			expLabels:    map[string]string{echoMiddleware.ErrorLabel: echoMiddleware.ErrorOutcomeHTTP},
+			expRespCode:  http.StatusNotFound,
+			expRespSize:  float64(len(`{"message":"Not Found"}` + "\n")),
+		},
+
+		"Handling the errors, a handler returning a generic error should be measured with the error handler response.": {
+			handleErrors: true,
+			handler:      func(c echo.Context) error { return errors.New("wanted") },
+			expCode:      "500",
+			expLabels:    map[string]string{echoMiddleware.ErrorLabel: echoMiddleware.ErrorOutcomeInternal},
+			expRespCode:  http.StatusInternalServerError,
+			expRespSize:  float64(len(`{"message":"Internal Server Error"}` + "\n")),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec})
+			mdlwFunc := echoMiddleware.Handler("", mdlw)
+			if test.handleErrors {
+				mdlwFunc = echoMiddleware.HandlerWithErrorHandler("", mdlw)
+			}
+
+			e := echo.New()
+			e.GET("/test", test.handler, mdlwFunc)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			assert.Equal(test.expRespCode, rec.Code)
+			props := metrics.HTTPReqProperties{ID: "/test", Method: http.MethodGet, Code: test.expCode, Labels: metrics.NewLabels(test.expLabels)}
+			assert.Contains(mrec.Snapshot().RequestDurations, props)
+			assert.Equal(test.expRespSize, mrec.ResponseSize(memory.Query{ID: "/test"}).Sum)
+		})
+	}
+}
+
+func TestMiddlewareStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureStreams: true})
+	e := echo.New()
+	e.GET("/events", func(c echo.Context) error {
+		for _, event := range []string{"data: 1\n\n", "data: 22\n\n"} {
+			if _, err := c.Response().Write([]byte(event)); err != nil {
+				return err
+			}
+			c.Response().Flush()
+		}
+		return nil
+	}, echoMiddleware.Handler("events", mdlw))
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	q := memory.Query{ID: "events", Code: "200"}
+	assert.Equal(memory.Aggregate{Count: 2, Sum: 19, Min: 9, Max: 10}, mrec.StreamFlushSize(q))
+	assert.Equal(1, mrec.StreamDuration(q).Count)
+	assert.Equal(1, mrec.RequestCount(q))
+}
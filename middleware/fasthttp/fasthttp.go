@@ -1,8 +1,13 @@
 // Package fasthttp is a helper package to get a fasthttp compatible middleware.
+//
+// fasthttp writes the response (including the streamed bodies) once the handler returns,
+// so the time to first byte is measured as the time the handler returns. fasthttp doesn't
+// notify the client disconnects, so the requests canceled by the clients are not detected.
 package fasthttp
 
 import (
 	"context"
+	"time"
 
 	"github.com/slok/go-http-metrics/middleware"
 	"github.com/valyala/fasthttp"
@@ -11,32 +16,92 @@ import (
 // Handler returns a fasthttp measuring middleware.
 func Handler(handlerID string, m middleware.Middleware, next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(c *fasthttp.RequestCtx) {
-		m.Measure(handlerID, reporter{c}, func() {
+		r := &reporter{c: c}
+		m.Measure(handlerID, r, func() {
 			next(c)
+			// fasthttp writes the response once the handler returns.
+			r.firstByteTime = time.Now()
 		})
 	}
 }
 
 type reporter struct {
-	c *fasthttp.RequestCtx
+	c             *fasthttp.RequestCtx
+	firstByteTime time.Time
 }
 
-func (r reporter) Method() string {
+func (r *reporter) Method() string {
 	return string(r.c.Method())
 }
 
-func (r reporter) Context() context.Context {
+func (r *reporter) Context() context.Context {
 	return r.c
 }
 
-func (r reporter) URLPath() string {
+// SetContextValue sets the value as a user value, fasthttp request context
+// returns the user values as the context values.
+func (r *reporter) SetContextValue(key, value any) {
+	r.c.SetUserValue(key, value)
+}
+
+func (r *reporter) URLPath() string {
 	return string(r.c.Path())
 }
 
-func (r reporter) StatusCode() int {
+func (r *reporter) StatusCode() int {
 	return r.c.Response.StatusCode()
 }
 
-func (r reporter) BytesWritten() int64 {
+func (r *reporter) BytesWritten() int64 {
 	return int64(len(r.c.Response.Body()))
 }
+
+func (r *reporter) BytesRead() int64 {
+	// Streamed bodies are consumed by the handler, we can't count them without
+	// reading them, so we rely on the content length.
+	if r.c.Request.IsBodyStream() {
+		if cl := r.c.Request.Header.ContentLength(); cl > 0 {
+			return int64(cl)
+		}
+		return 0
+	}
+
+	return int64(len(r.c.Request.Body()))
+}
+
+func (r *reporter) RespondPanic(statusCode int, body []byte) {
+	// The response is not sent until the handler returns, so we can replace it.
+	r.c.Response.Reset()
+	r.c.SetContentType("text/plain; charset=utf-8")
+	r.c.SetStatusCode(statusCode)
+	r.c.SetBody(body)
+}
+
+func (r *reporter) FirstByteTime() time.Time {
+	return r.firstByteTime
+}
+
+// Canceled returns always false, fasthttp doesn't notify the client disconnects, the
+// request context is only canceled when the server is shutting down.
+func (r *reporter) Canceled() bool {
+	return false
+}
+
+func (r *reporter) RequestHeader(name string) string {
+	return string(r.c.Request.Header.Peek(name))
+}
+
+// Proto returns the protocol version of the request, fasthttp only supports HTTP/1.x.
+func (r *reporter) Proto() string {
+	if r.c.Request.Header.IsHTTP11() {
+		return "HTTP/1.1"
+	}
+	return "HTTP/1.0"
+}
+
+func (r *reporter) Scheme() string {
+	if r.c.IsTLS() {
+		return "https"
+	}
+	return "http"
+}
@@ -1,15 +1,20 @@
 package fasthttp_test
 
 import (
+	"net"
 	"testing"
+	"time"
 
 	mmetrics "github.com/slok/go-http-metrics/internal/mocks/metrics"
 	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
 	"github.com/slok/go-http-metrics/middleware"
 	fasthttpMiddleware "github.com/slok/go-http-metrics/middleware/fasthttp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 func TestMiddleware(t *testing.T) {
@@ -83,3 +88,107 @@ func TestMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareRequestSize(t *testing.T) {
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureRequestSize: true})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.SetRequestURI("/test")
+	ctx.Request.SetBodyString("0123456789")
+
+	handler := fasthttpMiddleware.Handler("", mdlw, func(c *fasthttp.RequestCtx) {
+		c.SetStatusCode(fasthttp.StatusAccepted)
+	})
+	handler(ctx)
+
+	assert.Equal(t, memory.Aggregate{Count: 1, Sum: 10, Min: 10, Max: 10}, mrec.RequestSize(memory.Query{ID: "/test"}))
+}
+
+func TestMiddlewareTimeToFirstByte(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureTimeToFirstByte: true})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetRequestURI("/test")
+	handler := fasthttpMiddleware.Handler("", mdlw, func(c *fasthttp.RequestCtx) {
+		_, _ = c.WriteString("test")
+		time.Sleep(20 * time.Millisecond)
+	})
+	handler(ctx)
+
+	// fasthttp writes the response once the handler returns.
+	ttfb := mrec.TimeToFirstByte(memory.Query{ID: "/test"})
+	assert.Equal(1, ttfb.Count)
+	assert.GreaterOrEqual(ttfb.Sum, 0.02)
+}
+
+func TestMiddlewareShutdownIsNotCanceled(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, CanceledStatusCode: 499})
+
+	// fasthttp cancels the requests when the server is shutting down, they are not client aborts.
+	started := make(chan struct{})
+	srv := &fasthttp.Server{
+		Handler: fasthttpMiddleware.Handler("", mdlw, func(c *fasthttp.RequestCtx) {
+			close(started)
+			<-c.Done()
+		}),
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	go func() { _ = srv.Serve(ln) }()
+
+	client := &fasthttp.Client{Dial: func(string) (net.Conn, error) { return ln.Dial() }}
+	go func() { _, _, _ = client.Get(nil, "http://test/test") }()
+	<-started
+	require.NoError(srv.Shutdown())
+
+	assert.Equal(0, mrec.ClientAborts(metrics.HTTPProperties{ID: "/test"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/test", Code: "499"}))
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Code: "200"}))
+}
+
+func TestMiddlewareSetHandlerID(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec})
+
+	handler := fasthttpMiddleware.Handler("", mdlw, func(c *fasthttp.RequestCtx) {
+		middleware.SetHandlerID(c, "/users/{id}")
+	})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetRequestURI("/users/42")
+	handler(ctx)
+
+	skipHandler := fasthttpMiddleware.Handler("", mdlw, func(c *fasthttp.RequestCtx) {
+		middleware.SkipMeasurement(c)
+	})
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetRequestURI("/healthz")
+	skipHandler(ctx)
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/users/{id}"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/users/42"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/healthz"}))
+}
+
+func TestMiddlewareProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureProtocol: true})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetRequestURI("/test")
+	handler := fasthttpMiddleware.Handler("", mdlw, func(c *fasthttp.RequestCtx) {})
+	handler(ctx)
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Proto: "HTTP/1.1", Scheme: "http"}))
+}
@@ -3,16 +3,21 @@ package gin
 
 import (
 	"context"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/slok/go-http-metrics/middleware"
+	"github.com/slok/go-http-metrics/middleware/internal/bodycounter"
+	"github.com/slok/go-http-metrics/middleware/internal/protocol"
 )
 
 // Handler returns a Gin measuring middleware.
 func Handler(handlerID string, m middleware.Middleware) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		r := &reporter{c: c}
+		w := &firstByteWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		r := &reporter{c: c, w: w}
 		m.Measure(handlerID, r, func() {
 			c.Next()
 		})
@@ -20,15 +25,82 @@ func Handler(handlerID string, m middleware.Middleware) gin.HandlerFunc {
 }
 
 type reporter struct {
-	c *gin.Context
+	c    *gin.Context
+	w    *firstByteWriter
+	body *bodycounter.Body
 }
 
 func (r *reporter) Method() string { return r.c.Request.Method }
 
 func (r *reporter) Context() context.Context { return r.c.Request.Context() }
 
+func (r *reporter) SetContextValue(key, value any) {
+	r.c.Request = r.c.Request.WithContext(context.WithValue(r.c.Request.Context(), key, value))
+}
+
 func (r *reporter) URLPath() string { return r.c.FullPath() }
 
 func (r *reporter) StatusCode() int { return r.c.Writer.Status() }
 
 func (r *reporter) BytesWritten() int64 { return int64(r.c.Writer.Size()) }
+
+func (r *reporter) BytesRead() int64 { return r.body.BytesRead() }
+
+func (r *reporter) CountBytesRead() { r.body = bodycounter.Wrap(r.c.Request) }
+
+func (r *reporter) RequestHeader(name string) string { return r.c.GetHeader(name) }
+
+func (r *reporter) Proto() string { return protocol.Proto(r.c.Request) }
+
+func (r *reporter) Scheme() string { return protocol.Scheme(r.c.Request) }
+
+func (r *reporter) FirstByteTime() time.Time { return r.w.firstByteTime }
+
+func (r *reporter) OnFlush(f func()) { r.w.onFlush = f }
+
+func (r *reporter) RespondPanic(statusCode int, body []byte) {
+	// The rest of the handlers chain must not be executed.
+	r.c.Abort()
+	if r.c.Writer.Written() {
+		return
+	}
+	r.c.Data(statusCode, "text/plain; charset=utf-8", body)
+}
+
+// firstByteWriter tracks the time when the first byte is written on the response. Gin
+// delays writing the headers until `WriteHeaderNow` or the first write. It also calls the
+// flush hook after the flushes of the streaming responses (e.g `gin.Context.Stream`).
+type firstByteWriter struct {
+	gin.ResponseWriter
+	firstByteTime time.Time
+	onFlush       func()
+}
+
+func (w *firstByteWriter) WriteHeaderNow() {
+	w.markFirstByte()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *firstByteWriter) Write(p []byte) (int, error) {
+	w.markFirstByte()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *firstByteWriter) WriteString(s string) (int, error) {
+	w.markFirstByte()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *firstByteWriter) Flush() {
+	w.markFirstByte()
+	w.ResponseWriter.Flush()
+	if w.onFlush != nil {
+		w.onFlush()
+	}
+}
+
+func (w *firstByteWriter) markFirstByte() {
+	if w.firstByteTime.IsZero() {
+		w.firstByteTime = time.Now()
+	}
+}
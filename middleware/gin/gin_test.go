@@ -4,7 +4,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +15,7 @@ import (
 
 	mmetrics "github.com/slok/go-http-metrics/internal/mocks/metrics"
 	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
 	"github.com/slok/go-http-metrics/middleware"
 	ginmiddleware "github.com/slok/go-http-metrics/middleware/gin"
 )
@@ -155,3 +158,139 @@ func TestMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareRequestSize(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureRequestSize: true})
+	engine := gin.New()
+	engine.POST("/test", ginmiddleware.Handler("", mdlw), func(c *gin.Context) {
+		_, _ = io.ReadAll(c.Request.Body)
+		c.Status(http.StatusAccepted)
+	})
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("0123456789")))
+
+	assert.Equal(memory.Aggregate{Count: 1, Sum: 10, Min: 10, Max: 10}, mrec.RequestSize(memory.Query{ID: "/test"}))
+}
+
+func TestMiddlewareTimeToFirstByte(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureTimeToFirstByte: true})
+	engine := gin.New()
+	engine.GET("/test", ginmiddleware.Handler("", mdlw), func(c *gin.Context) {
+		c.String(http.StatusOK, "test")
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	ttfb := mrec.TimeToFirstByte(memory.Query{ID: "/test"})
+	assert.Equal(1, ttfb.Count)
+	assert.Less(ttfb.Sum, 0.02)
+}
+
+func TestMiddlewarePanics(t *testing.T) {
+	tests := map[string]struct {
+		recoverPanics bool
+		expBody       string
+	}{
+		"A panicking handler should be measured as a 500 and recovered by the framework.": {
+			recoverPanics: false,
+			expBody:       "",
+		},
+
+		"A panicking handler with panic recovery should be measured as a 500 and respond with a 500.": {
+			recoverPanics: true,
+			expBody:       "Internal Server Error",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec, RecoverPanics: test.recoverPanics})
+			engine := gin.New()
+			engine.Use(gin.CustomRecoveryWithWriter(io.Discard, gin.RecoveryFunc(func(c *gin.Context, _ any) {
+				c.AbortWithStatus(http.StatusInternalServerError)
+			})))
+			calledAfterPanic := false
+			engine.GET("/test", ginmiddleware.Handler("", mdlw), func(c *gin.Context) {
+				panic("boom")
+			}, func(c *gin.Context) {
+				calledAfterPanic = true
+			})
+
+			resp := httptest.NewRecorder()
+			engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			assert.Equal(http.StatusInternalServerError, resp.Code)
+			assert.Equal(test.expBody, resp.Body.String())
+			assert.False(calledAfterPanic)
+			assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Code: "500"}))
+			assert.Equal(1, mrec.Panics(metrics.HTTPProperties{ID: "/test"}))
+		})
+	}
+}
+
+func TestMiddlewareSetHandlerID(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec})
+	engine := gin.New()
+	engine.Use(ginmiddleware.Handler("", mdlw))
+	engine.GET("/users/:id", func(c *gin.Context) {
+		middleware.SetHandlerID(c.Request.Context(), c.FullPath())
+	})
+	engine.GET("/healthz", func(c *gin.Context) {
+		middleware.SkipMeasurement(c.Request.Context())
+	})
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/users/:id"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/users/42"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/healthz"}))
+}
+
+func TestMiddlewareProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureProtocol: true})
+	engine := gin.New()
+	engine.GET("/test", ginmiddleware.Handler("", mdlw), func(c *gin.Context) {})
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/test", nil))
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Proto: "HTTP/1.1", Scheme: "https"}))
+}
+
+func TestMiddlewareStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureStreams: true})
+	engine := gin.New()
+	engine.GET("/events", ginmiddleware.Handler("events", mdlw), func(c *gin.Context) {
+		for _, event := range []string{"1", "22"} {
+			c.SSEvent("message", event)
+			c.Writer.Flush()
+		}
+	})
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	q := memory.Query{ID: "events", Code: "200"}
+	assert.Equal(2, mrec.StreamFlushSize(q).Count)
+	assert.Equal(float64(len("event:message\ndata:1\n\nevent:message\ndata:22\n\n")), mrec.StreamFlushSize(q).Sum)
+	assert.Equal(1, mrec.StreamDuration(q).Count)
+	assert.Equal(1, mrec.RequestCount(q))
+}
@@ -3,16 +3,22 @@ package gorestful
 
 import (
 	"context"
+	"time"
 
 	gorestful "github.com/emicklei/go-restful/v3"
 
 	"github.com/slok/go-http-metrics/middleware"
+	"github.com/slok/go-http-metrics/middleware/internal/bodycounter"
+	"github.com/slok/go-http-metrics/middleware/internal/firstbyte"
+	"github.com/slok/go-http-metrics/middleware/internal/protocol"
 )
 
 // Handler returns a gorestful measuring middleware.
 func Handler(handlerID string, m middleware.Middleware) gorestful.FilterFunction {
 	return func(req *gorestful.Request, resp *gorestful.Response, chain *gorestful.FilterChain) {
-		r := &reporter{req: req, resp: resp}
+		w := firstbyte.Wrap(resp.ResponseWriter)
+		resp.ResponseWriter = w
+		r := &reporter{req: req, resp: resp, w: w}
 		m.Measure(handlerID, r, func() {
 			chain.ProcessFilter(req, resp)
 		})
@@ -22,14 +28,41 @@ func Handler(handlerID string, m middleware.Middleware) gorestful.FilterFunction
 type reporter struct {
 	req  *gorestful.Request
 	resp *gorestful.Response
+	body *bodycounter.Body
+	w    *firstbyte.Writer
 }
 
 func (r *reporter) Method() string { return r.req.Request.Method }
 
 func (r *reporter) Context() context.Context { return r.req.Request.Context() }
 
+func (r *reporter) SetContextValue(key, value any) {
+	r.req.Request = r.req.Request.WithContext(context.WithValue(r.req.Request.Context(), key, value))
+}
+
 func (r *reporter) URLPath() string { return r.req.Request.URL.Path }
 
 func (r *reporter) StatusCode() int { return r.resp.StatusCode() }
 
 func (r *reporter) BytesWritten() int64 { return int64(r.resp.ContentLength()) }
+
+func (r *reporter) BytesRead() int64 { return r.body.BytesRead() }
+
+func (r *reporter) CountBytesRead() { r.body = bodycounter.Wrap(r.req.Request) }
+
+func (r *reporter) RequestHeader(name string) string { return r.req.Request.Header.Get(name) }
+
+func (r *reporter) Proto() string { return protocol.Proto(r.req.Request) }
+
+func (r *reporter) Scheme() string { return protocol.Scheme(r.req.Request) }
+
+func (r *reporter) FirstByteTime() time.Time { return r.w.FirstByteTime() }
+
+func (r *reporter) RespondPanic(statusCode int, body []byte) {
+	if !r.w.FirstByteTime().IsZero() {
+		return
+	}
+	r.resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	r.resp.WriteHeader(statusCode)
+	_, _ = r.resp.Write(body)
+}
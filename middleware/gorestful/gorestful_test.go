@@ -4,7 +4,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	gorestful "github.com/emicklei/go-restful/v3"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +15,7 @@ import (
 
 	mmetrics "github.com/slok/go-http-metrics/internal/mocks/metrics"
 	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
 	"github.com/slok/go-http-metrics/middleware"
 	gorestfulmiddleware "github.com/slok/go-http-metrics/middleware/gorestful"
 )
@@ -57,6 +60,39 @@ func TestMiddleware(t *testing.T) {
 			expRespCode: 202,
 			expRespBody: "test1",
 		},
+
+		"Setting the handler ID from the handler should measure the request with it.": {
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/test", nil)
+			},
+			mock: func(m *mmetrics.Recorder) {
+				expHTTPReqProps := metrics.HTTPReqProperties{
+					ID:      "/users/{id}",
+					Service: "",
+					Method:  "POST",
+					Code:    "202",
+				}
+				m.On("ObserveHTTPRequestDuration", mock.Anything, expHTTPReqProps, mock.Anything).Once()
+				m.On("ObserveHTTPResponseSize", mock.Anything, expHTTPReqProps, int64(5)).Once()
+
+				// Inflight requests use the initial handler ID.
+				expHTTPProps := metrics.HTTPProperties{
+					ID:      "/test",
+					Service: "",
+				}
+				m.On("AddInflightRequests", mock.Anything, expHTTPProps, 1).Once()
+				m.On("AddInflightRequests", mock.Anything, expHTTPProps, -1).Once()
+			},
+			handler: func() gorestful.RouteFunction {
+				return gorestful.RouteFunction(func(req *gorestful.Request, resp *gorestful.Response) {
+					middleware.SetHandlerID(req.Request.Context(), "/users/{id}")
+					resp.WriteHeader(202)
+					resp.Write([]byte("test1")) // nolint: errcheck
+				})
+			},
+			expRespCode: 202,
+			expRespBody: "test1",
+		},
 	}
 
 	for name, test := range tests {
@@ -91,3 +127,60 @@ func TestMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareRequestSize(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureRequestSize: true})
+	c := gorestful.NewContainer()
+	c.Filter(gorestfulmiddleware.Handler("", mdlw))
+	ws := &gorestful.WebService{}
+	ws.Route(ws.POST("/test").To(func(req *gorestful.Request, resp *gorestful.Response) {
+		// Read only part of the body.
+		_, _ = io.ReadFull(req.Request.Body, make([]byte, 4))
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	c.Add(ws)
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("0123456789")))
+
+	assert.Equal(memory.Aggregate{Count: 1, Sum: 4, Min: 4, Max: 4}, mrec.RequestSize(memory.Query{ID: "/test"}))
+}
+
+func TestMiddlewareTimeToFirstByte(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureTimeToFirstByte: true})
+	c := gorestful.NewContainer()
+	c.Filter(gorestfulmiddleware.Handler("", mdlw))
+	ws := &gorestful.WebService{}
+	ws.Route(ws.GET("/test").To(func(_ *gorestful.Request, resp *gorestful.Response) {
+		_, _ = resp.Write([]byte("test"))
+		time.Sleep(20 * time.Millisecond)
+	}))
+	c.Add(ws)
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	ttfb := mrec.TimeToFirstByte(memory.Query{ID: "/test"})
+	assert.Equal(1, ttfb.Count)
+	assert.Less(ttfb.Sum, 0.02)
+}
+
+func TestMiddlewareProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureProtocol: true})
+	c := gorestful.NewContainer()
+	c.Filter(gorestfulmiddleware.Handler("", mdlw))
+	ws := &gorestful.WebService{}
+	ws.Route(ws.GET("/test").To(func(_ *gorestful.Request, _ *gorestful.Response) {}))
+	c.Add(ws)
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/test", nil))
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Proto: "HTTP/1.1", Scheme: "https"}))
+}
@@ -0,0 +1,48 @@
+package gorilla_test
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
+	"github.com/slok/go-http-metrics/middleware"
+	gorillamiddleware "github.com/slok/go-http-metrics/middleware/gorilla"
+)
+
+// GorillaMiddleware shows how you would create a default middleware factory and use it
+// to create a gorilla mux compatible middleware that measures the route templates and names.
+func Example_gorillaMiddleware() {
+	// Create our middleware factory with the route name extra label.
+	mdlw := middleware.New(middleware.Config{
+		Recorder: metrics.NewRecorder(metrics.Config{
+			ExtraLabels: []string{gorillamiddleware.RouteNameLabel},
+		}),
+	})
+
+	// Create our gorilla router and add our middleware, also to the not found
+	// requests.
+	r := mux.NewRouter()
+	r.Use(gorillamiddleware.Handler("", mdlw))
+	r.NotFoundHandler = gorillamiddleware.Handler("not-found", mdlw)(http.NotFoundHandler())
+
+	// Add our handler, it will be measured as `/users/{id}` with the `get-user` route label.
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("Hello " + mux.Vars(r)["id"]))
+	}).Methods(http.MethodGet).Name("get-user")
+
+	// Serve metrics from the default prometheus registry.
+	log.Printf("serving metrics at: %s", ":8081")
+	go func() {
+		_ = http.ListenAndServe(":8081", promhttp.Handler())
+	}()
+
+	// Serve our handler.
+	log.Printf("listening at: %s", ":8080")
+	if err := http.ListenAndServe(":8080", r); err != nil {
+		log.Panicf("error while serving: %s", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Package gorilla is a helper package to get a gorilla mux compatible middleware that
+// measures the requests using the gorilla route path templates as the handler IDs.
+package gorilla
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/slok/go-http-metrics/middleware"
+	"github.com/slok/go-http-metrics/middleware/std"
+)
+
+// RouteNameLabel is the extra label (`metrics.Labels`) of the route names. The recorders
+// need it declared as an extra label to record it (e.g Prometheus `ExtraLabels` option).
+const RouteNameLabel = "route"
+
+// unmatchedHandlerID is the default handler ID of the requests not matched by a route.
+const unmatchedHandlerID = "unmatched"
+
+// Handler returns a gorilla mux measuring middleware that uses the path template of the
+// matched route (e.g `/users/{id}`) as the handler ID, including the path prefixes of the
+// subrouters (e.g `/api/v1/users/{id}`). The routes names, when set, are recorded with
+// the `RouteNameLabel` extra label.
+//
+// The middleware needs to be used on the gorilla router (e.g `r.Use(...)`), gorilla matches
+// the route before calling its middlewares, so the handler ID is known before measuring and
+// the inflight requests metric uses it too. To measure the requests not matched by a route,
+// wrap the `NotFoundHandler` of the router with the middleware, the requests without a route
+// are measured with the fallbackID, by default `unmatched`. The handlers can still set their
+// own handler ID with `middleware.SetHandlerID`.
+func Handler(fallbackID string, m middleware.Middleware) mux.MiddlewareFunc {
+	if fallbackID == "" {
+		fallbackID = unmatchedHandlerID
+	}
+
+	return func(next http.Handler) http.Handler {
+		// Gorilla builds the middlewares chain on every request, the measuring
+		// handler is built with the handler ID of the matched route.
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerID, name := fallbackID, ""
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					handlerID = template
+				}
+				name = route.GetName()
+			}
+
+			h := next
+			if name != "" {
+				h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					middleware.SetLabel(r.Context(), RouteNameLabel, name)
+					next.ServeHTTP(w, r)
+				})
+			}
+			std.Handler(handlerID, m, h).ServeHTTP(w, r)
+		})
+	}
+}
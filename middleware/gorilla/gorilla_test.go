@@ -0,0 +1,148 @@
+package gorilla_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+	gorillamiddleware "github.com/slok/go-http-metrics/middleware/gorilla"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		fallbackID string
+		router     func(mdlw mux.MiddlewareFunc) http.Handler
+		req        *http.Request
+		expProps   metrics.HTTPReqProperties
+	}{
+		"A routed request should be measured with the route path template.": {
+			router: func(mdlw mux.MiddlewareFunc) http.Handler {
+				r := mux.NewRouter()
+				r.Use(mdlw)
+				r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+				return r
+			},
+			req:      httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expProps: metrics.HTTPReqProperties{ID: "/users/{id}", Method: http.MethodGet, Code: "200"},
+		},
+
+		"A routed request whose handler sets the handler ID should be measured with the handler ID.": {
+			router: func(mdlw mux.MiddlewareFunc) http.Handler {
+				r := mux.NewRouter()
+				r.Use(mdlw)
+				r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+					middleware.SetHandlerID(r.Context(), "get-user")
+				})
+				return r
+			},
+			req:      httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expProps: metrics.HTTPReqProperties{ID: "get-user", Method: http.MethodGet, Code: "200"},
+		},
+
+		"A request routed by a named route should be measured with the route name label.": {
+			router: func(mdlw mux.MiddlewareFunc) http.Handler {
+				r := mux.NewRouter()
+				r.Use(mdlw)
+				r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {}).Name("get-user")
+				return r
+			},
+			req: httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expProps: metrics.HTTPReqProperties{
+				ID:     "/users/{id}",
+				Method: http.MethodGet,
+				Code:   "200",
+				Labels: metrics.NewLabels(map[string]string{gorillamiddleware.RouteNameLabel: "get-user"}),
+			},
+		},
+
+		"A request routed by a subrouter should be measured with the full route path template.": {
+			router: func(mdlw mux.MiddlewareFunc) http.Handler {
+				r := mux.NewRouter()
+				r.Use(mdlw)
+				api := r.PathPrefix("/api/{version}").Subrouter()
+				api.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				return r
+			},
+			req:      httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil),
+			expProps: metrics.HTTPReqProperties{ID: "/api/{version}/users/{id}", Method: http.MethodGet, Code: "200"},
+		},
+
+		"A middleware on a subrouter should be measured with the full route path template.": {
+			router: func(mdlw mux.MiddlewareFunc) http.Handler {
+				r := mux.NewRouter()
+				api := r.PathPrefix("/api/{version}").Subrouter()
+				api.Use(mdlw)
+				api.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				return r
+			},
+			req:      httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil),
+			expProps: metrics.HTTPReqProperties{ID: "/api/{version}/users/{id}", Method: http.MethodGet, Code: "200"},
+		},
+
+		"A request not routed with the middleware on the not found handler should be measured with the default fallback ID.": {
+			router: func(mdlw mux.MiddlewareFunc) http.Handler {
+				r := mux.NewRouter()
+				r.Use(mdlw)
+				r.NotFoundHandler = mdlw(http.NotFoundHandler())
+				r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				return r
+			},
+			req:      httptest.NewRequest(http.MethodGet, "/posts/42", nil),
+			expProps: metrics.HTTPReqProperties{ID: "unmatched", Method: http.MethodGet, Code: "404"},
+		},
+
+		"A request not routed with the middleware on the not found handler should be measured with the fallback ID.": {
+			fallbackID: "not-found",
+			router: func(mdlw mux.MiddlewareFunc) http.Handler {
+				r := mux.NewRouter()
+				r.Use(mdlw)
+				r.NotFoundHandler = mdlw(http.NotFoundHandler())
+				r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+				return r
+			},
+			req:      httptest.NewRequest(http.MethodGet, "/posts/42", nil),
+			expProps: metrics.HTTPReqProperties{ID: "not-found", Method: http.MethodGet, Code: "404"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec})
+			h := test.router(gorillamiddleware.Handler(test.fallbackID, mdlw))
+			h.ServeHTTP(httptest.NewRecorder(), test.req)
+
+			snapshot := mrec.Snapshot()
+			assert.Len(snapshot.RequestDurations, 1)
+			assert.Contains(snapshot.RequestDurations, test.expProps)
+		})
+	}
+}
+
+func TestMiddlewareInflight(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec})
+
+	inflight := map[string]int{}
+	r := mux.NewRouter()
+	r.Use(gorillamiddleware.Handler("", mdlw))
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		inflight["unmatched"] = mrec.InflightRequests(metrics.HTTPProperties{ID: "unmatched"})
+		inflight["/users/{id}"] = mrec.InflightRequests(metrics.HTTPProperties{ID: "/users/{id}"})
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	// The route is known before measuring.
+	assert.Equal(map[string]int{"unmatched": 0, "/users/{id}": 1}, inflight)
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/users/{id}"}))
+}
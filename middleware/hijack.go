@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/slok/go-http-metrics/metrics"
+)
+
+// hijackedConn is a hijacked connection being measured, it counts the bytes read and
+// written, and measures the connection when it's closed.
+type hijackedConn struct {
+	net.Conn
+	start        time.Time
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	closeOnce    sync.Once
+	onClose      func(metrics.HijackedConnection)
+}
+
+func newHijackedConn(conn net.Conn, onClose func(metrics.HijackedConnection)) *hijackedConn {
+	return &hijackedConn{
+		Conn:    conn,
+		start:   time.Now(),
+		onClose: onClose,
+	}
+}
+
+func (c *hijackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesRead.Add(int64(n))
+	return n, err
+}
+
+func (c *hijackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+// Close closes the connection, the connection is measured only once, on the first close.
+func (c *hijackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.onClose(metrics.HijackedConnection{
+			Duration:     time.Since(c.start),
+			BytesRead:    c.bytesRead.Load(),
+			BytesWritten: c.bytesWritten.Load(),
+		})
+	})
+	return err
+}
@@ -0,0 +1,113 @@
+package middleware_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mockmiddleware "github.com/slok/go-http-metrics/internal/mocks/middleware"
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+)
+
+type hijackReporter struct {
+	*mockmiddleware.Reporter
+	onHijack func(conn net.Conn) net.Conn
+}
+
+func (r *hijackReporter) OnHijack(f func(conn net.Conn) net.Conn) { r.onHijack = f }
+
+// hijack hijacks the connection.
+func (r *hijackReporter) hijack(conn net.Conn) net.Conn {
+	if r.onHijack == nil {
+		return conn
+	}
+	return r.onHijack(conn)
+}
+
+func TestMiddlewareMeasureHijackedConnections(t *testing.T) {
+	tests := map[string]struct {
+		config        middleware.Config
+		expConns      int
+		expBytesRead  float64
+		expBytesWrite float64
+	}{
+		"Not enabling the hijacked connections measuring, it shouldn't measure the connections.": {
+			config: middleware.Config{},
+		},
+
+		"A hijacked connection should be measured when closed.": {
+			config:        middleware.Config{MeasureHijackedConnections: true},
+			expConns:      1,
+			expBytesRead:  4,
+			expBytesWrite: 5,
+		},
+
+		"A skipped hijacked connection shouldn't be measured.": {
+			config: middleware.Config{
+				MeasureHijackedConnections: true,
+				Skipper:                    func(middleware.SkipRequest) bool { return true },
+			},
+		},
+
+		"A hijacked connection of an ignored path shouldn't be measured.": {
+			config: middleware.Config{
+				MeasureHijackedConnections: true,
+				IgnoredPaths:               []string{"/ws"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(101)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(0))
+			mrep.On("URLPath").Return("/ws")
+			rep := &hijackReporter{Reporter: mrep}
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+
+			server, client := net.Pipe()
+			defer client.Close()
+			go func() {
+				_, _ = client.Write([]byte("ping"))
+				_, _ = io.ReadAll(client)
+			}()
+
+			var conn net.Conn
+			mdlw.Measure("ws", rep, func() {
+				conn = rep.hijack(server)
+			})
+
+			// The connection outlives the request.
+			props := metrics.HTTPProperties{ID: "ws"}
+			assert.Equal(test.expConns, mrec.HijackedConnections(props))
+
+			_, err := io.ReadFull(conn, make([]byte, 4))
+			require.NoError(err)
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(err)
+			require.NoError(conn.Close())
+			_ = conn.Close()
+
+			q := memory.Query{ID: "ws", Method: "GET", Code: "101"}
+			assert.Equal(0, mrec.HijackedConnections(props))
+			assert.Equal(test.expConns, mrec.HijackedConnDuration(q).Count)
+			assert.Equal(test.expBytesRead, mrec.HijackedConnBytesRead(q).Sum)
+			assert.Equal(test.expBytesWrite, mrec.HijackedConnBytesWritten(q).Sum)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Package bodycounter counts the bytes read from the HTTP request bodies, so the
+// framework middlewares can report the request size.
+package bodycounter
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Body is a request body that counts the bytes read from it.
+type Body struct {
+	io.ReadCloser
+	contentLength int64
+	read          atomic.Int64
+}
+
+// Wrap replaces the body of the request with a counting body and returns it.
+// Requests without body are not wrapped, but the returned body can still be used.
+func Wrap(r *http.Request) *Body {
+	b := &Body{
+		ReadCloser:    r.Body,
+		contentLength: r.ContentLength,
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = b
+	}
+
+	return b
+}
+
+// Read satisfies io.Reader interface.
+func (b *Body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read.Add(int64(n))
+	return n, err
+}
+
+// BytesRead returns the bytes read from the body, if the body has not been read
+// it will fall back to the request `Content-Length`. A nil body (not wrapped) has
+// not read any byte.
+func (b *Body) BytesRead() int64 {
+	if b == nil {
+		return 0
+	}
+
+	read := b.read.Load()
+	if read == 0 && b.contentLength > 0 {
+		return b.contentLength
+	}
+
+	return read
+}
@@ -0,0 +1,87 @@
+// Package firstbyte tracks when the first byte of the HTTP responses is written, so the
+// framework middlewares can report the time to first byte.
+package firstbyte
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Writer is a response writer that tracks the time of the first header or body
+// byte written on it.
+type Writer struct {
+	http.ResponseWriter
+	firstByteTime time.Time
+}
+
+// Wrap returns a tracking writer that writes on w.
+func Wrap(w http.ResponseWriter) *Writer {
+	return &Writer{ResponseWriter: w}
+}
+
+// FirstByteTime returns the time of the first byte written, it will be the zero
+// time if nothing has been written yet.
+func (w *Writer) FirstByteTime() time.Time { return w.firstByteTime }
+
+// WriteHeader satisfies http.ResponseWriter interface.
+func (w *Writer) WriteHeader(statusCode int) {
+	w.mark()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write satisfies http.ResponseWriter interface.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mark()
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush satisfies http.Flusher interface.
+func (w *Writer) Flush() {
+	f, ok := w.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+
+	w.mark()
+	f.Flush()
+}
+
+// Hijack satisfies http.Hijacker interface.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("type assertion failed http.ResponseWriter not a http.Hijacker")
+	}
+	return h.Hijack()
+}
+
+// CloseNotify satisfies http.CloseNotifier interface, some frameworks (e.g go-restful)
+// expect it from the response writers. If the wrapped writer doesn't support it, the
+// returned channel never receives.
+func (w *Writer) CloseNotify() <-chan bool {
+	cn, ok := w.ResponseWriter.(http.CloseNotifier) //nolint:staticcheck
+	if !ok {
+		return make(chan bool)
+	}
+	return cn.CloseNotify()
+}
+
+// Unwrap returns the wrapped response writer, used by http.ResponseController.
+func (w *Writer) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *Writer) mark() {
+	if w.firstByteTime.IsZero() {
+		w.firstByteTime = time.Now()
+	}
+}
+
+// Check interface implementations.
+var (
+	_ http.ResponseWriter = &Writer{}
+	_ http.Hijacker       = &Writer{}
+	_ http.Flusher        = &Writer{}
+	_ http.CloseNotifier  = &Writer{} //nolint:staticcheck
+)
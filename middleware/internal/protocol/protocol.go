@@ -0,0 +1,32 @@
+// Package protocol gets the HTTP protocol version and scheme of the requests, so the
+// framework middlewares report them in the same way.
+package protocol
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Proto returns the normalized HTTP protocol version of the request (`HTTP/1.0`, `HTTP/1.1`,
+// `HTTP/2` or `HTTP/3`).
+func Proto(r *http.Request) string {
+	switch {
+	case r.ProtoMajor == 1 && r.ProtoMinor == 0:
+		return "HTTP/1.0"
+	case r.ProtoMajor == 1:
+		return "HTTP/1.1"
+	case r.ProtoMajor > 1:
+		return "HTTP/" + strconv.Itoa(r.ProtoMajor)
+	default:
+		return r.Proto
+	}
+}
+
+// Scheme returns the scheme of the request (`http` or `https`), based on the
+// connection TLS state.
+func Scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -3,16 +3,24 @@ package iris
 
 import (
 	"context"
+	"time"
 
 	"github.com/kataras/iris/v12"
+	irisctx "github.com/kataras/iris/v12/context"
 
 	"github.com/slok/go-http-metrics/middleware"
+	"github.com/slok/go-http-metrics/middleware/internal/bodycounter"
+	"github.com/slok/go-http-metrics/middleware/internal/firstbyte"
+	"github.com/slok/go-http-metrics/middleware/internal/protocol"
 )
 
 // Handler returns a Iris measuring middleware.
 func Handler(handlerID string, m middleware.Middleware) iris.Handler {
 	return func(ctx iris.Context) {
-		r := &reporter{ctx: ctx}
+		// Iris writes the headers lazily, so we track the underlying writer.
+		w := firstbyte.Wrap(ctx.ResponseWriter().Naive())
+		ctx.ResponseWriter().SetWriter(w)
+		r := &reporter{ctx: ctx, w: w}
 		m.Measure(handlerID, r, func() {
 			ctx.Next()
 		})
@@ -20,15 +28,45 @@ func Handler(handlerID string, m middleware.Middleware) iris.Handler {
 }
 
 type reporter struct {
-	ctx iris.Context
+	ctx  iris.Context
+	body *bodycounter.Body
+	w    *firstbyte.Writer
 }
 
 func (r *reporter) Method() string { return r.ctx.Method() }
 
 func (r *reporter) Context() context.Context { return r.ctx.Request().Context() }
 
+func (r *reporter) SetContextValue(key, value any) {
+	req := r.ctx.Request()
+	r.ctx.ResetRequest(req.WithContext(context.WithValue(req.Context(), key, value)))
+}
+
 func (r *reporter) URLPath() string { return r.ctx.Path() }
 
 func (r *reporter) StatusCode() int { return r.ctx.GetStatusCode() }
 
 func (r *reporter) BytesWritten() int64 { return int64(r.ctx.ResponseWriter().Written()) }
+
+func (r *reporter) BytesRead() int64 { return r.body.BytesRead() }
+
+func (r *reporter) CountBytesRead() { r.body = bodycounter.Wrap(r.ctx.Request()) }
+
+func (r *reporter) RequestHeader(name string) string { return r.ctx.GetHeader(name) }
+
+func (r *reporter) Proto() string { return protocol.Proto(r.ctx.Request()) }
+
+func (r *reporter) Scheme() string { return protocol.Scheme(r.ctx.Request()) }
+
+func (r *reporter) FirstByteTime() time.Time { return r.w.FirstByteTime() }
+
+func (r *reporter) RespondPanic(statusCode int, body []byte) {
+	// The rest of the handlers chain must not be executed.
+	r.ctx.StopExecution()
+	if r.ctx.ResponseWriter().Written() != irisctx.NoWritten {
+		return
+	}
+	r.ctx.ContentType("text/plain; charset=utf-8")
+	r.ctx.StatusCode(statusCode)
+	_, _ = r.ctx.Write(body)
+}
@@ -4,7 +4,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +15,7 @@ import (
 
 	mmetrics "github.com/slok/go-http-metrics/internal/mocks/metrics"
 	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
 	"github.com/slok/go-http-metrics/middleware"
 	irismiddleware "github.com/slok/go-http-metrics/middleware/iris"
 )
@@ -58,6 +61,39 @@ func TestMiddleware(t *testing.T) {
 			expRespBody: "test1",
 		},
 
+		"Setting the handler ID from the handler should measure the request with it.": {
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/test", nil)
+			},
+			mock: func(m *mmetrics.Recorder) {
+				expHTTPReqProps := metrics.HTTPReqProperties{
+					ID:      "/users/{id}",
+					Service: "",
+					Method:  "POST",
+					Code:    "202",
+				}
+				m.On("ObserveHTTPRequestDuration", mock.Anything, expHTTPReqProps, mock.Anything).Once()
+				m.On("ObserveHTTPResponseSize", mock.Anything, expHTTPReqProps, int64(5)).Once()
+
+				// Inflight requests use the initial handler ID.
+				expHTTPProps := metrics.HTTPProperties{
+					ID:      "/test",
+					Service: "",
+				}
+				m.On("AddInflightRequests", mock.Anything, expHTTPProps, 1).Once()
+				m.On("AddInflightRequests", mock.Anything, expHTTPProps, -1).Once()
+			},
+			handler: func() iris.Handler {
+				return func(ctx iris.Context) {
+					middleware.SetHandlerID(ctx.Request().Context(), "/users/{id}")
+					ctx.StatusCode(iris.StatusAccepted)
+					_, _ = ctx.WriteString("test1")
+				}
+			},
+			expRespCode: 202,
+			expRespBody: "test1",
+		},
+
 		"A default HTTP middleware using JSON should call the recorder to measure (Regression test: https://github.com/slok/go-http-metrics/issues/31).": {
 			req: func() *http.Request {
 				return httptest.NewRequest(http.MethodPost, "/test", nil)
@@ -122,3 +158,57 @@ func TestMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareRequestSize(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureRequestSize: true})
+	app := iris.New().Configure(iris.WithOptimizations)
+	app.Post("/test", irismiddleware.Handler("", mdlw), func(ctx iris.Context) {
+		// Read only part of the body.
+		_, _ = io.ReadFull(ctx.Request().Body, make([]byte, 4))
+		ctx.StatusCode(iris.StatusAccepted)
+	})
+	require.NoError(app.Build())
+
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("0123456789")))
+
+	assert.Equal(memory.Aggregate{Count: 1, Sum: 4, Min: 4, Max: 4}, mrec.RequestSize(memory.Query{ID: "/test"}))
+}
+
+func TestMiddlewareTimeToFirstByte(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureTimeToFirstByte: true})
+	app := iris.New().Configure(iris.WithOptimizations)
+	app.Get("/test", irismiddleware.Handler("", mdlw), func(ctx iris.Context) {
+		_, _ = ctx.WriteString("test")
+		time.Sleep(20 * time.Millisecond)
+	})
+	require.NoError(app.Build())
+
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	ttfb := mrec.TimeToFirstByte(memory.Query{ID: "/test"})
+	assert.Equal(1, ttfb.Count)
+	assert.Less(ttfb.Sum, 0.02)
+}
+
+func TestMiddlewareProtocol(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureProtocol: true})
+	app := iris.New().Configure(iris.WithOptimizations)
+	app.Get("/test", irismiddleware.Handler("", mdlw), func(ctx iris.Context) {})
+	require.NoError(app.Build())
+
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/test", nil))
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Proto: "HTTP/1.1", Scheme: "https"}))
+}
@@ -0,0 +1,38 @@
+package middleware
+
+// LabelExtractor returns extra label key/values of a request.
+type LabelExtractor func(reporter Reporter) map[string]string
+
+// labelOtherValue is the value used by the label extractors for the not allowed values.
+const labelOtherValue = "other"
+
+// HeaderLabel returns a label extractor that sets the label with the value of a request
+// header, the Reporter needs to implement `HeaderReporter`. To keep the label values bounded,
+// the values that are not allowed are set as `other`, if there are no allowed values, any
+// value is allowed. The requests without the header will not have the label.
+func HeaderLabel(label, header string, allowed ...string) LabelExtractor {
+	allowedValues := make(map[string]struct{}, len(allowed))
+	for _, v := range allowed {
+		allowedValues[v] = struct{}{}
+	}
+
+	return func(reporter Reporter) map[string]string {
+		hr, ok := reporter.(HeaderReporter)
+		if !ok {
+			return nil
+		}
+
+		value := hr.RequestHeader(header)
+		if value == "" {
+			return nil
+		}
+
+		if len(allowedValues) > 0 {
+			if _, ok := allowedValues[value]; !ok {
+				value = labelOtherValue
+			}
+		}
+
+		return map[string]string{label: value}
+	}
+}
@@ -0,0 +1,94 @@
+package middleware_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mockmiddleware "github.com/slok/go-http-metrics/internal/mocks/middleware"
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+)
+
+// headerReporter is a reporter that reports the request headers.
+type headerReporter struct {
+	*mockmiddleware.Reporter
+	headers map[string]string
+}
+
+func (r headerReporter) RequestHeader(name string) string { return r.headers[name] }
+
+func TestMiddlewareLabelExtractors(t *testing.T) {
+	tests := map[string]struct {
+		extractors []middleware.LabelExtractor
+		headers    map[string]string
+		expLabels  map[string]string
+	}{
+		"Without label extractors, it shouldn't have extra labels.": {
+			headers:   map[string]string{"X-Api-Version": "v2"},
+			expLabels: map[string]string{},
+		},
+
+		"Having label extractors, it should have the extracted labels.": {
+			extractors: []middleware.LabelExtractor{
+				func(middleware.Reporter) map[string]string { return map[string]string{"tier": "gold"} },
+				func(r middleware.Reporter) map[string]string { return map[string]string{"path": r.URLPath()} },
+			},
+			expLabels: map[string]string{"tier": "gold", "path": "/test/01"},
+		},
+
+		"Having a header label extractor, it should have the header value label.": {
+			extractors: []middleware.LabelExtractor{middleware.HeaderLabel("version", "X-Api-Version", "v1", "v2")},
+			headers:    map[string]string{"X-Api-Version": "v2"},
+			expLabels:  map[string]string{"version": "v2"},
+		},
+
+		"Having a header label extractor with a not allowed value, it should have the other value label.": {
+			extractors: []middleware.LabelExtractor{middleware.HeaderLabel("version", "X-Api-Version", "v1", "v2")},
+			headers:    map[string]string{"X-Api-Version": "v3-beta"},
+			expLabels:  map[string]string{"version": "other"},
+		},
+
+		"Having a header label extractor without allowed values, it should allow any value.": {
+			extractors: []middleware.LabelExtractor{middleware.HeaderLabel("version", "X-Api-Version")},
+			headers:    map[string]string{"X-Api-Version": "v3-beta"},
+			expLabels:  map[string]string{"version": "v3-beta"},
+		},
+
+		"Having a header label extractor without the header, it shouldn't have the label.": {
+			extractors: []middleware.LabelExtractor{middleware.HeaderLabel("version", "X-Api-Version", "v1", "v2")},
+			expLabels:  map[string]string{},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(0))
+			mrep.On("URLPath").Return("/test/01")
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec, LabelExtractors: test.extractors})
+			mdlw.Measure("test01", headerReporter{Reporter: mrep, headers: test.headers}, func() {})
+
+			// All the metrics of the request should have the same labels.
+			obs := mrec.Observations()
+			assert.NotEmpty(obs)
+			for _, o := range obs {
+				if o.Metric == memory.MetricInflightRequests {
+					assert.Equal(test.expLabels, o.Props.Labels.Map())
+					continue
+				}
+				assert.Equal(test.expLabels, o.ReqProps.Labels.Map())
+			}
+			assert.Equal(0, mrec.InflightRequests(metrics.HTTPProperties{ID: "test01", Labels: metrics.NewLabels(test.expLabels)}))
+		})
+	}
+}
@@ -6,8 +6,9 @@ package middleware
 
 import (
 	"context"
-	"fmt"
-	"strconv"
+	"errors"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/slok/go-http-metrics/metrics"
@@ -24,23 +25,102 @@ type Config struct {
 	// 200, 201, and 203 will have the label `code="2xx"`. This impacts on the cardinality
 	// of the metrics and also improves the performance of queries that are grouped by
 	// status code because there are already aggregated in the metric.
-	// By default will be false.
+	// By default will be false. Ignored when `StatusCodeFormatter` is set.
 	GroupedStatus bool
+	// StatusCodeFormatter formats the status codes of the responses as the status code of the
+	// metrics, check the `StatusCode...` formatters (e.g `StatusCodeClassExcept(401, 404, 429)`
+	// to keep the detail of some status codes while grouping the rest). By default the status
+	// codes are formatted as they are (`StatusCodeExact`), or by class (`StatusCodeClass`)
+	// if `GroupedStatus` is enabled.
+	StatusCodeFormatter StatusCodeFormatter
 	// DisableMeasureSize will disable the recording metrics about the response size,
 	// by default measuring size is enabled (`DisableMeasureSize` is false).
 	DisableMeasureSize bool
+	// MeasureRequestSize will enable the recording metrics about the request size, by default
+	// is disabled. The request size is only measured when the Recorder implements
+	// `metrics.RequestSizeRecorder` and the Reporter implements `BytesReadReporter`.
+	MeasureRequestSize bool
+	// MeasureTimeToFirstByte will enable the recording metrics about the time to first byte,
+	// the time from the start of the measurement until the first header or body byte of the
+	// response is written, by default is disabled. The time to first byte is only measured when
+	// the Recorder implements `metrics.TimeToFirstByteRecorder` and the Reporter implements
+	// `FirstByteReporter`.
+	MeasureTimeToFirstByte bool
+	// MeasureProtocol will set the HTTP protocol version and scheme of the requests on the request
+	// metrics properties (`Proto` and `Scheme`), by default is disabled. They are only set when the
+	// Reporter implements `ProtocolReporter`, and the recorders may need them enabled to record
+	// them (e.g Prometheus `EnableProtoLabel` and `EnableSchemeLabel` options).
+	MeasureProtocol bool
+	// MeasureStreams will enable the recording metrics about the streaming responses (e.g Server-Sent
+	// Events), the responses flushed by the handlers. Each flush is measured with the bytes written and
+	// the interval since the previous flush (Server-Sent Events handlers usually flush once per event),
+	// and the lifetime of the streams is measured when the handlers finish. By default is disabled. The
+	// streams are only measured when the Recorder implements `metrics.StreamRecorder` and the Reporter
+	// implements `StreamReporter`.
+	MeasureStreams bool
+	// ExcludeStreamsFromDuration will not measure the streaming responses (the ones flushed at least once)
+	// on the request duration metric, only on the stream duration metric, this way the long-lived streams
+	// don't distort the request latencies. By default is false. Requires `MeasureStreams`.
+	ExcludeStreamsFromDuration bool
+	// MeasureHijackedConnections will enable the recording metrics about the hijacked connections (e.g
+	// WebSockets), the number of open hijacked connections, and the lifetime and the bytes read and
+	// written of the connections, measured when they are closed. The hijacked requests are measured
+	// with the 101 status code unless the handler writes another one before hijacking. By default is
+	// disabled. The hijacked connections are only measured when the Recorder implements
+	// `metrics.HijackRecorder` and the Reporter implements `HijackReporter`.
+	MeasureHijackedConnections bool
 	// DisableMeasureInflight will disable the recording metrics about the inflight requests number,
 	// by default measuring inflights is enabled (`DisableMeasureInflight` is false).
 	DisableMeasureInflight bool
 	// IgnoredPaths is a list of paths that will not be measured for the request duration
 	// and the response size. They will still be counted in the RequestsInflight metric.
 	IgnoredPaths []string
+	// Skipper knows which requests will not be measured (e.g all the `OPTIONS` requests or
+	// the `/internal/*` paths), check the `Skip...` helpers. The skipped requests will still be
+	// counted in the RequestsInflight metric unless `SkipInflight` is enabled. By default no
+	// requests are skipped.
+	Skipper Skipper
+	// SkipInflight will not count the skipped requests by the `Skipper` in the RequestsInflight
+	// metric. By default is false.
+	SkipInflight bool
+	// RecoverPanics will recover the panics of the handlers after measuring them, instead of
+	// panicking again so the framework recovery handles them. The recovered requests will get a
+	// 500 response with the `PanicResponseBody`, if nothing has been written yet and the Reporter
+	// implements `PanicResponder`. `http.ErrAbortHandler` panics are never recovered. By default
+	// panics are not recovered.
+	RecoverPanics bool
+	// PanicResponseBody is the body of the response of the recovered panics, by default
+	// is `Internal Server Error`.
+	PanicResponseBody string
+	// CanceledStatusCode is the status code that will be measured for the requests canceled by
+	// the clients (e.g `499`), these are the requests whose context has been canceled when the
+	// handler finishes. By default (zero) the status code of the response is measured. The canceled
+	// requests are also counted when the Recorder implements `metrics.ClientAbortRecorder`.
+	CanceledStatusCode int
+	// LabelExtractors return the extra labels (`metrics.Labels`) of the requests (e.g API version,
+	// tenant tier...). They are called before the handler, so all the metrics of a request have the
+	// same extra labels. The extracted values must be a bounded set to not explode the metrics
+	// cardinality, and the recorders may need the extra label keys declared beforehand. By default
+	// there are no extra labels.
+	LabelExtractors []LabelExtractor
 }
 
 func (c *Config) defaults() {
 	if c.Recorder == nil {
 		c.Recorder = metrics.Dummy
 	}
+
+	if c.StatusCodeFormatter == nil {
+		if c.GroupedStatus {
+			c.StatusCodeFormatter = StatusCodeClass()
+		} else {
+			c.StatusCodeFormatter = StatusCodeExact()
+		}
+	}
+
+	if c.PanicResponseBody == "" {
+		c.PanicResponseBody = http.StatusText(http.StatusInternalServerError)
+	}
 }
 
 // Middleware is a service that knows how to measure an HTTP handler by wrapping
@@ -53,10 +133,24 @@ func (c *Config) defaults() {
 type Middleware struct {
 	recorder               metrics.Recorder
 	service                string
-	groupedStatus          bool
+	statusCodes            *statusCodeTable
 	disableMeasureSize     bool
 	disableMeasureInflight bool
+	measureProtocol        bool
 	ignoredPaths           map[string]struct{}
+	skipper                Skipper
+	skipInflight           bool
+	requestSizeRecorder    metrics.RequestSizeRecorder
+	ttfbRecorder           metrics.TimeToFirstByteRecorder
+	streamRecorder         metrics.StreamRecorder
+	excludeStreamsDuration bool
+	hijackRecorder         metrics.HijackRecorder
+	panicRecorder          metrics.PanicRecorder
+	recoverPanics          bool
+	panicResponseBody      []byte
+	canceledStatusCode     int
+	clientAbortRecorder    metrics.ClientAbortRecorder
+	labelExtractors        []LabelExtractor
 }
 
 // New returns the a Middleware service.
@@ -71,10 +165,36 @@ func New(cfg Config) Middleware {
 	m := Middleware{
 		recorder:               cfg.Recorder,
 		service:                cfg.Service,
-		groupedStatus:          cfg.GroupedStatus,
+		statusCodes:            newStatusCodeTable(cfg.StatusCodeFormatter),
 		disableMeasureSize:     cfg.DisableMeasureSize,
 		disableMeasureInflight: cfg.DisableMeasureInflight,
+		measureProtocol:        cfg.MeasureProtocol,
 		ignoredPaths:           ignPaths,
+		skipper:                cfg.Skipper,
+		skipInflight:           cfg.SkipInflight,
+		recoverPanics:          cfg.RecoverPanics,
+		panicResponseBody:      []byte(cfg.PanicResponseBody),
+		canceledStatusCode:     cfg.CanceledStatusCode,
+		labelExtractors:        cfg.LabelExtractors,
+		excludeStreamsDuration: cfg.ExcludeStreamsFromDuration,
+	}
+	m.panicRecorder, _ = cfg.Recorder.(metrics.PanicRecorder)
+	m.clientAbortRecorder, _ = cfg.Recorder.(metrics.ClientAbortRecorder)
+
+	if cfg.MeasureRequestSize {
+		m.requestSizeRecorder, _ = cfg.Recorder.(metrics.RequestSizeRecorder)
+	}
+
+	if cfg.MeasureTimeToFirstByte {
+		m.ttfbRecorder, _ = cfg.Recorder.(metrics.TimeToFirstByteRecorder)
+	}
+
+	if cfg.MeasureStreams {
+		m.streamRecorder, _ = cfg.Recorder.(metrics.StreamRecorder)
+	}
+
+	if cfg.MeasureHijackedConnections {
+		m.hijackRecorder, _ = cfg.Recorder.(metrics.HijackRecorder)
 	}
 
 	return m
@@ -94,11 +214,29 @@ func (m Middleware) Measure(handlerID string, reporter Reporter, next func()) {
 		hid = reporter.URLPath()
 	}
 
+	skipped := m.skipper != nil && m.skipper(SkipRequest{
+		Method:    reporter.Method(),
+		Path:      reporter.URLPath(),
+		HandlerID: handlerID,
+		reporter:  reporter,
+	})
+
+	labels := m.extractLabels(reporter)
+
+	// Let the handler override the measurement, if the Reporter can pass
+	// the overrides to the handler.
+	var ov *overrides
+	if cvs, ok := reporter.(ContextValueSetter); ok {
+		ov = &overrides{}
+		cvs.SetContextValue(overridesKey{}, ov)
+	}
+
 	// Measure inflights if required.
-	if !m.disableMeasureInflight {
+	if !m.disableMeasureInflight && !(skipped && m.skipInflight) {
 		props := metrics.HTTPProperties{
 			Service: m.service,
 			ID:      hid,
+			Labels:  labels,
 		}
 		m.recorder.AddInflightRequests(ctx, props, 1)
 		defer m.recorder.AddInflightRequests(ctx, props, -1)
@@ -106,42 +244,231 @@ func (m Middleware) Measure(handlerID string, reporter Reporter, next func()) {
 
 	// Start the timer and when finishing measure the duration.
 	start := time.Now()
+
+	// Measure the flushes of the streaming responses if required and supported.
+	var st *stream
+	if sr, ok := reporter.(StreamReporter); ok && m.streamRecorder != nil && !skipped {
+		st = &stream{lastFlush: start}
+		sr.OnFlush(func() { m.measureFlush(ctx, hid, labels, ov, reporter, st) })
+	}
+
+	// Measure the hijacked connections if required and supported.
+	if hr, ok := reporter.(HijackReporter); ok && m.hijackRecorder != nil && !skipped {
+		hr.OnHijack(func(conn net.Conn) net.Conn {
+			return m.measureHijack(ctx, hid, labels, ov, reporter, conn)
+		})
+	}
+
 	defer func() {
-		_, shouldIgnore := m.ignoredPaths[reporter.URLPath()]
-		if shouldIgnore {
-			return
+		// A panicking handler is measured as an internal server error, after
+		// being measured, the panic continues unless we need to recover it.
+		recovered := recover()
+		panicked := recovered != nil
+		repanic := panicked && (!m.recoverPanics || recovered == http.ErrAbortHandler)
+		if panicked && !repanic {
+			if pr, ok := reporter.(PanicResponder); ok {
+				pr.RespondPanic(http.StatusInternalServerError, m.panicResponseBody)
+			}
 		}
 
-		duration := time.Since(start)
-
-		// If we need to group the status code, it uses the
-		// first number of the status code because is the least
-		// required identification way.
-		var code string
-		if m.groupedStatus {
-			code = fmt.Sprintf("%dxx", reporter.StatusCode()/100)
-		} else {
-			code = strconv.Itoa(reporter.StatusCode())
+		// The inflight requests use the initial handler ID and labels, this way they
+		// are always decremented with the same ones they were incremented.
+		id, reqLabels, skip := hid, labels, skipped
+		if ov != nil {
+			var skipMeasurement bool
+			id, reqLabels, skipMeasurement = ov.apply(hid, labels)
+			skip = skip || skipMeasurement
 		}
 
-		props := metrics.HTTPReqProperties{
-			Service: m.service,
-			ID:      hid,
-			Method:  reporter.Method(),
-			Code:    code,
+		if !skip {
+			m.measure(ctx, id, reqLabels, reporter, start, panicked, st)
 		}
-		m.recorder.ObserveHTTPRequestDuration(ctx, props, duration)
 
-		// Measure size of response if required.
-		if !m.disableMeasureSize {
-			m.recorder.ObserveHTTPResponseSize(ctx, props, reporter.BytesWritten())
+		if repanic {
+			panic(recovered)
 		}
 	}()
 
+	// Count the bytes read from the request body if required and supported.
+	if bc, ok := reporter.(BytesReadCounter); ok && m.requestSizeRecorder != nil && !skipped {
+		bc.CountBytesRead()
+	}
+
 	// Call the wrapped logic.
 	next()
 }
 
+// measure measures the finished request.
+func (m Middleware) measure(ctx context.Context, hid string, labels metrics.Labels, reporter Reporter, start time.Time, panicked bool, st *stream) {
+	_, shouldIgnore := m.ignoredPaths[reporter.URLPath()]
+	if shouldIgnore {
+		return
+	}
+
+	duration := time.Since(start)
+
+	// The clients can go away before the handler finishes, we don't
+	// want to mix these with the responses the clients received.
+	aborted := !panicked && canceled(ctx, reporter)
+
+	var statusCode int
+	switch {
+	case panicked:
+		statusCode = http.StatusInternalServerError
+	case aborted && m.canceledStatusCode != 0:
+		statusCode = m.canceledStatusCode
+	default:
+		statusCode = reporter.StatusCode()
+	}
+
+	props := m.reqProperties(hid, labels, reporter, statusCode)
+
+	// The streams are measured with their own duration.
+	streamed := st != nil && st.flushes > 0
+	if streamed {
+		m.streamRecorder.ObserveHTTPStreamDuration(ctx, props, duration)
+	}
+	if !streamed || !m.excludeStreamsDuration {
+		m.recorder.ObserveHTTPRequestDuration(ctx, props, duration)
+	}
+
+	// Measure size of response if required.
+	if !m.disableMeasureSize {
+		m.recorder.ObserveHTTPResponseSize(ctx, props, reporter.BytesWritten())
+	}
+
+	// Measure size of request if required and supported.
+	if m.requestSizeRecorder != nil {
+		if br, ok := reporter.(BytesReadReporter); ok {
+			m.requestSizeRecorder.ObserveHTTPRequestSize(ctx, props, br.BytesRead())
+		}
+	}
+
+	// Measure time to first byte if required and supported. If nothing has been
+	// written by the handler, the response will be written after it, so we use
+	// the request duration.
+	if m.ttfbRecorder != nil {
+		if fbr, ok := reporter.(FirstByteReporter); ok {
+			ttfb := duration
+			if t := fbr.FirstByteTime(); !t.IsZero() {
+				ttfb = t.Sub(start)
+			}
+			m.ttfbRecorder.ObserveHTTPTimeToFirstByte(ctx, props, ttfb)
+		}
+	}
+
+	if panicked && m.panicRecorder != nil {
+		m.panicRecorder.IncHTTPPanics(ctx, metrics.HTTPProperties{Service: m.service, ID: hid, Labels: labels})
+	}
+
+	if aborted && m.clientAbortRecorder != nil {
+		m.clientAbortRecorder.IncHTTPClientAborts(ctx, metrics.HTTPProperties{Service: m.service, ID: hid, Labels: labels})
+	}
+}
+
+// stream is the state of a streaming response being measured.
+type stream struct {
+	flushes      int
+	bytesFlushed int64
+	lastFlush    time.Time
+}
+
+// measureFlush measures a flush of a streaming response, the handler ID and labels
+// overrides set until the flush are used.
+func (m Middleware) measureFlush(ctx context.Context, hid string, labels metrics.Labels, ov *overrides, reporter Reporter, st *stream) {
+	if _, shouldIgnore := m.ignoredPaths[reporter.URLPath()]; shouldIgnore {
+		return
+	}
+
+	if ov != nil {
+		var skip bool
+		hid, labels, skip = ov.apply(hid, labels)
+		if skip {
+			return
+		}
+	}
+
+	now := time.Now()
+	written := reporter.BytesWritten()
+	props := m.reqProperties(hid, labels, reporter, reporter.StatusCode())
+	m.streamRecorder.ObserveHTTPStreamFlush(ctx, props, written-st.bytesFlushed, now.Sub(st.lastFlush))
+
+	st.flushes++
+	st.bytesFlushed = written
+	st.lastFlush = now
+}
+
+// measureHijack starts measuring a hijacked connection, it returns the connection that will be
+// measured when closed. The handler ID and labels overrides set until the hijack are used.
+func (m Middleware) measureHijack(ctx context.Context, hid string, labels metrics.Labels, ov *overrides, reporter Reporter, conn net.Conn) net.Conn {
+	if _, shouldIgnore := m.ignoredPaths[reporter.URLPath()]; shouldIgnore {
+		return conn
+	}
+
+	if ov != nil {
+		var skip bool
+		hid, labels, skip = ov.apply(hid, labels)
+		if skip {
+			return conn
+		}
+	}
+
+	// The hijacked connections outlive the requests.
+	ctx = context.WithoutCancel(ctx)
+	props := metrics.HTTPProperties{Service: m.service, ID: hid, Labels: labels}
+	reqProps := m.reqProperties(hid, labels, reporter, reporter.StatusCode())
+
+	m.hijackRecorder.AddHijackedConnections(ctx, props, 1)
+	return newHijackedConn(conn, func(hc metrics.HijackedConnection) {
+		m.hijackRecorder.AddHijackedConnections(ctx, props, -1)
+		m.hijackRecorder.ObserveHTTPHijackedConnection(ctx, reqProps, hc)
+	})
+}
+
+// reqProperties returns the properties of the request metrics.
+func (m Middleware) reqProperties(hid string, labels metrics.Labels, reporter Reporter, statusCode int) metrics.HTTPReqProperties {
+	props := metrics.HTTPReqProperties{
+		Service: m.service,
+		ID:      hid,
+		Method:  reporter.Method(),
+		Code:    m.statusCodes.format(statusCode),
+		Labels:  labels,
+	}
+	if m.measureProtocol {
+		if pr, ok := reporter.(ProtocolReporter); ok {
+			props.Proto = pr.Proto()
+			props.Scheme = pr.Scheme()
+		}
+	}
+
+	return props
+}
+
+// extractLabels returns the extra labels of the request.
+func (m Middleware) extractLabels(reporter Reporter) metrics.Labels {
+	if len(m.labelExtractors) == 0 {
+		return metrics.Labels{}
+	}
+
+	kvs := map[string]string{}
+	for _, extract := range m.labelExtractors {
+		for k, v := range extract(reporter) {
+			kvs[k] = v
+		}
+	}
+
+	return metrics.NewLabels(kvs)
+}
+
+// canceled returns if the request has been canceled by the client.
+func canceled(ctx context.Context, reporter Reporter) bool {
+	if cr, ok := reporter.(CanceledReporter); ok {
+		return cr.Canceled()
+	}
+
+	return errors.Is(ctx.Err(), context.Canceled)
+}
+
 // Reporter knows how to report the data to the Middleware so it can measure the
 // different framework/libraries.
 type Reporter interface {
@@ -151,3 +478,68 @@ type Reporter interface {
 	StatusCode() int
 	BytesWritten() int64
 }
+
+// BytesReadReporter is an optional Reporter capability that knows how to report the
+// number of bytes read from the request body.
+type BytesReadReporter interface {
+	BytesRead() int64
+}
+
+// BytesReadCounter is an optional Reporter capability that starts counting the bytes read
+// from the request body, it's called before the handler only when the request size is
+// measured, so the bodies of the requests not measured are not wrapped.
+type BytesReadCounter interface {
+	CountBytesRead()
+}
+
+// FirstByteReporter is an optional Reporter capability that knows how to report the
+// time when the first byte of the response (headers or body) was written. It will
+// return the zero time if nothing has been written yet.
+type FirstByteReporter interface {
+	FirstByteTime() time.Time
+}
+
+// PanicResponder is an optional Reporter capability that knows how to write the response
+// of a recovered handler panic, it should only write it if nothing has been written yet.
+type PanicResponder interface {
+	RespondPanic(statusCode int, body []byte)
+}
+
+// CanceledReporter is an optional Reporter capability that knows if the request has been
+// canceled. By default the Reporter context cancellation is used.
+type CanceledReporter interface {
+	Canceled() bool
+}
+
+// ContextValueSetter is an optional Reporter capability that knows how to set a value on the
+// request context that the handler receives, it is required by `SetHandlerID` and `SkipMeasurement`.
+type ContextValueSetter interface {
+	SetContextValue(key, value any)
+}
+
+// ProtocolReporter is an optional Reporter capability that knows how to report the HTTP
+// protocol version (`HTTP/1.0`, `HTTP/1.1`, `HTTP/2` or `HTTP/3`) and the scheme (`http`
+// or `https`) of the request.
+type ProtocolReporter interface {
+	Proto() string
+	Scheme() string
+}
+
+// StreamReporter is an optional Reporter capability that knows how to report the flushes
+// of the streaming responses, it calls the function after each flush of the response.
+type StreamReporter interface {
+	OnFlush(f func())
+}
+
+// HijackReporter is an optional Reporter capability that knows how to report the hijacked
+// connections, it calls the function with the connection when the handler hijacks it, and
+// the handler gets the connection returned by the function.
+type HijackReporter interface {
+	OnHijack(f func(conn net.Conn) net.Conn)
+}
+
+// HeaderReporter is an optional Reporter capability that knows how to report the
+// request headers.
+type HeaderReporter interface {
+	RequestHeader(name string) string
+}
@@ -2,7 +2,9 @@ package middleware_test
 
 import (
 	"context"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -10,6 +12,7 @@ import (
 	mockmetrics "github.com/slok/go-http-metrics/internal/mocks/metrics"
 	mockmiddleware "github.com/slok/go-http-metrics/internal/mocks/middleware"
 	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
 	"github.com/slok/go-http-metrics/middleware"
 )
 
@@ -192,3 +195,344 @@ func TestMiddlewareMeasure(t *testing.T) {
 		})
 	}
 }
+
+// requestSizeReporter is a reporter that reports the request bytes read.
+type requestSizeReporter struct {
+	*mockmiddleware.Reporter
+	bytesRead int64
+}
+
+func (r requestSizeReporter) BytesRead() int64 { return r.bytesRead }
+
+func TestMiddlewareMeasureRequestSize(t *testing.T) {
+	tests := map[string]struct {
+		config       middleware.Config
+		reporter     func(mrep *mockmiddleware.Reporter) middleware.Reporter
+		expReqSizeBy map[string]memory.Aggregate
+	}{
+		"Having a reporter that reports the bytes read, it should measure the request size.": {
+			config: middleware.Config{Service: "svc1", MeasureRequestSize: true},
+			reporter: func(mrep *mockmiddleware.Reporter) middleware.Reporter {
+				return requestSizeReporter{Reporter: mrep, bytesRead: 1024}
+			},
+			expReqSizeBy: map[string]memory.Aggregate{
+				"PATCH": {Count: 1, Sum: 1024, Min: 1024, Max: 1024},
+			},
+		},
+
+		"Having a reporter that doesn't report the bytes read, it shouldn't measure the request size.": {
+			config: middleware.Config{Service: "svc1", MeasureRequestSize: true},
+			reporter: func(mrep *mockmiddleware.Reporter) middleware.Reporter {
+				return mrep
+			},
+			expReqSizeBy: map[string]memory.Aggregate{},
+		},
+
+		"Not enabling request size measuring, it shouldn't measure the request size.": {
+			config: middleware.Config{Service: "svc1"},
+			reporter: func(mrep *mockmiddleware.Reporter) middleware.Reporter {
+				return requestSizeReporter{Reporter: mrep, bytesRead: 1024}
+			},
+			expReqSizeBy: map[string]memory.Aggregate{},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("PATCH")
+			mrep.On("BytesWritten").Return(int64(42))
+			mrep.On("URLPath").Return("/test/01")
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+			mdlw.Measure("test01", test.reporter(mrep), func() {})
+
+			assert.Equal(test.expReqSizeBy, mrec.RequestSizeBy(memory.LabelMethod, memory.Query{Service: "svc1", ID: "test01"}))
+		})
+	}
+}
+
+// bytesReadCounterReporter is a reporter that reports if it has been asked to count the request bytes read.
+type bytesReadCounterReporter struct {
+	*mockmiddleware.Reporter
+	counted bool
+}
+
+func (r *bytesReadCounterReporter) BytesRead() int64 { return 0 }
+
+func (r *bytesReadCounterReporter) CountBytesRead() { r.counted = true }
+
+func TestMiddlewareCountBytesRead(t *testing.T) {
+	tests := map[string]struct {
+		config     middleware.Config
+		expCounted bool
+	}{
+		"Measuring the request size, it should count the request bytes read.": {
+			config:     middleware.Config{MeasureRequestSize: true},
+			expCounted: true,
+		},
+
+		"Not measuring the request size, it shouldn't count the request bytes read.": {
+			config:     middleware.Config{},
+			expCounted: false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("PATCH")
+			mrep.On("BytesWritten").Return(int64(42))
+			mrep.On("URLPath").Return("/test/01")
+
+			test.config.Recorder = memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(test.config)
+			rep := &bytesReadCounterReporter{Reporter: mrep}
+			counted := false
+			mdlw.Measure("test01", rep, func() { counted = rep.counted })
+
+			// The bytes are counted before calling the handler.
+			assert.Equal(test.expCounted, counted)
+		})
+	}
+}
+
+// firstByteReporter is a reporter that reports the time of the first byte written.
+type firstByteReporter struct {
+	*mockmiddleware.Reporter
+	firstByteTime *time.Time
+}
+
+func (r firstByteReporter) FirstByteTime() time.Time { return *r.firstByteTime }
+
+func TestMiddlewareMeasureTimeToFirstByte(t *testing.T) {
+	tests := map[string]struct {
+		config         middleware.Config
+		supported      bool
+		writeFirstByte bool
+		expMeasured    bool
+		expTTFBIsTotal bool
+	}{
+		"Having a reporter that reports the first byte, it should measure the time to first byte.": {
+			config:         middleware.Config{Service: "svc1", MeasureTimeToFirstByte: true},
+			supported:      true,
+			writeFirstByte: true,
+			expMeasured:    true,
+		},
+
+		"Having a reporter that hasn't written the first byte, it should measure the request duration as the time to first byte.": {
+			config:         middleware.Config{Service: "svc1", MeasureTimeToFirstByte: true},
+			supported:      true,
+			expMeasured:    true,
+			expTTFBIsTotal: true,
+		},
+
+		"Having a reporter that doesn't report the first byte, it shouldn't measure the time to first byte.": {
+			config:    middleware.Config{Service: "svc1", MeasureTimeToFirstByte: true},
+			supported: false,
+		},
+
+		"Not enabling the time to first byte measuring, it shouldn't measure the time to first byte.": {
+			config:         middleware.Config{Service: "svc1"},
+			supported:      true,
+			writeFirstByte: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(42))
+			mrep.On("URLPath").Return("/test/01")
+
+			var firstByte time.Time
+			var rep middleware.Reporter = mrep
+			if test.supported {
+				rep = firstByteReporter{Reporter: mrep, firstByteTime: &firstByte}
+			}
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+			mdlw.Measure("test01", rep, func() {
+				if test.writeFirstByte {
+					firstByte = time.Now()
+				}
+				time.Sleep(20 * time.Millisecond)
+			})
+
+			q := memory.Query{Service: "svc1", ID: "test01"}
+			ttfb := mrec.TimeToFirstByte(q)
+			if !test.expMeasured {
+				assert.Equal(memory.Aggregate{}, ttfb)
+				return
+			}
+
+			assert.Equal(1, ttfb.Count)
+			if test.expTTFBIsTotal {
+				assert.Equal(mrec.RequestDuration(q), ttfb)
+			} else {
+				assert.Less(ttfb.Sum, mrec.RequestDuration(q).Sum)
+			}
+		})
+	}
+}
+
+// panicReporter is a reporter that stores the recovered panic responses.
+type panicReporter struct {
+	*mockmiddleware.Reporter
+	statusCode int
+	body       string
+}
+
+func (r *panicReporter) RespondPanic(statusCode int, body []byte) {
+	r.statusCode = statusCode
+	r.body = string(body)
+}
+
+func TestMiddlewareMeasurePanics(t *testing.T) {
+	tests := map[string]struct {
+		config        middleware.Config
+		panicValue    any
+		expPanic      bool
+		expStatusCode int
+		expBody       string
+	}{
+		"A panicking handler should be measured as a 500 and panic again.": {
+			config:     middleware.Config{Service: "svc1"},
+			panicValue: "boom",
+			expPanic:   true,
+		},
+
+		"A panicking handler with panic recovery should be measured as a 500 and respond with the panic response.": {
+			config:        middleware.Config{Service: "svc1", RecoverPanics: true},
+			panicValue:    "boom",
+			expStatusCode: 500,
+			expBody:       "Internal Server Error",
+		},
+
+		"A panicking handler with panic recovery and custom body should respond with the custom body.": {
+			config:        middleware.Config{Service: "svc1", RecoverPanics: true, PanicResponseBody: "oops"},
+			panicValue:    "boom",
+			expStatusCode: 500,
+			expBody:       "oops",
+		},
+
+		"A handler aborted with ErrAbortHandler should panic again even with panic recovery.": {
+			config:     middleware.Config{Service: "svc1", RecoverPanics: true},
+			panicValue: http.ErrAbortHandler,
+			expPanic:   true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(0))
+			mrep.On("URLPath").Return("/test/01")
+			rep := &panicReporter{Reporter: mrep}
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+			measure := func() {
+				mdlw.Measure("test01", rep, func() { panic(test.panicValue) })
+			}
+
+			if test.expPanic {
+				assert.PanicsWithValue(test.panicValue, measure)
+			} else {
+				assert.NotPanics(measure)
+			}
+
+			assert.Equal(1, mrec.RequestCount(memory.Query{Service: "svc1", ID: "test01", Code: "500"}))
+			assert.Equal(1, mrec.Panics(metrics.HTTPProperties{Service: "svc1", ID: "test01"}))
+			assert.Equal(0, mrec.InflightRequests(metrics.HTTPProperties{Service: "svc1", ID: "test01"}))
+			assert.Equal(test.expStatusCode, rep.statusCode)
+			assert.Equal(test.expBody, rep.body)
+		})
+	}
+}
+
+func TestMiddlewareMeasureCanceled(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOutCtx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+
+	tests := map[string]struct {
+		config       middleware.Config
+		ctx          context.Context
+		expCode      string
+		expAbortions int
+	}{
+		"A canceled request should be counted as a client abort and measured with the response status code.": {
+			config:       middleware.Config{Service: "svc1"},
+			ctx:          canceledCtx,
+			expCode:      "200",
+			expAbortions: 1,
+		},
+
+		"A canceled request with a canceled status code should be measured with the canceled status code.": {
+			config:       middleware.Config{Service: "svc1", CanceledStatusCode: 499},
+			ctx:          canceledCtx,
+			expCode:      "499",
+			expAbortions: 1,
+		},
+
+		"A timed out request shouldn't be counted as a client abort.": {
+			config:       middleware.Config{Service: "svc1", CanceledStatusCode: 499},
+			ctx:          timedOutCtx,
+			expCode:      "200",
+			expAbortions: 0,
+		},
+
+		"A regular request shouldn't be counted as a client abort.": {
+			config:       middleware.Config{Service: "svc1", CanceledStatusCode: 499},
+			ctx:          context.Background(),
+			expCode:      "200",
+			expAbortions: 0,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(test.ctx)
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(0))
+			mrep.On("URLPath").Return("/test/01")
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+			mdlw.Measure("test01", mrep, func() {})
+
+			assert.Equal(1, mrec.RequestCount(memory.Query{Service: "svc1", ID: "test01", Code: test.expCode}))
+			assert.Equal(test.expAbortions, mrec.ClientAborts(metrics.HTTPProperties{Service: "svc1", ID: "test01"}))
+		})
+	}
+}
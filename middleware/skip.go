@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// SkipRequest is the request information a Skipper uses to decide if a request is skipped.
+type SkipRequest struct {
+	// Method is the method of the request.
+	Method string
+	// Path is the URL path of the request.
+	Path string
+	// HandlerID is the handler ID of the request, empty if the middleware doesn't have one.
+	HandlerID string
+
+	reporter Reporter
+}
+
+// Header returns the value of a request header, the Reporter needs to implement
+// `HeaderReporter`, if not, it will return an empty value.
+func (s SkipRequest) Header(name string) string {
+	hr, ok := s.reporter.(HeaderReporter)
+	if !ok {
+		return ""
+	}
+
+	return hr.RequestHeader(name)
+}
+
+// Skipper knows if a request should be skipped from being measured.
+type Skipper func(req SkipRequest) bool
+
+// SkipAny returns a Skipper that skips the requests skipped by any of the skippers.
+func SkipAny(skippers ...Skipper) Skipper {
+	return func(req SkipRequest) bool {
+		for _, skip := range skippers {
+			if skip(req) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipMethods returns a Skipper that skips the requests with any of the methods (e.g `OPTIONS`).
+func SkipMethods(methods ...string) Skipper {
+	ms := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		ms[strings.ToUpper(m)] = struct{}{}
+	}
+
+	return func(req SkipRequest) bool {
+		_, ok := ms[req.Method]
+		return ok
+	}
+}
+
+// SkipPathPrefixes returns a Skipper that skips the requests whose path starts with
+// any of the prefixes (e.g `/internal/`).
+func SkipPathPrefixes(prefixes ...string) Skipper {
+	// Instead of checking every prefix, we check the path prefixes of the
+	// lengths we know, there are a lot less lengths than prefixes.
+	ps := make(map[string]struct{}, len(prefixes))
+	lengthSet := map[int]struct{}{}
+	for _, p := range prefixes {
+		ps[p] = struct{}{}
+		lengthSet[len(p)] = struct{}{}
+	}
+	lengths := make([]int, 0, len(lengthSet))
+	for l := range lengthSet {
+		lengths = append(lengths, l)
+	}
+	sort.Ints(lengths)
+
+	return func(req SkipRequest) bool {
+		for _, l := range lengths {
+			if l > len(req.Path) {
+				return false
+			}
+			if _, ok := ps[req.Path[:l]]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipPathGlobs returns a Skipper that skips the requests whose path matches any of the glob
+// patterns. `*` matches any sequence of characters in a path segment, `**` matches any sequence
+// of characters including the segment separators (`/`), and `?` matches a single character
+// in a path segment (e.g `/internal/**`, `/api/*/health`).
+func SkipPathGlobs(patterns ...string) (Skipper, error) {
+	exprs := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		exprs = append(exprs, "^"+globToRegexp(p)+"$")
+	}
+
+	return SkipPathRegexps(exprs...)
+}
+
+// SkipPathRegexps returns a Skipper that skips the requests whose path matches any of the
+// regular expressions. The expressions anchored to the start of the path (`^`) are indexed
+// by their literal prefix, so hundreds of them don't impact on the matching performance.
+func SkipPathRegexps(exprs ...string) (Skipper, error) {
+	m, err := newPathMatcher(exprs)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(req SkipRequest) bool {
+		return m.match(req.Path)
+	}, nil
+}
+
+// pathMatcher matches paths against multiple regular expressions. Only the expressions
+// whose literal prefix is a prefix of the path are checked.
+type pathMatcher struct {
+	prefixLengths []int
+	indexed       map[string][]*regexp.Regexp
+	unindexed     []*regexp.Regexp
+}
+
+func newPathMatcher(exprs []string) (*pathMatcher, error) {
+	m := &pathMatcher{indexed: map[string][]*regexp.Regexp{}}
+	lengthSet := map[int]struct{}{}
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q path expression: %w", expr, err)
+		}
+
+		anchored, err := anchoredAtStart(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q path expression: %w", expr, err)
+		}
+
+		prefix, _ := re.LiteralPrefix()
+		if !anchored {
+			m.unindexed = append(m.unindexed, re)
+			continue
+		}
+		m.indexed[prefix] = append(m.indexed[prefix], re)
+		lengthSet[len(prefix)] = struct{}{}
+	}
+
+	for l := range lengthSet {
+		m.prefixLengths = append(m.prefixLengths, l)
+	}
+	sort.Ints(m.prefixLengths)
+
+	return m, nil
+}
+
+func (m *pathMatcher) match(path string) bool {
+	for _, l := range m.prefixLengths {
+		if l > len(path) {
+			break
+		}
+		for _, re := range m.indexed[path[:l]] {
+			if re.MatchString(path) {
+				return true
+			}
+		}
+	}
+
+	for _, re := range m.unindexed {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// anchoredAtStart returns if the expression only matches at the start of the text.
+func anchoredAtStart(expr string) (bool, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return false, err
+	}
+	re = re.Simplify()
+
+	if re.Op == syntax.OpConcat && len(re.Sub) > 0 {
+		re = re.Sub[0]
+	}
+
+	return re.Op == syntax.OpBeginText, nil
+}
+
+// globToRegexp converts a path glob pattern into a regular expression.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return b.String()
+}
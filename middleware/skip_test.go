@@ -0,0 +1,201 @@
+package middleware_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mockmiddleware "github.com/slok/go-http-metrics/internal/mocks/middleware"
+	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+)
+
+func mustSkipper(s middleware.Skipper, err error) middleware.Skipper {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestSkippers(t *testing.T) {
+	tests := map[string]struct {
+		skipper middleware.Skipper
+		exp     map[string]bool
+	}{
+		"Skipping by path prefixes should skip the paths with any of the prefixes.": {
+			skipper: middleware.SkipPathPrefixes("/internal/", "/debug"),
+			exp: map[string]bool{
+				"/internal/status": true,
+				"/internal":        false,
+				"/debug/pprof":     true,
+				"/debugger":        true,
+				"/api/debug":       false,
+				"/":                false,
+			},
+		},
+
+		"Skipping by path globs should skip the paths that match any of the globs.": {
+			skipper: mustSkipper(middleware.SkipPathGlobs("/internal/**", "/api/*/health", "/v?/status")),
+			exp: map[string]bool{
+				"/internal/a/b/c":      true,
+				"/internal":            false,
+				"/api/users/health":    true,
+				"/api/users/a/health":  false,
+				"/v1/status":           true,
+				"/v10/status":          false,
+				"/api/users/health/ok": false,
+			},
+		},
+
+		"Skipping by path regexps should skip the paths that match any of the expressions.": {
+			skipper: mustSkipper(middleware.SkipPathRegexps(`^/users/\d+$`, `\.(css|js)$`)),
+			exp: map[string]bool{
+				"/users/42":       true,
+				"/users/batman":   false,
+				"/static/app.js":  true,
+				"/static/app.css": true,
+				"/static/app.png": false,
+			},
+		},
+
+		"Skipping by path regexps not anchored to the start should match in any part of the path.": {
+			skipper: mustSkipper(middleware.SkipPathRegexps(`/debug/`, `^/metrics$`)),
+			exp: map[string]bool{
+				"/debug/pprof":     true,
+				"/v1/debug/vars":   true,
+				"/metrics":         true,
+				"/v1/metrics":      false,
+				"/metrics/details": false,
+			},
+		},
+
+		"Skipping without expressions should not skip anything.": {
+			skipper: mustSkipper(middleware.SkipPathRegexps()),
+			exp: map[string]bool{
+				"/":      false,
+				"/users": false,
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for path, exp := range test.exp {
+				assert.Equal(t, exp, test.skipper(middleware.SkipRequest{Method: "GET", Path: path}), path)
+			}
+		})
+	}
+}
+
+func TestSkipperMethodsAndAny(t *testing.T) {
+	assert := assert.New(t)
+
+	skipper := middleware.SkipAny(
+		middleware.SkipMethods("options", "HEAD"),
+		func(r middleware.SkipRequest) bool { return r.HandlerID == "healthcheck" },
+	)
+
+	assert.True(skipper(middleware.SkipRequest{Method: "OPTIONS", Path: "/users"}))
+	assert.True(skipper(middleware.SkipRequest{Method: "HEAD", Path: "/users"}))
+	assert.True(skipper(middleware.SkipRequest{Method: "GET", Path: "/health", HandlerID: "healthcheck"}))
+	assert.False(skipper(middleware.SkipRequest{Method: "GET", Path: "/users"}))
+}
+
+func TestSkipperInvalidRegexp(t *testing.T) {
+	_, err := middleware.SkipPathRegexps(`^/users/(\d+$`)
+	assert.Error(t, err)
+}
+
+func TestMiddlewareSkipper(t *testing.T) {
+	tests := map[string]struct {
+		config      middleware.Config
+		path        string
+		headers     map[string]string
+		expMeasured bool
+		expInflight bool
+	}{
+		"A request not skipped should be measured.": {
+			config:      middleware.Config{Skipper: middleware.SkipPathPrefixes("/internal/")},
+			path:        "/api/users",
+			expMeasured: true,
+			expInflight: true,
+		},
+
+		"A skipped request should not be measured but counted as inflight.": {
+			config:      middleware.Config{Skipper: middleware.SkipPathPrefixes("/internal/")},
+			path:        "/internal/status",
+			expMeasured: false,
+			expInflight: true,
+		},
+
+		"A skipped request with skip inflight should not be measured nor counted as inflight.": {
+			config:      middleware.Config{Skipper: middleware.SkipPathPrefixes("/internal/"), SkipInflight: true},
+			path:        "/internal/status",
+			expMeasured: false,
+			expInflight: false,
+		},
+
+		"A request skipped by header should not be measured.": {
+			config: middleware.Config{Skipper: func(r middleware.SkipRequest) bool {
+				return r.Header("User-Agent") == "kube-probe"
+			}},
+			path:        "/api/users",
+			headers:     map[string]string{"User-Agent": "kube-probe"},
+			expMeasured: false,
+			expInflight: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(0))
+			mrep.On("URLPath").Return(test.path)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+
+			inflight := 0
+			mdlw.Measure("test01", headerReporter{Reporter: mrep, headers: test.headers}, func() {
+				inflight = mrec.InflightRequests(metrics.HTTPProperties{ID: "test01"})
+			})
+
+			expCount := 0
+			if test.expMeasured {
+				expCount = 1
+			}
+			expInflight := 0
+			if test.expInflight {
+				expInflight = 1
+			}
+			require.Equal(expInflight, inflight)
+			assert.Equal(expCount, mrec.RequestCount(memory.Query{ID: "test01"}))
+		})
+	}
+}
+
+func BenchmarkSkipPathGlobs(b *testing.B) {
+	patterns := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		patterns = append(patterns, fmt.Sprintf("/internal/%d/**", i))
+	}
+	skipper := mustSkipper(middleware.SkipPathGlobs(patterns...))
+	req := middleware.SkipRequest{Method: "GET", Path: "/api/v1/users/42/profile"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		skipper(req)
+	}
+}
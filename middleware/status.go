@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// StatusCodeFormatter formats the status code of a response as the status code of the
+// metrics (e.g `200`, `2xx`, `other`...).
+//
+// The formatters are precomputed for the standard range of status codes (100-599) when
+// the middleware is created, so they need to return always the same value for a status
+// code.
+type StatusCodeFormatter func(statusCode int) string
+
+// statusCodeOther is the formatted status code for the non standard status codes.
+const statusCodeOther = "other"
+
+// StatusCodeExact returns a StatusCodeFormatter that formats the status codes as they
+// are (e.g `200`, `201`, `404`).
+func StatusCodeExact() StatusCodeFormatter {
+	return strconv.Itoa
+}
+
+// StatusCodeClass returns a StatusCodeFormatter that formats the status codes by their
+// class in the form of `\dxx` (e.g `200` and `201` as `2xx`, `404` as `4xx`).
+func StatusCodeClass() StatusCodeFormatter {
+	return func(statusCode int) string {
+		return strconv.Itoa(statusCode/100) + "xx"
+	}
+}
+
+// StatusCodeClassExcept returns a StatusCodeFormatter that formats the status codes by their
+// class (like `StatusCodeClass`) except the status codes of the list that are formatted as they
+// are (e.g keeping `401`, `404` and `429` detail while grouping the rest as `2xx`, `4xx`...).
+func StatusCodeClassExcept(statusCodes ...int) StatusCodeFormatter {
+	except := make(map[int]struct{}, len(statusCodes))
+	for _, c := range statusCodes {
+		except[c] = struct{}{}
+	}
+
+	class := StatusCodeClass()
+	return func(statusCode int) string {
+		if _, ok := except[statusCode]; ok {
+			return strconv.Itoa(statusCode)
+		}
+		return class(statusCode)
+	}
+}
+
+// StatusCodeMapping returns a StatusCodeFormatter that formats the status codes using an explicit
+// mapping table (e.g `{499: "client_closed"}`), the status codes missing in the table are formatted
+// with the fallback formatter, if nil, they are formatted as they are.
+func StatusCodeMapping(mapping map[int]string, fallback StatusCodeFormatter) StatusCodeFormatter {
+	if fallback == nil {
+		fallback = StatusCodeExact()
+	}
+
+	m := make(map[int]string, len(mapping))
+	for k, v := range mapping {
+		m[k] = v
+	}
+
+	return func(statusCode int) string {
+		if v, ok := m[statusCode]; ok {
+			return v
+		}
+		return fallback(statusCode)
+	}
+}
+
+// StatusCodeStandard returns a StatusCodeFormatter that formats the non standard status codes
+// (the ones without `http.StatusText`) as `other`, and the standard ones with the formatter.
+func StatusCodeStandard(formatter StatusCodeFormatter) StatusCodeFormatter {
+	return func(statusCode int) string {
+		if http.StatusText(statusCode) == "" {
+			return statusCodeOther
+		}
+		return formatter(statusCode)
+	}
+}
+
+const (
+	minTableStatusCode = 100
+	maxTableStatusCode = 599
+)
+
+// statusCodeTable is a StatusCodeFormatter with the standard range of status codes
+// precomputed, so formatting the status codes on each request is a lookup.
+type statusCodeTable struct {
+	formatted []string
+	formatter StatusCodeFormatter
+}
+
+func newStatusCodeTable(formatter StatusCodeFormatter) *statusCodeTable {
+	formatted := make([]string, maxTableStatusCode-minTableStatusCode+1)
+	for i := range formatted {
+		formatted[i] = formatter(i + minTableStatusCode)
+	}
+
+	return &statusCodeTable{
+		formatted: formatted,
+		formatter: formatter,
+	}
+}
+
+func (s *statusCodeTable) format(statusCode int) string {
+	if statusCode < minTableStatusCode || statusCode > maxTableStatusCode {
+		return s.formatter(statusCode)
+	}
+
+	return s.formatted[statusCode-minTableStatusCode]
+}
@@ -0,0 +1,112 @@
+package middleware_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mockmiddleware "github.com/slok/go-http-metrics/internal/mocks/middleware"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+)
+
+func TestStatusCodeFormatters(t *testing.T) {
+	tests := map[string]struct {
+		formatter middleware.StatusCodeFormatter
+		exp       map[int]string
+	}{
+		"Exact formatter should format the status codes as they are.": {
+			formatter: middleware.StatusCodeExact(),
+			exp:       map[int]string{200: "200", 201: "201", 404: "404", 599: "599"},
+		},
+
+		"Class formatter should format the status codes by class.": {
+			formatter: middleware.StatusCodeClass(),
+			exp:       map[int]string{200: "2xx", 201: "2xx", 302: "3xx", 404: "4xx", 503: "5xx"},
+		},
+
+		"Class except formatter should format the status codes by class except the listed ones.": {
+			formatter: middleware.StatusCodeClassExcept(401, 404, 429),
+			exp:       map[int]string{200: "2xx", 302: "3xx", 400: "4xx", 401: "401", 404: "404", 429: "429", 503: "5xx"},
+		},
+
+		"Mapping formatter should format the status codes with the mapping and the missing ones as they are.": {
+			formatter: middleware.StatusCodeMapping(map[int]string{499: "client_closed", 418: "4xx"}, nil),
+			exp:       map[int]string{200: "200", 418: "4xx", 499: "client_closed"},
+		},
+
+		"Mapping formatter should format the missing status codes with the fallback.": {
+			formatter: middleware.StatusCodeMapping(map[int]string{499: "client_closed"}, middleware.StatusCodeClass()),
+			exp:       map[int]string{200: "2xx", 404: "4xx", 499: "client_closed"},
+		},
+
+		"Standard formatter should format the non standard status codes as other.": {
+			formatter: middleware.StatusCodeStandard(middleware.StatusCodeClassExcept(404)),
+			exp:       map[int]string{200: "2xx", 404: "404", 299: "other", 499: "other", 999: "other"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for code, exp := range test.exp {
+				assert.Equal(t, exp, test.formatter(code), code)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStatusCodeFormatter(t *testing.T) {
+	tests := map[string]struct {
+		config     middleware.Config
+		statusCode int
+		expCode    string
+	}{
+		"By default the status codes should be measured as they are.": {
+			statusCode: 404,
+			expCode:    "404",
+		},
+
+		"Grouped status should measure the status codes by class.": {
+			config:     middleware.Config{GroupedStatus: true},
+			statusCode: 404,
+			expCode:    "4xx",
+		},
+
+		"A status code formatter should be used to measure the status codes.": {
+			config:     middleware.Config{StatusCodeFormatter: middleware.StatusCodeClassExcept(404)},
+			statusCode: 404,
+			expCode:    "404",
+		},
+
+		"A status code formatter should have priority over grouped status.": {
+			config:     middleware.Config{GroupedStatus: true, StatusCodeFormatter: middleware.StatusCodeClassExcept(404)},
+			statusCode: 404,
+			expCode:    "404",
+		},
+
+		"A status code out of the standard range should be formatted.": {
+			config:     middleware.Config{StatusCodeFormatter: middleware.StatusCodeStandard(middleware.StatusCodeExact())},
+			statusCode: 999,
+			expCode:    "other",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(test.statusCode)
+			mrep.On("Method").Return("GET")
+			mrep.On("BytesWritten").Return(int64(0))
+			mrep.On("URLPath").Return("/test")
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+			mdlw.Measure("test", mrep, func() {})
+
+			assert.Equal(t, 1, mrec.RequestCount(memory.Query{ID: "test", Code: test.expCode}))
+		})
+	}
+}
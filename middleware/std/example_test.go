@@ -40,3 +40,28 @@ func Example_stdMiddleware() {
 		log.Panicf("error while serving: %s", err)
 	}
 }
+
+// Example_stdPatternMiddleware shows how you would measure a `http.ServeMux` using the
+// matched route patterns as the handler IDs.
+func Example_stdPatternMiddleware() {
+	// Create our middleware factory with the default settings.
+	mdlw := middleware.New(middleware.Config{
+		Recorder: metrics.NewRecorder(metrics.Config{}),
+	})
+
+	// Create our router.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("item " + r.PathValue("id")))
+	})
+
+	// Wrap our router with the middleware, the requests will be measured with
+	// the `/items/{id}` handler ID, and the not found ones with `not-found`.
+	h := stdmiddleware.PatternHandler("not-found", mdlw, mux)
+
+	// Serve our handler.
+	log.Printf("listening at: %s", ":8080")
+	if err := http.ListenAndServe(":8080", h); err != nil {
+		log.Panicf("error while serving: %s", err)
+	}
+}
@@ -5,14 +5,75 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/slok/go-http-metrics/middleware"
+	"github.com/slok/go-http-metrics/middleware/internal/bodycounter"
+	"github.com/slok/go-http-metrics/middleware/internal/protocol"
 )
 
 // Handler returns an measuring standard http.Handler.
 func Handler(handlerID string, m middleware.Middleware, h http.Handler) http.Handler {
+	return handler(handlerID, m, h, nil)
+}
+
+// HandlerProvider is a helper method that returns a handler provider. This kind of
+// provider is a defacto standard in some frameworks (e.g: Gorilla, Chi...).
+func HandlerProvider(handlerID string, m middleware.Middleware) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return Handler(handlerID, m, next)
+	}
+}
+
+// unmatchedHandlerID is the default handler ID of the requests not matched by a pattern.
+const unmatchedHandlerID = "unmatched"
+
+// PatternHandler returns a measuring standard http.Handler that uses the `http.ServeMux` pattern
+// that matched the request (`http.Request.Pattern`) as the handler ID, instead of the URL path.
+// The method of the method qualified patterns is removed (e.g `GET /items/{id}` is measured
+// as `/items/{id}`). h is expected to be an `http.ServeMux` (or to route with one).
+//
+// The handler ID is resolved after the handler finishes, so the requests not matched by a
+// pattern (e.g not found) are measured with the fallbackID, by default `unmatched`. The inflight
+// requests metric is measured before routing the request, so all the inflight requests use
+// the fallbackID. The handler ID set by the handlers with `middleware.SetHandlerID` is kept.
+func PatternHandler(fallbackID string, m middleware.Middleware, h http.Handler) http.Handler {
+	if fallbackID == "" {
+		fallbackID = unmatchedHandlerID
+	}
+
+	return handler(fallbackID, m, h, func(r *http.Request) {
+		if id := patternHandlerID(r.Pattern); id != "" {
+			middleware.SetDefaultHandlerID(r.Context(), id)
+		}
+	})
+}
+
+// PatternHandlerProvider is a helper method that returns a handler provider
+// that uses `PatternHandler`.
+func PatternHandlerProvider(fallbackID string, m middleware.Middleware) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return PatternHandler(fallbackID, m, next)
+	}
+}
+
+// patternHandlerID returns the handler ID of a `http.ServeMux` pattern, the patterns
+// have the form of `[METHOD ][HOST]/[PATH]`.
+func patternHandlerID(pattern string) string {
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " \t")
+	}
+
+	return pattern
+}
+
+// handler returns the measuring handler, resolveID is called after the handler with the
+// request the handler received, so the handler ID can be set once the request is routed.
+func handler(handlerID string, m middleware.Middleware, h http.Handler, resolveID func(r *http.Request)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		wi := &responseWriterInterceptor{
 			statusCode:     http.StatusOK,
@@ -24,22 +85,20 @@ func Handler(handlerID string, m middleware.Middleware, h http.Handler) http.Han
 		}
 
 		m.Measure(handlerID, reporter, func() {
-			h.ServeHTTP(wi, r)
+			// The reporter can update the request context.
+			req := reporter.r
+			if resolveID != nil {
+				defer resolveID(req)
+			}
+			h.ServeHTTP(wrapResponseWriter(wi), req)
 		})
 	})
 }
 
-// HandlerProvider is a helper method that returns a handler provider. This kind of
-// provider is a defacto standard in some frameworks (e.g: Gorilla, Chi...).
-func HandlerProvider(handlerID string, m middleware.Middleware) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return Handler(handlerID, m, next)
-	}
-}
-
 type stdReporter struct {
-	w *responseWriterInterceptor
-	r *http.Request
+	w    *responseWriterInterceptor
+	r    *http.Request
+	body *bodycounter.Body
 }
 
 func (s *stdReporter) Method() string { return s.r.Method }
@@ -52,22 +111,74 @@ func (s *stdReporter) StatusCode() int { return s.w.statusCode }
 
 func (s *stdReporter) BytesWritten() int64 { return int64(s.w.bytesWritten) }
 
+func (s *stdReporter) BytesRead() int64 { return s.body.BytesRead() }
+
+func (s *stdReporter) CountBytesRead() { s.body = bodycounter.Wrap(s.r) }
+
+func (s *stdReporter) RequestHeader(name string) string { return s.r.Header.Get(name) }
+
+func (s *stdReporter) Proto() string { return protocol.Proto(s.r) }
+
+func (s *stdReporter) Scheme() string { return protocol.Scheme(s.r) }
+
+func (s *stdReporter) SetContextValue(key, value any) {
+	s.r = s.r.WithContext(context.WithValue(s.r.Context(), key, value))
+}
+
+func (s *stdReporter) FirstByteTime() time.Time { return s.w.firstByteTime }
+
+func (s *stdReporter) OnFlush(f func()) { s.w.onFlush = f }
+
+func (s *stdReporter) OnHijack(f func(conn net.Conn) net.Conn) { s.w.onHijack = f }
+
+func (s *stdReporter) RespondPanic(statusCode int, body []byte) {
+	if !s.w.firstByteTime.IsZero() {
+		return
+	}
+	s.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	s.w.WriteHeader(statusCode)
+	_, _ = s.w.Write(body)
+}
+
 // responseWriterInterceptor is a simple wrapper to intercept set data on a
 // ResponseWriter.
 type responseWriterInterceptor struct {
 	http.ResponseWriter
-	statusCode   int
-	bytesWritten int
+	statusCode    int
+	bytesWritten  int
+	firstByteTime time.Time
+	onFlush       func()
+	onHijack      func(conn net.Conn) net.Conn
 }
 
 func (w *responseWriterInterceptor) WriteHeader(statusCode int) {
-	w.statusCode = statusCode
+	// Like net/http, the informational responses (e.g 103 Early Hints) are not the final
+	// response, and only the first final status code is used.
+	if !isInformational(statusCode) && w.firstByteTime.IsZero() {
+		w.markFirstByte()
+		w.statusCode = statusCode
+	}
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (w *responseWriterInterceptor) Write(p []byte) (int, error) {
-	w.bytesWritten += len(p)
-	return w.ResponseWriter.Write(p)
+	w.markFirstByte()
+	n, err := w.ResponseWriter.Write(p)
+	w.bytesWritten += n
+	return n, err
+}
+
+// markFirstByte sets the time of the first byte written on the response, if not set already.
+// The status code of the response can't change once set.
+func (w *responseWriterInterceptor) markFirstByte() {
+	if w.firstByteTime.IsZero() {
+		w.firstByteTime = time.Now()
+	}
+}
+
+// Unwrap returns the wrapped ResponseWriter, used by `http.ResponseController`.
+func (w *responseWriterInterceptor) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func (w *responseWriterInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -75,7 +186,32 @@ func (w *responseWriterInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error
 	if !ok {
 		return nil, nil, errors.New("type assertion failed http.ResponseWriter not a http.Hijacker")
 	}
-	return h.Hijack()
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return conn, brw, err
+	}
+
+	// The hijacked requests without a response are measured as switching protocols, the
+	// handler writes the response (if any) on the connection.
+	if w.firstByteTime.IsZero() {
+		w.markFirstByte()
+		w.statusCode = http.StatusSwitchingProtocols
+	}
+
+	if w.onHijack == nil {
+		return conn, brw, nil
+	}
+
+	// The buffered reader and writer use the measured connection, unless they have
+	// buffered data that would be lost.
+	conn = w.onHijack(conn)
+	if brw.Reader.Buffered() == 0 {
+		brw.Reader.Reset(conn)
+	}
+	if brw.Writer.Buffered() == 0 {
+		brw.Writer.Reset(conn)
+	}
+	return conn, brw, nil
 }
 
 func (w *responseWriterInterceptor) Flush() {
@@ -84,7 +220,173 @@ func (w *responseWriterInterceptor) Flush() {
 		return
 	}
 
+	// Flushing writes the headers if they haven't been written.
+	w.markFirstByte()
 	f.Flush()
+	if w.onFlush != nil {
+		w.onFlush()
+	}
+}
+
+func (w *responseWriterInterceptor) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{w}, src)
+	}
+
+	w.markFirstByte()
+	n, err := rf.ReadFrom(src)
+	w.bytesWritten += int(n)
+	return n, err
+}
+
+func (w *responseWriterInterceptor) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// isInformational returns if the status code is an informational (1xx) response, except
+// 101 Switching Protocols that is a final response.
+func isInformational(statusCode int) bool {
+	return statusCode >= 100 && statusCode <= 199 && statusCode != http.StatusSwitchingProtocols
+}
+
+// writerOnly hides the optional interfaces of a writer, so `io.Copy` doesn't call
+// `ReadFrom` recursively.
+type writerOnly struct{ io.Writer }
+
+// unwrapResponseWriter is the interceptor without the optional interfaces.
+type unwrapResponseWriter interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// Optional interfaces of the ResponseWriters.
+const (
+	flusherIface = 1 << iota
+	hijackerIface
+	readerFromIface
+	pusherIface
+)
+
+// wrapResponseWriter returns the interceptor implementing the same optional interfaces
+// (`http.Flusher`, `http.Hijacker`, `io.ReaderFrom` and `http.Pusher`) as the wrapped
+// ResponseWriter, so the handlers can detect the capabilities of the original ResponseWriter.
+func wrapResponseWriter(w *responseWriterInterceptor) http.ResponseWriter {
+	ifaces := 0
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		ifaces |= flusherIface
+	}
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		ifaces |= hijackerIface
+	}
+	if _, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		ifaces |= readerFromIface
+	}
+	if _, ok := w.ResponseWriter.(http.Pusher); ok {
+		ifaces |= pusherIface
+	}
+
+	var u unwrapResponseWriter = w
+	switch ifaces {
+	case flusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+		}{u, w}
+	case hijackerIface:
+		return struct {
+			unwrapResponseWriter
+			http.Hijacker
+		}{u, w}
+	case readerFromIface:
+		return struct {
+			unwrapResponseWriter
+			io.ReaderFrom
+		}{u, w}
+	case pusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Pusher
+		}{u, w}
+	case flusherIface | hijackerIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{u, w, w}
+	case flusherIface | readerFromIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{u, w, w}
+	case flusherIface | pusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+			http.Pusher
+		}{u, w, w}
+	case hijackerIface | readerFromIface:
+		return struct {
+			unwrapResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{u, w, w}
+	case hijackerIface | pusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{u, w, w}
+	case readerFromIface | pusherIface:
+		return struct {
+			unwrapResponseWriter
+			io.ReaderFrom
+			http.Pusher
+		}{u, w, w}
+	case flusherIface | hijackerIface | readerFromIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{u, w, w, w}
+	case flusherIface | hijackerIface | pusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{u, w, w, w}
+	case flusherIface | readerFromIface | pusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{u, w, w, w}
+	case hijackerIface | readerFromIface | pusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{u, w, w, w}
+	case flusherIface | hijackerIface | readerFromIface | pusherIface:
+		return struct {
+			unwrapResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{u, w, w, w, w}
+	default:
+		return struct{ unwrapResponseWriter }{u}
+	}
 }
 
 // Check interface implementations.
@@ -92,4 +394,6 @@ var (
 	_ http.ResponseWriter = &responseWriterInterceptor{}
 	_ http.Hijacker       = &responseWriterInterceptor{}
 	_ http.Flusher        = &responseWriterInterceptor{}
+	_ io.ReaderFrom       = &responseWriterInterceptor{}
+	_ http.Pusher         = &responseWriterInterceptor{}
 )
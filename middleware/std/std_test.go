@@ -1,17 +1,24 @@
 package std_test
 
 import (
+	"bufio"
+	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	mmetrics "github.com/slok/go-http-metrics/internal/mocks/metrics"
 	"github.com/slok/go-http-metrics/metrics"
+	"github.com/slok/go-http-metrics/metrics/memory"
 	"github.com/slok/go-http-metrics/middleware"
 	stdmiddleware "github.com/slok/go-http-metrics/middleware/std"
 )
@@ -156,3 +163,766 @@ func TestProvider(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareRequestSize(t *testing.T) {
+	tests := map[string]struct {
+		req        func() *http.Request
+		handler    http.HandlerFunc
+		expReqSize memory.Aggregate
+	}{
+		"Reading the request body should measure the read bytes.": {
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("Я бэтмен"))
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.ReadAll(r.Body)
+			},
+			expReqSize: memory.Aggregate{Count: 1, Sum: 15, Min: 15, Max: 15},
+		},
+
+		"Reading part of the request body should measure only the read bytes.": {
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("0123456789"))
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.ReadFull(r.Body, make([]byte, 4))
+			},
+			expReqSize: memory.Aggregate{Count: 1, Sum: 4, Min: 4, Max: 4},
+		},
+
+		"Not reading the request body should measure the content length.": {
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("0123456789"))
+			},
+			handler:    func(w http.ResponseWriter, r *http.Request) {},
+			expReqSize: memory.Aggregate{Count: 1, Sum: 10, Min: 10, Max: 10},
+		},
+
+		"Requests without body should measure zero bytes.": {
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/test", nil)
+			},
+			handler:    func(w http.ResponseWriter, r *http.Request) {},
+			expReqSize: memory.Aggregate{Count: 1, Sum: 0, Min: 0, Max: 0},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			m := middleware.New(middleware.Config{Recorder: mrec, MeasureRequestSize: true})
+			h := stdmiddleware.Handler("", m, test.handler)
+
+			h.ServeHTTP(httptest.NewRecorder(), test.req())
+
+			assert.Equal(test.expReqSize, mrec.RequestSize(memory.Query{ID: "/test"}))
+		})
+	}
+}
+
+func TestMiddlewareRequestSizeDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	m := middleware.New(middleware.Config{Recorder: mrec})
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("0123456789"))
+	body := req.Body
+	var gotBody io.ReadCloser
+	h := stdmiddleware.Handler("", m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody = r.Body
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Not measuring the request size, the body is not wrapped.
+	assert.Equal(body, gotBody)
+	assert.Equal(memory.Aggregate{}, mrec.RequestSize(memory.Query{ID: "/test"}))
+}
+
+func TestMiddlewareTimeToFirstByte(t *testing.T) {
+	tests := map[string]struct {
+		handler        http.HandlerFunc
+		expTTFBIsTotal bool
+	}{
+		"Writing the headers should measure the time to first byte.": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				time.Sleep(20 * time.Millisecond)
+			},
+		},
+
+		"Writing the body should measure the time to first byte.": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("test"))
+				time.Sleep(20 * time.Millisecond)
+			},
+		},
+
+		"Not writing anything should measure the request duration as the time to first byte.": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(20 * time.Millisecond)
+			},
+			expTTFBIsTotal: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			m := middleware.New(middleware.Config{Recorder: mrec, MeasureTimeToFirstByte: true})
+			h := stdmiddleware.Handler("", m, test.handler)
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			q := memory.Query{ID: "/test"}
+			ttfb := mrec.TimeToFirstByte(q)
+			assert.Equal(1, ttfb.Count)
+			if test.expTTFBIsTotal {
+				assert.Equal(mrec.RequestDuration(q), ttfb)
+			} else {
+				assert.Less(ttfb.Sum, 0.02)
+			}
+		})
+	}
+}
+
+func TestMiddlewarePanics(t *testing.T) {
+	tests := map[string]struct {
+		recoverPanics bool
+		expPanic      bool
+		expCode       int
+		expBody       string
+	}{
+		"A panicking handler should be measured as a 500 and panic again.": {
+			expPanic: true,
+		},
+
+		"A panicking handler with panic recovery should be measured as a 500 and respond with a 500.": {
+			recoverPanics: true,
+			expCode:       http.StatusInternalServerError,
+			expBody:       "Internal Server Error",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			m := middleware.New(middleware.Config{Recorder: mrec, RecoverPanics: test.recoverPanics})
+			h := stdmiddleware.Handler("", m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			}))
+
+			resp := httptest.NewRecorder()
+			serve := func() { h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/test", nil)) }
+			if test.expPanic {
+				assert.Panics(serve)
+			} else {
+				assert.NotPanics(serve)
+				assert.Equal(test.expCode, resp.Code)
+				assert.Equal(test.expBody, resp.Body.String())
+			}
+
+			assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Code: "500"}))
+			assert.Equal(1, mrec.Panics(metrics.HTTPProperties{ID: "/test"}))
+		})
+	}
+}
+
+func TestMiddlewareClientAbort(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	m := middleware.New(middleware.Config{Recorder: mrec, CanceledStatusCode: 499})
+	started := make(chan struct{})
+	h := stdmiddleware.Handler("", m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// Cancel the request once the handler is running.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/test", nil)
+	require.NoError(err)
+	_, err = srv.Client().Do(req)
+	require.Error(err)
+
+	assert.Eventually(func() bool {
+		return mrec.ClientAborts(metrics.HTTPProperties{ID: "/test"}) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test", Code: "499"}))
+}
+
+func TestMiddlewareHeaderLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	m := middleware.New(middleware.Config{
+		Recorder:        mrec,
+		LabelExtractors: []middleware.LabelExtractor{middleware.HeaderLabel("version", "X-Api-Version", "v1", "v2")},
+	})
+	h := stdmiddleware.Handler("", m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-Api-Version", "v2")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	labels := metrics.NewLabels(map[string]string{"version": "v2"})
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/test"}))
+	snapshot := mrec.Snapshot()
+	assert.Contains(snapshot.RequestDurations, metrics.HTTPReqProperties{ID: "/test", Method: http.MethodGet, Code: "200", Labels: labels})
+}
+
+func TestMiddlewareSetHandlerID(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	m := middleware.New(middleware.Config{Recorder: mrec})
+	h := stdmiddleware.Handler("", m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middleware.SetHandlerID(r.Context(), "/users/{id}")
+	}))
+	skipH := stdmiddleware.Handler("", m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middleware.SkipMeasurement(r.Context())
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	skipH.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/users/{id}"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/users/42"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/healthz"}))
+}
+
+func TestPatternHandler(t *testing.T) {
+	tests := map[string]struct {
+		fallbackID string
+		pattern    string
+		req        *http.Request
+		expID      string
+	}{
+		"A request matched by a pattern should use the pattern as the handler ID.": {
+			pattern: "/items/{id}",
+			req:     httptest.NewRequest(http.MethodGet, "/items/42", nil),
+			expID:   "/items/{id}",
+		},
+
+		"A request matched by a method qualified pattern should use the pattern without the method as the handler ID.": {
+			pattern: "GET /items/{id}",
+			req:     httptest.NewRequest(http.MethodGet, "/items/42", nil),
+			expID:   "/items/{id}",
+		},
+
+		"A request matched by a host qualified pattern should use the pattern with the host as the handler ID.": {
+			pattern: "GET example.com/items/{id...}",
+			req:     httptest.NewRequest(http.MethodGet, "http://example.com/items/42/43", nil),
+			expID:   "example.com/items/{id...}",
+		},
+
+		"A request not matched by a pattern should use the default fallback handler ID.": {
+			pattern: "GET /items/{id}",
+			req:     httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expID:   "unmatched",
+		},
+
+		"A request not matched by a pattern should use the fallback handler ID.": {
+			fallbackID: "not-found",
+			pattern:    "GET /items/{id}",
+			req:        httptest.NewRequest(http.MethodGet, "/users/42", nil),
+			expID:      "not-found",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			m := middleware.New(middleware.Config{Recorder: mrec})
+
+			mux := http.NewServeMux()
+			mux.HandleFunc(test.pattern, func(w http.ResponseWriter, r *http.Request) {})
+			h := stdmiddleware.PatternHandler(test.fallbackID, m, mux)
+			h.ServeHTTP(httptest.NewRecorder(), test.req)
+
+			snapshot := mrec.Snapshot()
+			assert.Len(snapshot.RequestDurations, 1)
+			assert.Equal(1, mrec.RequestCount(memory.Query{ID: test.expID}))
+		})
+	}
+}
+
+func TestPatternHandlerSetHandlerID(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	m := middleware.New(middleware.Config{Recorder: mrec})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middleware.SetHandlerID(r.Context(), "get-item")
+	})
+	h := stdmiddleware.PatternHandler("", m, mux)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/42", nil))
+
+	// The handler ID set by the handler should not be overridden by the pattern.
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "get-item"}))
+	assert.Equal(0, mrec.RequestCount(memory.Query{ID: "/items/{id}"}))
+}
+
+func TestPatternHandlerInflight(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	m := middleware.New(middleware.Config{Recorder: mrec})
+
+	inflight := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		inflight["unmatched"] = mrec.InflightRequests(metrics.HTTPProperties{ID: "unmatched"})
+		inflight["/items/{id}"] = mrec.InflightRequests(metrics.HTTPProperties{ID: "/items/{id}"})
+	})
+	h := stdmiddleware.PatternHandler("", m, mux)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/42", nil))
+
+	// The inflight requests are measured before routing.
+	assert.Equal(map[string]int{"unmatched": 1, "/items/{id}": 0}, inflight)
+	assert.Equal(0, mrec.InflightRequests(metrics.HTTPProperties{ID: "unmatched"}))
+	assert.Equal(1, mrec.RequestCount(memory.Query{ID: "/items/{id}"}))
+}
+
+func TestMiddlewareProtocol(t *testing.T) {
+	tests := map[string]struct {
+		config    middleware.Config
+		req       func() *http.Request
+		expProto  string
+		expScheme string
+	}{
+		"Without measuring the protocol, the request should be measured without protocol and scheme.": {
+			req:       func() *http.Request { return httptest.NewRequest(http.MethodGet, "https://example.com/test", nil) },
+			expProto:  "",
+			expScheme: "",
+		},
+
+		"Measuring the protocol of an HTTP/1.1 request should measure it with the protocol and scheme.": {
+			config:    middleware.Config{MeasureProtocol: true},
+			req:       func() *http.Request { return httptest.NewRequest(http.MethodGet, "/test", nil) },
+			expProto:  "HTTP/1.1",
+			expScheme: "http",
+		},
+
+		"Measuring the protocol of an HTTP/1.0 TLS request should measure it with the protocol and scheme.": {
+			config: middleware.Config{MeasureProtocol: true},
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/test", nil)
+				r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.0", 1, 0
+				return r
+			},
+			expProto:  "HTTP/1.0",
+			expScheme: "https",
+		},
+
+		"Measuring the protocol of an HTTP/2 TLS request should measure it with the protocol and scheme.": {
+			config: middleware.Config{MeasureProtocol: true},
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/test", nil)
+				r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+				return r
+			},
+			expProto:  "HTTP/2",
+			expScheme: "https",
+		},
+
+		"Measuring the protocol of an HTTP/3 request should measure it with the protocol.": {
+			config: middleware.Config{MeasureProtocol: true},
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/test", nil)
+				r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/3.0", 3, 0
+				return r
+			},
+			expProto:  "HTTP/3",
+			expScheme: "https",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			m := middleware.New(test.config)
+			h := stdmiddleware.Handler("test", m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			h.ServeHTTP(httptest.NewRecorder(), test.req())
+
+			assert.Contains(mrec.Snapshot().RequestDurations, metrics.HTTPReqProperties{
+				ID:     "test",
+				Method: http.MethodGet,
+				Code:   "200",
+				Proto:  test.expProto,
+				Scheme: test.expScheme,
+			})
+		})
+	}
+}
+
+// fakeWriter is a ResponseWriter that implements all the optional interfaces and records
+// their calls.
+type fakeWriter struct {
+	http.ResponseWriter
+	calls []string
+}
+
+func (f *fakeWriter) Flush() { f.calls = append(f.calls, "flush") }
+
+func (f *fakeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	f.calls = append(f.calls, "hijack")
+	return nil, nil, nil
+}
+
+func (f *fakeWriter) ReadFrom(src io.Reader) (int64, error) {
+	f.calls = append(f.calls, "readfrom")
+	return io.Copy(f.ResponseWriter, src)
+}
+
+func (f *fakeWriter) Push(string, *http.PushOptions) error {
+	f.calls = append(f.calls, "push")
+	return nil
+}
+
+func TestMiddlewareResponseWriterInterfaces(t *testing.T) {
+	tests := map[string]struct {
+		writer   func(f *fakeWriter) http.ResponseWriter
+		expCalls []string
+		expSize  float64
+	}{
+		"A writer without optional interfaces should not have them.": {
+			writer:   func(f *fakeWriter) http.ResponseWriter { return struct{ http.ResponseWriter }{f} },
+			expCalls: nil,
+		},
+
+		"A flusher writer should be a flusher.": {
+			writer: func(f *fakeWriter) http.ResponseWriter {
+				return struct {
+					http.ResponseWriter
+					http.Flusher
+				}{f, f}
+			},
+			expCalls: []string{"flush"},
+		},
+
+		"A hijacker writer should be a hijacker.": {
+			writer: func(f *fakeWriter) http.ResponseWriter {
+				return struct {
+					http.ResponseWriter
+					http.Hijacker
+				}{f, f}
+			},
+			expCalls: []string{"hijack"},
+		},
+
+		"A reader from writer should be a reader from and count the bytes.": {
+			writer: func(f *fakeWriter) http.ResponseWriter {
+				return struct {
+					http.ResponseWriter
+					io.ReaderFrom
+				}{f, f}
+			},
+			expCalls: []string{"readfrom"},
+			expSize:  4,
+		},
+
+		"A pusher writer should be a pusher.": {
+			writer: func(f *fakeWriter) http.ResponseWriter {
+				return struct {
+					http.ResponseWriter
+					http.Pusher
+				}{f, f}
+			},
+			expCalls: []string{"push"},
+		},
+
+		"An HTTP/1 like writer should be a flusher, hijacker and reader from.": {
+			writer: func(f *fakeWriter) http.ResponseWriter {
+				return struct {
+					http.ResponseWriter
+					http.Flusher
+					http.Hijacker
+					io.ReaderFrom
+				}{f, f, f, f}
+			},
+			expCalls: []string{"flush", "hijack", "readfrom"},
+			expSize:  4,
+		},
+
+		"An HTTP/2 like writer should be a flusher and pusher.": {
+			writer: func(f *fakeWriter) http.ResponseWriter {
+				return struct {
+					http.ResponseWriter
+					http.Flusher
+					http.Pusher
+				}{f, f, f}
+			},
+			expCalls: []string{"flush", "push"},
+		},
+
+		"A writer with all the optional interfaces should have all of them.": {
+			writer:   func(f *fakeWriter) http.ResponseWriter { return f },
+			expCalls: []string{"flush", "hijack", "readfrom", "push"},
+			expSize:  4,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec})
+
+			fw := &fakeWriter{ResponseWriter: httptest.NewRecorder()}
+			rw := test.writer(fw)
+			h := stdmiddleware.Handler("test", mdlw, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The handler should see the same optional interfaces as the original writer.
+				if f, ok := w.(http.Flusher); assertSameIface[http.Flusher](t, rw, ok) {
+					f.Flush()
+				}
+				if h, ok := w.(http.Hijacker); assertSameIface[http.Hijacker](t, rw, ok) {
+					_, _, _ = h.Hijack()
+				}
+				if rf, ok := w.(io.ReaderFrom); assertSameIface[io.ReaderFrom](t, rw, ok) {
+					_, _ = rf.ReadFrom(strings.NewReader("test"))
+				}
+				if p, ok := w.(http.Pusher); assertSameIface[http.Pusher](t, rw, ok) {
+					_ = p.Push("/static/app.js", nil)
+				}
+
+				u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+				if assert.True(ok) {
+					assert.Equal(rw, u.Unwrap())
+				}
+			}))
+
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			assert.Equal(test.expCalls, fw.calls)
+			assert.Equal(test.expSize, mrec.ResponseSize(memory.Query{ID: "test"}).Sum)
+		})
+	}
+}
+
+// assertSameIface asserts the original writer implements the interface only if the wrapped
+// one implements it, and returns if the wrapped one implements it.
+func assertSameIface[T any](t *testing.T, original http.ResponseWriter, ok bool) bool {
+	_, expOK := original.(T)
+	assert.Equal(t, expOK, ok)
+	return ok
+}
+
+func TestMiddlewareStatusCode(t *testing.T) {
+	tests := map[string]struct {
+		handler http.HandlerFunc
+		expCode string
+	}{
+		"Writing the body without status code should measure a 200.": {
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("test")) },
+			expCode: "200",
+		},
+
+		"Writing multiple status codes should measure the first one.": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expCode: "202",
+		},
+
+		"Writing the status code after the body should measure a 200.": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("test"))
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expCode: "200",
+		},
+
+		"Writing informational responses should measure the final status code.": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusNotFound)
+			},
+			expCode: "404",
+		},
+
+		"Switching protocols should be measured as the final status code.": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusSwitchingProtocols)
+				w.WriteHeader(http.StatusOK)
+			},
+			expCode: "101",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrec := memory.NewRecorder(memory.Config{})
+			mdlw := middleware.New(middleware.Config{Recorder: mrec})
+
+			h := stdmiddleware.Handler("test", mdlw, test.handler)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			assert.Equal(1, mrec.RequestCount(memory.Query{ID: "test", Code: test.expCode}))
+		})
+	}
+}
+
+func TestMiddlewareStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureStreams: true, ExcludeStreamsFromDuration: true})
+	h := stdmiddleware.Handler("events", mdlw, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, event := range []string{"data: 1\n\n", "data: 22\n\n"} {
+			_, _ = io.WriteString(w, event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	q := memory.Query{ID: "events", Code: "200"}
+	assert.Equal(memory.Aggregate{Count: 2, Sum: 19, Min: 9, Max: 10}, mrec.StreamFlushSize(q))
+	assert.Equal(1, mrec.StreamDuration(q).Count)
+	assert.Equal(0, mrec.RequestCount(q))
+}
+
+func TestMiddlewareHijackedConnections(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	const upgradeResp = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureHijackedConnections: true})
+	h := stdmiddleware.Handler("echo", mdlw, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Upgrade the connection and echo the data until the client closes it.
+		_, _ = brw.WriteString(upgradeResp)
+		_ = brw.Flush()
+		_, _ = io.Copy(conn, brw)
+	}))
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(err)
+	_, err = io.WriteString(conn, "GET /echo HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.NoError(err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(err)
+	require.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(err)
+	msg := make([]byte, 5)
+	_, err = io.ReadFull(br, msg)
+	require.NoError(err)
+	assert.Equal("hello", string(msg))
+
+	// The connection is open.
+	assert.Equal(1, mrec.HijackedConnections(metrics.HTTPProperties{ID: "echo"}))
+
+	require.NoError(conn.Close())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow("timeout waiting for the handler")
+	}
+
+	q := memory.Query{ID: "echo", Method: http.MethodGet, Code: "101"}
+	assert.Equal(0, mrec.HijackedConnections(metrics.HTTPProperties{ID: "echo"}))
+	assert.Equal(1, mrec.HijackedConnDuration(q).Count)
+	assert.Equal(float64(len("hello")), mrec.HijackedConnBytesRead(q).Sum)
+	assert.Equal(float64(len(upgradeResp)+len("hello")), mrec.HijackedConnBytesWritten(q).Sum)
+	assert.Equal(1, mrec.RequestCount(q))
+}
+
+func TestMiddlewareHijackedWebsocketConnections(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mrec := memory.NewRecorder(memory.Config{})
+	mdlw := middleware.New(middleware.Config{Recorder: mrec, MeasureHijackedConnections: true})
+	upgrader := websocket.Upgrader{}
+	h := stdmiddleware.Handler("ws", mdlw, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		// Echo the messages until the client closes the connection.
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = c.WriteMessage(mt, msg)
+		}
+	}))
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(err)
+	require.NoError(c.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, msg, err := c.ReadMessage()
+	require.NoError(err)
+	assert.Equal("hello", string(msg))
+
+	// The connection is open.
+	assert.Equal(1, mrec.HijackedConnections(metrics.HTTPProperties{ID: "ws"}))
+
+	require.NoError(c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	require.NoError(c.Close())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow("timeout waiting for the handler")
+	}
+
+	q := memory.Query{ID: "ws", Method: http.MethodGet, Code: "101"}
+	assert.Equal(0, mrec.HijackedConnections(metrics.HTTPProperties{ID: "ws"}))
+	assert.Equal(1, mrec.HijackedConnDuration(q).Count)
+	assert.Greater(mrec.HijackedConnBytesRead(q).Sum, float64(0))
+	assert.Greater(mrec.HijackedConnBytesWritten(q).Sum, float64(0))
+	assert.Equal(1, mrec.RequestCount(q))
+}
@@ -0,0 +1,113 @@
+package middleware_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mockmiddleware "github.com/slok/go-http-metrics/internal/mocks/middleware"
+	"github.com/slok/go-http-metrics/metrics/memory"
+	"github.com/slok/go-http-metrics/middleware"
+)
+
+type streamReporter struct {
+	*mockmiddleware.Reporter
+	written int64
+	onFlush func()
+}
+
+func (r *streamReporter) BytesWritten() int64 { return r.written }
+
+func (r *streamReporter) OnFlush(f func()) { r.onFlush = f }
+
+// flush writes the bytes on the response and flushes them.
+func (r *streamReporter) flush(bytes int64) {
+	r.written += bytes
+	if r.onFlush != nil {
+		r.onFlush()
+	}
+}
+
+func TestMiddlewareMeasureStreams(t *testing.T) {
+	tests := map[string]struct {
+		config         middleware.Config
+		flushes        []int64
+		expFlushSize   memory.Aggregate
+		expStreams     int
+		expRequests    int
+		expResponseSum float64
+	}{
+		"Not enabling the streams measuring, it shouldn't measure the streams.": {
+			config:         middleware.Config{},
+			flushes:        []int64{10, 20},
+			expRequests:    1,
+			expResponseSum: 30,
+		},
+
+		"A response without flushes shouldn't be measured as a stream.": {
+			config:      middleware.Config{MeasureStreams: true},
+			expRequests: 1,
+		},
+
+		"A flushed response should measure the flushes and the stream duration.": {
+			config:         middleware.Config{MeasureStreams: true},
+			flushes:        []int64{10, 20, 0},
+			expFlushSize:   memory.Aggregate{Count: 3, Sum: 30, Min: 0, Max: 20},
+			expStreams:     1,
+			expRequests:    1,
+			expResponseSum: 30,
+		},
+
+		"A flushed response excluded from the duration should measure the stream duration only.": {
+			config:         middleware.Config{MeasureStreams: true, ExcludeStreamsFromDuration: true},
+			flushes:        []int64{10, 20},
+			expFlushSize:   memory.Aggregate{Count: 2, Sum: 30, Min: 10, Max: 20},
+			expStreams:     1,
+			expRequests:    0,
+			expResponseSum: 30,
+		},
+
+		"A response without flushes excluded from the duration should be measured on the request duration.": {
+			config:      middleware.Config{MeasureStreams: true, ExcludeStreamsFromDuration: true},
+			expRequests: 1,
+		},
+
+		"A skipped flushed response shouldn't be measured.": {
+			config: middleware.Config{
+				MeasureStreams: true,
+				Skipper:        func(middleware.SkipRequest) bool { return true },
+			},
+			flushes: []int64{10},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mrep := &mockmiddleware.Reporter{}
+			mrep.On("Context").Return(context.TODO())
+			mrep.On("StatusCode").Return(200)
+			mrep.On("Method").Return("GET")
+			mrep.On("URLPath").Return("/events")
+			rep := &streamReporter{Reporter: mrep}
+
+			mrec := memory.NewRecorder(memory.Config{})
+			test.config.Recorder = mrec
+			mdlw := middleware.New(test.config)
+			mdlw.Measure("events", rep, func() {
+				for _, bytes := range test.flushes {
+					rep.flush(bytes)
+				}
+			})
+
+			q := memory.Query{ID: "events", Method: "GET", Code: "200"}
+			assert.Equal(test.expFlushSize, mrec.StreamFlushSize(q))
+			assert.Equal(test.expFlushSize.Count, mrec.StreamFlushInterval(q).Count)
+			assert.Equal(test.expStreams, mrec.StreamDuration(q).Count)
+			assert.Equal(test.expRequests, mrec.RequestCount(q))
+			assert.Equal(test.expResponseSum, mrec.ResponseSize(q).Sum)
+		})
+	}
+}
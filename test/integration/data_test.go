@@ -1,6 +1,9 @@
 package integration
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 var (
 	expReqs = []handlerConfig{
@@ -85,5 +88,28 @@ var (
 		`http_response_size_bytes_bucket{code="205",handler="/test/4",method="DELETE",service="integration",le="+Inf"} 7`,
 		`http_response_size_bytes_sum{code="205",handler="/test/4",method="DELETE",service="integration"} 28`,
 		`http_response_size_bytes_count{code="205",handler="/test/4",method="DELETE",service="integration"} 7`,
+
+		`# HELP http_request_size_bytes The size of the HTTP requests.`,
+		`# TYPE http_request_size_bytes histogram`,
+		`http_request_size_bytes_sum{code="201",handler="/test/1",method="GET",service="integration"} 0`,
+		`http_request_size_bytes_count{code="201",handler="/test/1",method="GET",service="integration"} 10`,
+		`http_request_size_bytes_sum{code="202",handler="/test/2",method="POST",service="integration"} 0`,
+		`http_request_size_bytes_count{code="202",handler="/test/2",method="POST",service="integration"} 9`,
+		`http_request_size_bytes_sum{code="203",handler="/test/3",method="PATCH",service="integration"} 0`,
+		`http_request_size_bytes_count{code="203",handler="/test/3",method="PATCH",service="integration"} 8`,
+		`http_request_size_bytes_sum{code="205",handler="/test/4",method="DELETE",service="integration"} 0`,
+		`http_request_size_bytes_count{code="205",handler="/test/4",method="DELETE",service="integration"} 7`,
 	}
 )
+
+// expRoutedAfterMeasureMetrics are the expected metrics of the middlewares that route the requests
+// after they start measuring, the inflight requests are measured with the fallback handler ID.
+var expRoutedAfterMeasureMetrics = func() []string {
+	res := []string{`http_requests_inflight{handler="unmatched",service="integration"} 0`}
+	for _, m := range expMetrics {
+		if !strings.HasPrefix(m, "http_requests_inflight{") {
+			res = append(res, m)
+		}
+	}
+	return res
+}()
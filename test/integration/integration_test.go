@@ -28,11 +28,13 @@ import (
 
 	metricsprometheus "github.com/slok/go-http-metrics/metrics/prometheus"
 	"github.com/slok/go-http-metrics/middleware"
+	chimiddleware "github.com/slok/go-http-metrics/middleware/chi"
 	echomiddleware "github.com/slok/go-http-metrics/middleware/echo"
 	fasthttpmiddleware "github.com/slok/go-http-metrics/middleware/fasthttp"
 	ginmiddleware "github.com/slok/go-http-metrics/middleware/gin"
 	gojimiddleware "github.com/slok/go-http-metrics/middleware/goji"
 	gorestfulmiddleware "github.com/slok/go-http-metrics/middleware/gorestful"
+	gorillamiddleware "github.com/slok/go-http-metrics/middleware/gorilla"
 	httproutermiddleware "github.com/slok/go-http-metrics/middleware/httprouter"
 	irismiddleware "github.com/slok/go-http-metrics/middleware/iris"
 	negronimiddleware "github.com/slok/go-http-metrics/middleware/negroni"
@@ -71,7 +73,8 @@ type handlerConfig struct {
 
 func TestMiddlewarePrometheus(t *testing.T) {
 	tests := map[string]struct {
-		server func(m middleware.Middleware, hc []handlerConfig) server
+		server     func(m middleware.Middleware, hc []handlerConfig) server
+		expMetrics []string
 	}{
 		"STD http.Handler": {server: prepareHandlerSTD},
 		"Negroni":          {server: prepareHandlerNegroni},
@@ -83,6 +86,8 @@ func TestMiddlewarePrometheus(t *testing.T) {
 		"Chi":              {server: prepareHandlerChi},
 		"Alice":            {server: prepareHandlerAlice},
 		"Gorilla":          {server: prepareHandlerGorilla},
+		"Chi adapter":      {server: prepareHandlerChiAdapter, expMetrics: expRoutedAfterMeasureMetrics},
+		"Gorilla adapter":  {server: prepareHandlerGorillaAdapter},
 		"Fasthttp":         {server: prepareHandlerFastHTTP},
 		"Iris":             {server: prepareHandlerIris},
 	}
@@ -97,8 +102,9 @@ func TestMiddlewarePrometheus(t *testing.T) {
 				SizeBuckets:     []float64{1, 2, 3, 4, 5},
 			})
 			mdlw := middleware.New(middleware.Config{
-				Service:  "integration",
-				Recorder: rec,
+				Service:            "integration",
+				Recorder:           rec,
+				MeasureRequestSize: true,
 			})
 
 			server := test.server(mdlw, expReqs)
@@ -106,6 +112,10 @@ func TestMiddlewarePrometheus(t *testing.T) {
 
 			// Test.
 			testMiddlewareRequests(t, server, expReqs)
+			expMetrics := expMetrics
+			if test.expMetrics != nil {
+				expMetrics = test.expMetrics
+			}
 			testMiddlewarePrometheusMetrics(t, metricsHandler, expMetrics)
 		})
 	}
@@ -317,6 +327,24 @@ func prepareHandlerChi(m middleware.Middleware, hc []handlerConfig) server {
 	return testServer{server: httptest.NewServer(mux)}
 }
 
+func prepareHandlerChiAdapter(m middleware.Middleware, hc []handlerConfig) server {
+	// Setup server and middleware.
+	mux := chi.NewMux()
+	mux.Use(chimiddleware.Handler("", m))
+
+	// Setup handlers.
+	for _, h := range hc {
+		h := h
+		mux.Method(h.Method, h.Path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(h.SleepDuration)
+			w.WriteHeader(h.Code)
+			w.Write([]byte(h.ReturnData)) // nolint: errcheck
+		}))
+	}
+
+	return testServer{server: httptest.NewServer(mux)}
+}
+
 func prepareHandlerAlice(m middleware.Middleware, hc []handlerConfig) server {
 	// Setup handlers.
 	mux := http.NewServeMux()
@@ -360,6 +388,26 @@ func prepareHandlerGorilla(m middleware.Middleware, hc []handlerConfig) server {
 	return testServer{server: httptest.NewServer(r)}
 }
 
+func prepareHandlerGorillaAdapter(m middleware.Middleware, hc []handlerConfig) server {
+	// Setup handlers.
+	r := mux.NewRouter()
+	for _, h := range hc {
+		h := h
+		r.Methods(h.Method).
+			Path(h.Path).
+			HandlerFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(h.SleepDuration)
+				w.WriteHeader(h.Code)
+				w.Write([]byte(h.ReturnData)) // nolint: errcheck
+			}))
+	}
+
+	// Setup middleware.
+	r.Use(gorillamiddleware.Handler("", m))
+
+	return testServer{server: httptest.NewServer(r)}
+}
+
 func prepareHandlerFastHTTP(m middleware.Middleware, hc []handlerConfig) server {
 	// Setup handlers.
 	r := fasthttprouter.New()